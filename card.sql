@@ -4,4 +4,60 @@ CREATE TABLE cards (
     id SERIAL PRIMARY KEY,
     word TEXT NOT NULL,
     meaning TEXT NOT NULL
-);
\ No newline at end of file
+);
+
+-- Reviews learners record for cards, grade runs from 0 (forgot it) to 5 (perfect recall)
+CREATE TABLE reviews (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    card_id BIGINT UNSIGNED NOT NULL,
+    user_id INT NULL,
+    grade TINYINT NOT NULL,
+    reviewed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_reviews_card (card_id),
+    FOREIGN KEY (card_id) REFERENCES cards(id) ON DELETE CASCADE
+);
+
+-- Domain events written in the same transaction as the card or review change, published by the outbox relay
+CREATE TABLE outbox (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    topic VARCHAR(255) NOT NULL,
+    payload BLOB NOT NULL,
+    -- W3C traceparent of the request that made the change, the relay publishes in that trace
+    trace_parent VARCHAR(55) NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP NULL,
+    -- Set once a row has failed too often, dead rows are skipped and never pruned
+    dead_at TIMESTAMP NULL,
+    INDEX idx_outbox_pending (sent_at, dead_at, id)
+);
+
+-- Registered webhook endpoints, events holds comma separated filters such as card.* or card.created.
+-- A webhook belongs to the user who registered it and is only sent that user's events.
+CREATE TABLE webhooks (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    url VARCHAR(2048) NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    events VARCHAR(1024) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_webhooks_user (user_id)
+);
+
+-- One row per event and webhook, the delivery log; rows that ran out of attempts are the dead letters
+CREATE TABLE webhook_deliveries (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    webhook_id BIGINT NOT NULL,
+    event_type VARCHAR(255) NOT NULL,
+    payload BLOB NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_status_code INT NULL,
+    last_error TEXT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP NULL,
+    INDEX idx_webhook_deliveries_due (status, next_attempt_at),
+    INDEX idx_webhook_deliveries_log (webhook_id, id),
+    FOREIGN KEY (webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+);
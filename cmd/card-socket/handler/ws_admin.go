@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Connection describes a client connected to this instance, as listed on the admin server
+type Connection struct {
+	UserID      int       `json:"user_id"`
+	Transport   string    `json:"transport"`
+	ConnectedAt time.Time `json:"connected_at"`
+	LastSeen    time.Time `json:"last_seen"`
+	Queued      int       `json:"queued"`
+	Dropped     int64     `json:"dropped"`
+	Rooms       []string  `json:"rooms"`
+}
+
+// Connections lists the clients connected to this instance by user id, with the rooms they are in here
+func (server *WebSocketServer) Connections() []Connection {
+	server.mutex.RLock()
+	defer server.mutex.RUnlock()
+
+	rooms := make(map[int][]string)
+	for room, members := range server.rooms {
+		for userId := range members {
+			rooms[userId] = append(rooms[userId], room)
+		}
+	}
+	connections := make([]Connection, 0, len(server.clients))
+	for userId, c := range server.clients {
+		sort.Strings(rooms[userId])
+		connections = append(connections, Connection{
+			UserID:      userId,
+			Transport:   c.transport(),
+			ConnectedAt: c.connectedAt,
+			LastSeen:    c.idleSince(),
+			Queued:      len(c.send),
+			Dropped:     c.dropped.Load(),
+			Rooms:       append([]string{}, rooms[userId]...),
+		})
+	}
+	sort.Slice(connections, func(i, j int) bool { return connections[i].UserID < connections[j].UserID })
+	return connections
+}
+
+// Disconnect closes the connection of a user to this instance and reports whether they were connected.
+// Their rooms are kept for the grace period, as after any disconnect, so the client may reconnect.
+func (server *WebSocketServer) Disconnect(userId int) bool {
+	server.mutex.RLock()
+	c, ok := server.clients[userId]
+	server.mutex.RUnlock()
+	if !ok {
+		return false
+	}
+	server.unregister(c)
+	return true
+}
+
+// GetConnections lists the clients connected to this instance, for the admin server
+func (server *WebSocketServer) GetConnections(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(server.Connections())
+}
+
+// DisconnectUser closes the connection of the user in the path, for the admin server
+func (server *WebSocketServer) DisconnectUser(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.Atoi(mux.Vars(r)["user"])
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+	if !server.Disconnect(userId) {
+		http.Error(w, "User not connected", http.StatusNotFound)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "User disconnected by an operator", "user", userId)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminListsAndDisconnectsConnections(t *testing.T) {
+	server, ts := newTestServer(t, DefaultOptions())
+	conn := dial(t, server, ts, 2)
+	dial(t, server, ts, 1)
+	require.NoError(t, server.joinRoom(2, "spanish"))
+	require.NoError(t, server.joinRoom(2, "german"))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/connections", server.GetConnections).Methods("GET")
+	router.HandleFunc("/connections/{user}", server.DisconnectUser).Methods("DELETE")
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/connections", nil))
+	var connections []Connection
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &connections))
+	require.Len(t, connections, 2)
+	assert.Equal(t, 1, connections[0].UserID)
+	assert.Empty(t, connections[0].Rooms)
+	assert.Equal(t, 2, connections[1].UserID)
+	assert.Equal(t, "websocket", connections[1].Transport)
+	assert.Equal(t, []string{"german", "spanish"}, connections[1].Rooms)
+	assert.WithinDuration(t, time.Now(), connections[1].ConnectedAt, time.Minute)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/connections/2", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.False(t, server.hasClient(2))
+	assert.True(t, server.hasClient(1))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := conn.ReadMessage()
+	assert.Error(t, err, "the connection is closed")
+
+	for path, code := range map[string]int{"/connections/2": http.StatusNotFound, "/connections/x": http.StatusBadRequest} {
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, path, nil))
+		assert.Equal(t, code, rec.Code, path)
+	}
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/cupv/mux/pkg/broker"
+	"github.com/gorilla/mux"
+)
+
+// Health is the state reported by the health endpoint
+type Health struct {
+	Status string        `json:"status"`
+	Broker broker.Status `json:"broker"`
+}
+
+// GetHealth reports whether the broker is reachable, answering 503 while it is not
+func (server *WebSocketServer) GetHealth(w http.ResponseWriter, r *http.Request) {
+	health := Health{Status: "ok", Broker: server.broker.Status()}
+	code := http.StatusOK
+	if !health.Broker.Connected {
+		health.Status = "degraded"
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(health)
+}
+
+// GetRooms lists every room with its member count
+func (server *WebSocketServer) GetRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := server.ListRooms()
+	if err != nil {
+		http.Error(w, "Failed to retrieve rooms", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rooms)
+}
+
+// GetRoomMembers lists the user ids that belong to a room
+func (server *WebSocketServer) GetRoomMembers(w http.ResponseWriter, r *http.Request) {
+	room := mux.Vars(r)["room"]
+
+	members, err := server.RoomMembers(room)
+	if err != nil {
+		http.Error(w, "Failed to retrieve room members", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// GetReceipts lists the delivery and read state of a message per recipient
+func (server *WebSocketServer) GetReceipts(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid message id", http.StatusBadRequest)
+		return
+	}
+
+	receipts, err := server.stores.Receipts.Receipts(server.ctx, id)
+	if errors.Is(err, ErrUnknownMessage) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve receipts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(receipts)
+}
+
+// GetPresence reports the presence of the users named by user_id query parameters, or of everyone online
+func (server *WebSocketServer) GetPresence(w http.ResponseWriter, r *http.Request) {
+	var userIds []int
+	for _, value := range r.URL.Query()["user_id"] {
+		userId, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid user id", http.StatusBadRequest)
+			return
+		}
+		userIds = append(userIds, userId)
+	}
+
+	var presences []Presence
+	var err error
+	if len(userIds) > 0 {
+		presences, err = server.stores.Presence.Get(server.ctx, userIds)
+	} else {
+		presences, err = server.stores.Presence.List(server.ctx)
+	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve presence", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(presences)
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cupv/mux/pkg/broker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyBroker is an in-process broker whose backend can be taken down
+type flakyBroker struct {
+	*broker.Memory
+	down atomic.Bool
+}
+
+func (b *flakyBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	if b.down.Load() {
+		return fmt.Errorf("%w: connection refused", broker.ErrUnavailable)
+	}
+	return b.Memory.Publish(ctx, topic, payload)
+}
+
+func (b *flakyBroker) Status() broker.Status {
+	return broker.Status{Connected: !b.down.Load()}
+}
+
+func TestHealthReportsBrokerOutage(t *testing.T) {
+	b := &flakyBroker{Memory: broker.NewMemory()}
+	t.Cleanup(func() { b.Close() })
+	server := NewWebSocketServer(b, NewMemoryStores(DefaultOptions()), DefaultOptions())
+	require.NoError(t, server.Start())
+	ts := httptest.NewServer(http.HandlerFunc(server.HandleConnections))
+	t.Cleanup(ts.Close)
+
+	health := func() (int, Health) {
+		rec := httptest.NewRecorder()
+		server.GetHealth(rec, httptest.NewRequest("GET", "/health", nil))
+		var body Health
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+		return rec.Code, body
+	}
+
+	code, body := health()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", body.Status)
+
+	b.down.Store(true)
+	code, body = health()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "degraded", body.Status)
+	assert.False(t, body.Broker.Connected)
+
+	// Publishes fail explicitly instead of disappearing
+	alice := connect(t, ts, 1)
+	require.Eventually(t, func() bool { return server.hasClient(1) }, time.Second, 5*time.Millisecond)
+	send(t, alice, MessageTypeBroadcast, broadcastPayload{Content: "anyone?"})
+	assert.Equal(t, ErrCodeUnavailable, readError(t, alice).Code)
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strconv"
+
+	"github.com/cupv/mux/internal/domain"
+	"github.com/cupv/mux/pkg/broker"
+)
+
+// CardsRoom is the room clients join to follow the card changes a user makes through the card API.
+// Cards belong to no deck yet, so changes are scoped by the user who made them.
+func CardsRoom(userId int) string {
+	return "cards:" + strconv.Itoa(userId)
+}
+
+// onCardEvent relays a card event to the local members of its user's cards room, the frame payload is the card
+func (server *WebSocketServer) onCardEvent(msg broker.Message) {
+	var event domain.CardEvent
+	if err := json.Unmarshal(msg.Payload, &event); err != nil {
+		slog.WarnContext(msg.Context(), "Invalid card event", "error", err)
+		return
+	}
+	switch event.Type {
+	case domain.CardCreated, domain.CardUpdated, domain.CardDeleted:
+	default:
+		slog.WarnContext(msg.Context(), "Unknown card event", "type", event.Type)
+		return
+	}
+	// Changes made without a user have no room to go to
+	if event.UserID == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(event.Card)
+	if err != nil {
+		return
+	}
+	frame, err := json.Marshal(Envelope{Type: event.Type, TS: event.OccurredAt.UnixMilli(), Payload: payload})
+	if err != nil {
+		return
+	}
+	server.deliverFrame(CardsRoom(event.UserID), 0, 0, frame)
+}
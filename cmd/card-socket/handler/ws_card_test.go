@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cupv/mux/internal/domain"
+	"github.com/cupv/mux/pkg/broker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCardEventsReachTheUsersCardsRoom(t *testing.T) {
+	b := broker.NewMemory()
+	t.Cleanup(func() { b.Close() })
+	server := NewWebSocketServer(b, NewMemoryStores(DefaultOptions()), DefaultOptions())
+	require.NoError(t, server.Start())
+	ts := httptest.NewServer(http.HandlerFunc(server.HandleConnections))
+	t.Cleanup(ts.Close)
+
+	// Alice follows user 7's changes, Bob follows user 8's
+	alice := connect(t, ts, 1)
+	bob := connect(t, ts, 2)
+	send(t, alice, MessageTypeJoin, roomPayload{Room: CardsRoom(7)})
+	send(t, bob, MessageTypeJoin, roomPayload{Room: CardsRoom(8)})
+	require.Eventually(t, func() bool {
+		return server.isMember(CardsRoom(7), 1) && server.isMember(CardsRoom(8), 2)
+	}, time.Second, 5*time.Millisecond)
+
+	// The card API's outbox relay publishes through the same broker
+	card := domain.Card{ID: 3, Word: "hund", Meaning: "dog"}
+	for _, userId := range []int{0, 7} {
+		event, err := json.Marshal(domain.CardEvent{Type: domain.CardUpdated, Card: card, UserID: userId, OccurredAt: time.Now()})
+		require.NoError(t, err)
+		require.NoError(t, b.Publish(context.Background(), domain.CardEventsTopic, event))
+	}
+
+	env := readEnvelope(t, alice)
+	assert.Equal(t, domain.CardUpdated, env.Type)
+	var got domain.Card
+	require.NoError(t, json.Unmarshal(env.Payload, &got))
+	assert.Equal(t, card, got)
+
+	bob.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err := bob.ReadMessage()
+	assert.Error(t, err)
+}
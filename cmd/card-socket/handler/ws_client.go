@@ -0,0 +1,277 @@
+package handler
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// client is a single connection with its own outbound queue, WebSocket clients also own a writer goroutine
+// while Server-Sent Events and long-polling clients are drained by their HTTP handlers
+type client struct {
+	userId    int
+	conn      *websocket.Conn // nil for HTTP transports
+	polling   bool            // set for long-polling sessions
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	policy    OverflowPolicy
+	writeWait time.Duration
+	pingEvery time.Duration
+	dropped   atomic.Int64
+	lastSeen  atomic.Int64
+	// lastActive is when the client last sent a frame, pings and polls do not count
+	lastActive atomic.Int64
+	// away is set while the user chose to be away, idleAway while the server set them away for being idle
+	away     atomic.Bool
+	idleAway atomic.Bool
+	// connectedAt is when the client connected, for the admin connection list
+	connectedAt time.Time
+
+	// leaving is closed when the server shuts down, the client flushes its queue and is told when to reconnect
+	leaving        chan struct{}
+	leaveOnce      sync.Once
+	reconnectAfter time.Duration
+
+	// While holding, live messages wait in held until a replay finishes
+	holdMutex sync.Mutex
+	holding   bool
+	held      []heldMessage
+}
+
+// heldMessage is a live message kept back during a replay, id is zero for unsequenced messages
+type heldMessage struct {
+	id      int64
+	payload []byte
+}
+
+// newClient wraps a connection and starts its writer goroutine
+func newClient(conn *websocket.Conn, userId int, opts Options) *client {
+	c := &client{
+		userId:         userId,
+		conn:           conn,
+		send:           make(chan []byte, opts.QueueSize),
+		done:           make(chan struct{}),
+		policy:         opts.OverflowPolicy,
+		writeWait:      opts.WriteTimeout,
+		pingEvery:      opts.PingInterval,
+		leaving:        make(chan struct{}),
+		reconnectAfter: opts.ReconnectDelay,
+		connectedAt:    time.Now(),
+	}
+	c.touch()
+	c.active()
+	c.configureReads(opts)
+	go c.writePump()
+	return c
+}
+
+// newStreamClient creates a client without a connection whose queue is drained by an HTTP handler
+func newStreamClient(userId int, opts Options) *client {
+	c := &client{
+		userId:         userId,
+		send:           make(chan []byte, opts.QueueSize),
+		done:           make(chan struct{}),
+		policy:         opts.OverflowPolicy,
+		writeWait:      opts.WriteTimeout,
+		pingEvery:      opts.PingInterval,
+		leaving:        make(chan struct{}),
+		reconnectAfter: opts.ReconnectDelay,
+		connectedAt:    time.Now(),
+	}
+	c.touch()
+	c.active()
+	return c
+}
+
+// configureReads limits frame size and makes reads fail once the client goes quiet for too long
+func (c *client) configureReads(opts Options) {
+	c.conn.SetReadLimit(opts.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(opts.PongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.touch()
+		return c.conn.SetReadDeadline(time.Now().Add(opts.PongWait))
+	})
+}
+
+// touch records that the client was heard from
+func (c *client) touch() {
+	c.lastSeen.Store(time.Now().UnixNano())
+}
+
+// active records that the client sent a frame
+func (c *client) active() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+// activeSince returns when the client last sent a frame
+func (c *client) activeSince() time.Time {
+	return time.Unix(0, c.lastActive.Load())
+}
+
+// idleSince returns when the client was last heard from
+func (c *client) idleSince() time.Time {
+	return time.Unix(0, c.lastSeen.Load())
+}
+
+// enqueue queues a message without blocking and applies the overflow policy when the queue is full
+func (c *client) enqueue(message []byte) bool {
+	for {
+		select {
+		case <-c.done:
+			messagesDropped.With(dropDisconnected).Inc()
+			return false
+		case c.send <- message:
+			return true
+		default:
+		}
+
+		if c.policy == DisconnectSlow {
+			slowDisconnects.Inc()
+			messagesDropped.With(dropQueueFull).Inc()
+			c.close()
+			return false
+		}
+
+		// Make room by dropping the oldest queued message, then retry
+		select {
+		case <-c.send:
+			c.dropped.Add(1)
+			messagesDropped.With(dropQueueFull).Inc()
+		default:
+		}
+	}
+}
+
+// hold makes live messages wait until release is called
+func (c *client) hold() {
+	c.holdMutex.Lock()
+	c.holding = true
+	c.holdMutex.Unlock()
+}
+
+// deliver queues a live message, or keeps it back while a replay is running
+func (c *client) deliver(id int64, payload []byte) {
+	c.holdMutex.Lock()
+	defer c.holdMutex.Unlock()
+
+	if !c.holding {
+		c.enqueue(payload)
+		return
+	}
+	if len(c.held) >= cap(c.send) {
+		if c.policy == DisconnectSlow {
+			slowDisconnects.Inc()
+			messagesDropped.With(dropQueueFull).Inc()
+			messagesDropped.With(dropDisconnected).Add(float64(len(c.held)))
+			c.held = nil
+			c.close()
+			return
+		}
+		c.held = c.held[1:]
+		c.dropped.Add(1)
+		messagesDropped.With(dropQueueFull).Inc()
+	}
+	c.held = append(c.held, heldMessage{id, payload})
+}
+
+// release queues the messages held back during a replay, skipping those the replay already sent
+func (c *client) release(replayedId int64) {
+	c.holdMutex.Lock()
+	defer c.holdMutex.Unlock()
+
+	for _, msg := range c.held {
+		if msg.id == 0 || msg.id > replayedId {
+			c.enqueue(msg.payload)
+		}
+	}
+	c.held = nil
+	c.holding = false
+}
+
+// writePump writes queued messages and pings to the connection until the client is closed
+func (c *client) writePump() {
+	ticker := time.NewTicker(c.pingEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			deadline := time.Now().Add(c.writeWait)
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				c.close()
+				return
+			}
+		case <-c.leaving:
+			c.flush()
+			c.close()
+			return
+		case message := <-c.send:
+			if err := c.write(message); err != nil {
+				messagesDropped.With(dropDisconnected).Inc()
+				c.close()
+				return
+			}
+			messagesOut.Inc()
+		}
+	}
+}
+
+// write sends one frame to the connection within the write timeout
+func (c *client) write(message []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.writeWait))
+	return c.conn.WriteMessage(websocket.TextMessage, message)
+}
+
+// flush writes whatever is still queued, then sends a going away close frame with the reconnect hint
+func (c *client) flush() {
+	messages := c.pending()
+	for i, message := range messages {
+		if err := c.write(message); err != nil {
+			messagesDropped.With(dropDisconnected).Add(float64(len(messages) - i))
+			return
+		}
+		messagesOut.Inc()
+	}
+
+	reason := fmt.Sprintf(`{"reconnect_after":%d}`, c.reconnectAfter.Milliseconds())
+	deadline := time.Now().Add(c.writeWait)
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, reason), deadline)
+}
+
+// pending takes every queued message without waiting for more
+func (c *client) pending() [][]byte {
+	var messages [][]byte
+	for {
+		select {
+		case message := <-c.send:
+			messages = append(messages, message)
+		default:
+			return messages
+		}
+	}
+}
+
+// goAway asks the client to flush its queue and disconnect, used when the server drains
+func (c *client) goAway() {
+	c.leaveOnce.Do(func() {
+		close(c.leaving)
+	})
+}
+
+// close stops the writer and closes the connection, which also ends the reader
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if c.conn != nil {
+			c.conn.Close()
+		}
+		// Whatever is still queued will never be written
+		messagesDropped.With(dropDisconnected).Add(float64(len(c.send)))
+	})
+}
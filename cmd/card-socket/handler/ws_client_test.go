@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cupv/mux/pkg/broker"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer serves the fan-out path of a WebSocketServer backed by the in-process broker
+func newTestServer(t *testing.T, opts Options) (*WebSocketServer, *httptest.Server) {
+	b := broker.NewMemory()
+	t.Cleanup(func() { b.Close() })
+	server := NewWebSocketServer(b, NewMemoryStores(opts), opts)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := server.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		userId, _ := strconv.Atoi(r.Header.Get("X-User-Id"))
+		c := newClient(conn, userId, server.options)
+		server.register(c)
+		go func() {
+			defer server.unregister(c)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+	}))
+	t.Cleanup(ts.Close)
+	return server, ts
+}
+
+// dial connects a test client and waits until the server has registered it
+func dial(t *testing.T, server *WebSocketServer, ts *httptest.Server, userId int) *websocket.Conn {
+	header := http.Header{}
+	header.Set("X-User-Id", strconv.Itoa(userId))
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), header)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	require.Eventually(t, func() bool { return server.hasClient(userId) }, time.Second, 5*time.Millisecond)
+	return conn
+}
+
+func (server *WebSocketServer) hasClient(userId int) bool {
+	server.mutex.RLock()
+	defer server.mutex.RUnlock()
+	_, ok := server.clients[userId]
+	return ok
+}
+
+// withTimeout fails the test when fn does not return in time, which is how a deadlock shows up
+func withTimeout(t *testing.T, d time.Duration, fn func()) {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatal("operation did not finish in time, fan-out is blocked")
+	}
+}
+
+func TestFanOutDoesNotWaitForSlowClient(t *testing.T) {
+	opts := DefaultOptions()
+	opts.QueueSize = 4
+	server, ts := newTestServer(t, opts)
+
+	fast := dial(t, server, ts, 1)
+	dial(t, server, ts, 2) // never reads
+
+	payload := strings.Repeat("x", 4096)
+	withTimeout(t, 2*time.Second, func() {
+		for i := 0; i < 1000; i++ {
+			server.fanOut([]byte(fmt.Sprintf("%d:%s", i, payload)))
+		}
+	})
+
+	// Dropping the oldest messages keeps the latest one queued for the fast client
+	fast.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, message, err := fast.ReadMessage()
+		require.NoError(t, err)
+		if strings.HasPrefix(string(message), "999:") {
+			break
+		}
+	}
+}
+
+func TestFanOutDisconnectsSlowConsumer(t *testing.T) {
+	opts := DefaultOptions()
+	opts.QueueSize = 1
+	opts.OverflowPolicy = DisconnectSlow
+	server, ts := newTestServer(t, opts)
+
+	dial(t, server, ts, 1) // never reads
+
+	payload := []byte(strings.Repeat("x", 4096))
+	withTimeout(t, 2*time.Second, func() {
+		for i := 0; i < 1000 && server.hasClient(1); i++ {
+			server.fanOut(payload)
+		}
+	})
+
+	assert.Eventually(t, func() bool { return !server.hasClient(1) }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestFailedWriteDoesNotDeadlock(t *testing.T) {
+	server, ts := newTestServer(t, DefaultOptions())
+	go server.localBroadcast()
+	defer close(server.broadcast)
+
+	broken := dial(t, server, ts, 1)
+	broken.Close()
+
+	withTimeout(t, 2*time.Second, func() {
+		for i := 0; i < 10; i++ {
+			server.broadcast <- []byte("hello")
+		}
+	})
+
+	// Registering takes the write lock, so it only succeeds if no read lock was leaked
+	withTimeout(t, 2*time.Second, func() {
+		dial(t, server, ts, 2)
+	})
+	assert.Eventually(t, func() bool { return !server.hasClient(1) }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestConcurrentFanOutAndRegistration(t *testing.T) {
+	server, ts := newTestServer(t, DefaultOptions())
+
+	var wg sync.WaitGroup
+	for userId := 1; userId <= 20; userId++ {
+		wg.Add(1)
+		go func(userId int) {
+			defer wg.Done()
+			header := http.Header{}
+			header.Set("X-User-Id", strconv.Itoa(userId))
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), header)
+			if !assert.NoError(t, err) {
+				return
+			}
+			if userId%2 == 0 {
+				conn.Close()
+			} else {
+				t.Cleanup(func() { conn.Close() })
+			}
+		}(userId)
+	}
+
+	withTimeout(t, 5*time.Second, func() {
+		for i := 0; i < 500; i++ {
+			server.fanOut([]byte("tick"))
+		}
+		wg.Wait()
+	})
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strconv"
+
+	"github.com/cupv/mux/pkg/broker"
+)
+
+// directTopic is the broker topic carrying direct messages between instances
+const directTopic = "direct"
+
+// publishDirect stores a direct message in the recipient's history and sends it to every instance
+func (server *WebSocketServer) publishDirect(msg Message) error {
+	recipientId, err := strconv.Atoi(msg.RecipientID)
+	if err != nil {
+		return err
+	}
+
+	id, err := server.stores.History.Append(server.ctx, userHistoryKey(recipientId), msg)
+	if err != nil {
+		return err
+	}
+	msg.ID = id
+	if err := server.stores.Receipts.Track(server.ctx, msg); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return server.broker.Publish(server.ctx, directTopic, payload)
+}
+
+// onDirectMessage delivers a message from the "direct" topic when its recipient is connected here
+func (server *WebSocketServer) onDirectMessage(msg broker.Message) {
+	var message Message
+	if err := json.Unmarshal(msg.Payload, &message); err != nil {
+		slog.WarnContext(msg.Context(), "Invalid direct message", "error", err)
+		return
+	}
+	recipientId, err := strconv.Atoi(message.RecipientID)
+	if err != nil {
+		return
+	}
+
+	frame, err := encodeFrame(message)
+	if err != nil {
+		return
+	}
+
+	server.mutex.RLock()
+	c, ok := server.clients[recipientId]
+	server.mutex.RUnlock()
+	if ok {
+		c.deliver(message.ID, frame)
+	}
+}
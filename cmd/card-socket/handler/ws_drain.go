@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cupv/mux/internal/domain"
+)
+
+// Drain stops accepting new clients and asks every connected client to flush its queue and go away with a reconnect hint.
+// It waits until they have left or ctx is done, closes whoever is left, then unsubscribes from the broker.
+func (server *WebSocketServer) Drain(ctx context.Context) error {
+	server.draining.Store(true)
+	// Games live on their host's instance, so they end with it
+	server.endQuizzes()
+
+	server.mutex.RLock()
+	clients := make([]*client, 0, len(server.clients))
+	for _, c := range server.clients {
+		clients = append(clients, c)
+	}
+	server.mutex.RUnlock()
+
+	for _, c := range clients {
+		c.goAway()
+	}
+
+	var err error
+	for _, c := range clients {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			err = ctx.Err()
+			c.close()
+		}
+	}
+
+	for _, topic := range []string{broadcastTopic, roomTopic, directTopic, receiptTopic, domain.CardEventsTopic, quizTopic} {
+		if unsubErr := server.broker.Unsubscribe(context.Background(), topic); unsubErr != nil {
+			slog.Error("Broker unsubscribe error", "topic", topic, "error", unsubErr)
+		}
+	}
+	return err
+}
+
+// refuseDraining turns away a new client while the server drains, telling it when to retry
+func (server *WebSocketServer) refuseDraining(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", retryAfter(server.options.ReconnectDelay))
+	http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+}
+
+// retryAfter formats a delay as whole seconds for the Retry-After header
+func retryAfter(delay time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(delay.Seconds())))
+}
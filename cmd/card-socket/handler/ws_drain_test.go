@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrainFlushesQueuesAndSaysGoingAway(t *testing.T) {
+	server, ts := startTransports(t, DefaultOptions())
+	alice := connect(t, ts, 1)
+	resp := openEvents(t, ts, 2, "")
+	require.Eventually(t, func() bool { return server.hasClient(1) && server.hasClient(2) }, time.Second, 5*time.Millisecond)
+
+	frame, err := encodeFrame(Message{Type: MessageTypeBroadcast, Content: "last words"})
+	require.NoError(t, err)
+	server.fanOut(frame)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, server.Drain(ctx))
+
+	// The WebSocket client gets what was queued, then a going away close frame with the reconnect hint
+	assert.Equal(t, "last words", readMessage(t, alice).Content)
+	_, _, err = alice.ReadMessage()
+	var closeErr *websocket.CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, websocket.CloseGoingAway, closeErr.Code)
+	assert.Equal(t, `{"reconnect_after":5000}`, closeErr.Text)
+
+	// The event stream gets the same frame and a retry hint before it ends
+	reader := bufio.NewReader(resp.Body)
+	_, env := readEvent(t, reader)
+	assert.Equal(t, MessageTypeBroadcast, env.Type)
+	var tail strings.Builder
+	_, err = reader.WriteTo(&tail)
+	require.NoError(t, err)
+	assert.Contains(t, tail.String(), "retry: 5000\n\n")
+	require.Eventually(t, func() bool { return !server.hasClient(1) && !server.hasClient(2) }, time.Second, 5*time.Millisecond)
+
+	// New clients are turned away until the next instance takes over
+	_, refused, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, refused.StatusCode)
+	assert.Equal(t, "5", refused.Header.Get("Retry-After"))
+}
+
+func TestDrainGivesUpAtTheDeadline(t *testing.T) {
+	server, ts := startTransports(t, DefaultOptions())
+
+	// A long-polling session that stopped polling is never told to leave
+	req, err := http.NewRequest("GET", ts.URL+"/poll", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-User-Id", "3")
+	_, err = (&http.Client{Timeout: 50 * time.Millisecond}).Do(req)
+	require.Error(t, err)
+	require.True(t, server.hasClient(3))
+	server.mutex.RLock()
+	session := server.clients[3]
+	server.mutex.RUnlock()
+	polled := session.idleSince()
+	require.Eventually(t, func() bool { return session.idleSince().After(polled) }, time.Second, 5*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, server.Drain(ctx), context.DeadlineExceeded)
+	require.Eventually(t, func() bool { return !server.hasClient(3) }, time.Second, 5*time.Millisecond)
+}
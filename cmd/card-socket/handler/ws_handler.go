@@ -2,132 +2,269 @@ package handler
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"encoding/json"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/go-redis/redis/v8"
+	"github.com/cupv/mux/internal/domain"
+	"github.com/cupv/mux/pkg/broker"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
 // WebSocketServer holds the state of the WebSocket server
 type WebSocketServer struct {
-	clients     map[int]*websocket.Conn
-	broadcast   chan []byte
-	mutex       sync.RWMutex
-	upgrader    websocket.Upgrader
-	redisClient *redis.Client
-	ctx         context.Context
+	clients   map[int]*client
+	rooms     map[string]map[int]struct{}
+	expiring  map[int]*time.Timer
+	roomGrace time.Duration
+	options   Options
+	broadcast chan []byte
+	mutex     sync.RWMutex
+	upgrader  websocket.Upgrader
+	broker    broker.Broker
+	handlers  map[string]frameHandler
+	stores    Stores
+	ctx       context.Context
+	draining  atomic.Bool
+	quizzes   map[string]*quizGame
+	quizMutex sync.Mutex
+	instance  string
 }
 
+// broadcastTopic is the broker topic carrying messages for every connected client
+const broadcastTopic = "messages"
+
 // NewWebSocketServer initializes a new WebSocket server
-func NewWebSocketServer(redisAddr string, redisPassword string) *WebSocketServer {
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     redisAddr,
-		Password: redisPassword,
-	})
-	return &WebSocketServer{
-		clients:   make(map[int]*websocket.Conn),
+func NewWebSocketServer(b broker.Broker, stores Stores, opts Options) *WebSocketServer {
+	server := &WebSocketServer{
+		clients:   make(map[int]*client),
+		rooms:     make(map[string]map[int]struct{}),
+		expiring:  make(map[int]*time.Timer),
+		roomGrace: opts.RoomGrace,
+		options:   opts,
 		broadcast: make(chan []byte),
 		upgrader: websocket.Upgrader{
+			Subprotocols: []string{ProtocolV1},
 			CheckOrigin: func(r *http.Request) bool {
 				return true
 			},
 		},
-		redisClient: rdb,
-		ctx:         context.Background(),
+		broker:   b,
+		handlers: make(map[string]frameHandler),
+		stores:   stores,
+		ctx:      context.Background(),
+		quizzes:  make(map[string]*quizGame),
+		instance: uuid.NewString(),
 	}
+	server.registerHandlers()
+	return server
 }
 
-// Start initializes the broadcasting goroutines
-func (server *WebSocketServer) Start() {
+// Start initializes the broadcasting goroutines and broker subscriptions
+func (server *WebSocketServer) Start() error {
 	// Start local broadcaster
 	go server.localBroadcast()
 
-	// Start Redis subscriber for distributed broadcast
-	go server.redisSubscribe()
+	// Subscribe to the broker for distributed broadcast
+	if err := server.broker.Subscribe(server.ctx, broadcastTopic, server.onBroadcast); err != nil {
+		return err
+	}
+
+	// Subscribe to the broker for room messages
+	if err := server.broker.Subscribe(server.ctx, roomTopic, server.onRoomMessage); err != nil {
+		return err
+	}
+
+	// Subscribe to the broker for direct messages
+	if err := server.broker.Subscribe(server.ctx, directTopic, server.onDirectMessage); err != nil {
+		return err
+	}
+
+	// Subscribe to the broker for receipts
+	if err := server.broker.Subscribe(server.ctx, receiptTopic, server.onReceipt); err != nil {
+		return err
+	}
+
+	// Subscribe to the broker for card events from the card API
+	if err := server.broker.Subscribe(server.ctx, domain.CardEventsTopic, server.onCardEvent); err != nil {
+		return err
+	}
+
+	// Subscribe to the broker for quiz commands and frames
+	if err := server.broker.Subscribe(server.ctx, quizTopic, server.onQuizMessage); err != nil {
+		return err
+	}
+
+	// Start reaper for dead connections
+	go server.reap()
+
+	// Start presence heartbeats for local clients
+	go server.heartbeat()
+	return nil
 }
 
 // localBroadcast handles messages from the broadcast channel and sends them to clients
 func (server *WebSocketServer) localBroadcast() {
 	for message := range server.broadcast {
-		server.mutex.RLock()
-		for userId := range server.clients {
-			client := server.clients[userId]
-			err := client.WriteMessage(websocket.TextMessage, message)
-			if err != nil {
-				client.Close()
-				server.unregister(client, userId)
-			}
-		}
-		server.mutex.RUnlock()
+		server.fanOut(message)
 	}
 }
 
-// redisSubscribe subscribes to the Redis "messages" channel for cross-instance messaging
-func (server *WebSocketServer) redisSubscribe() {
-	pubsub := server.redisClient.Subscribe(server.ctx, "messages")
-	defer pubsub.Close()
-
-	for msg := range pubsub.Channel() {
-		server.mutex.RLock()
-		for userId := range server.clients {
-			client := server.clients[userId]
-			err := client.WriteMessage(websocket.TextMessage, []byte(msg.Payload))
-			if err != nil {
-				client.Close()
-				server.unregister(client, userId)
-			}
-		}
-		server.mutex.RUnlock()
+// publishBroadcast sends a message to every client on every instance, broadcasts are not stored
+func (server *WebSocketServer) publishBroadcast(msg Message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
 	}
+	return server.broker.Publish(server.ctx, broadcastTopic, payload)
 }
 
-// HandleConnections upgrades HTTP requests to WebSocket connections
+// onBroadcast delivers a message from the "messages" topic to every local client
+func (server *WebSocketServer) onBroadcast(msg broker.Message) {
+	var message Message
+	if err := json.Unmarshal(msg.Payload, &message); err != nil {
+		slog.WarnContext(msg.Context(), "Invalid broadcast message", "error", err)
+		return
+	}
+	frame, err := encodeFrame(message)
+	if err != nil {
+		return
+	}
+	server.fanOut(frame)
+}
+
+// fanOut queues a message for every local client without blocking on any of them
+func (server *WebSocketServer) fanOut(message []byte) {
+	server.mutex.RLock()
+	defer server.mutex.RUnlock()
+
+	for _, c := range server.clients {
+		c.enqueue(message)
+	}
+}
+
+// onRoomMessage delivers a message from the "rooms" topic to local room members
+func (server *WebSocketServer) onRoomMessage(msg broker.Message) {
+	var message Message
+	if err := json.Unmarshal(msg.Payload, &message); err != nil {
+		slog.WarnContext(msg.Context(), "Invalid room message", "error", err)
+		return
+	}
+	server.deliverToRoom(message)
+}
+
+// HandleConnections upgrades HTTP requests to WebSocket connections, unacknowledged direct messages are redelivered
+// and a last_id query parameter replays everything missed before live traffic resumes.
+// Clients that name protocols in Sec-WebSocket-Protocol must offer a supported version, clients that name none get card.v1.
 func (server *WebSocketServer) HandleConnections(w http.ResponseWriter, r *http.Request) {
+	if server.draining.Load() {
+		server.refuseDraining(w)
+		return
+	}
+	if !supportsProtocol(websocket.Subprotocols(r)) {
+		http.Error(w, "Unsupported protocol, expected "+ProtocolV1, http.StatusBadRequest)
+		return
+	}
+
 	conn, err := server.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		fmt.Println("Error upgrading:", err)
+		slog.WarnContext(r.Context(), "WebSocket upgrade failed", "error", err)
 		return
 	}
-	xUserId := r.Header.Get("X-User-Id")
-	fmt.Println("Client " + xUserId + " connect to ws server.")
+	userId, _ := strconv.Atoi(r.Header.Get("X-User-Id"))
+	slog.InfoContext(r.Context(), "Client connected", "user", userId, "transport", "websocket")
+
+	c := newClient(conn, userId, server.options)
+
+	query := r.URL.Query()
+	resuming := query.Has("last_id")
+	lastId, _ := strconv.ParseInt(query.Get("last_id"), 10, 64)
+
+	server.attach(c, resuming, lastId)
+	go server.handleMessages(c)
+}
+
+// attach registers a client of any transport, restores its rooms and queues what it missed before live traffic
+func (server *WebSocketServer) attach(c *client, resuming bool, lastId int64) {
+	c.hold()
+	server.register(c)
+	rooms := server.restoreRooms(c.userId)
+	server.setPresence(c.userId, PresenceOnline)
+	sent := server.redeliver(c, resuming, lastId)
+	if resuming {
+		sent = max(sent, server.replay(c, rooms, lastId))
+	}
+	c.release(sent)
+}
 
-	userId, _ := strconv.Atoi(xUserId)
-	server.register(conn, userId)
-	go server.handleMessages(conn, userId)
+// detach unregisters a client, starts the grace period of its rooms and marks the user offline.
+// Games the user hosts here are cancelled unless a reconnect already replaced the connection.
+func (server *WebSocketServer) detach(c *client) {
+	server.unregister(c)
+	server.mutex.RLock()
+	_, replaced := server.clients[c.userId]
+	server.mutex.RUnlock()
+	if !replaced {
+		server.endHostedQuizzes(c.userId)
+	}
+	server.expireRooms(c.userId)
+	server.goOffline(c.userId)
 }
 
-// register adds a new client to the server
-func (server *WebSocketServer) register(conn *websocket.Conn, userId int) {
+// register adds a new client to the server, closing any connection it replaces
+func (server *WebSocketServer) register(c *client) {
 	server.mutex.Lock()
-	server.clients[userId] = conn
+	previous := server.clients[c.userId]
+	server.clients[c.userId] = c
 	server.mutex.Unlock()
+	connectedClients.With(c.transport()).Inc()
+
+	if previous != nil {
+		connectedClients.With(previous.transport()).Dec()
+		previous.close()
+	}
 }
 
 // unregister removes a client from the server
-func (server *WebSocketServer) unregister(conn *websocket.Conn, userId int) {
+func (server *WebSocketServer) unregister(c *client) {
 	server.mutex.Lock()
-	delete(server.clients, userId)
+	// A reconnect may already have replaced this connection
+	if server.clients[c.userId] == c {
+		delete(server.clients, c.userId)
+		connectedClients.With(c.transport()).Dec()
+	}
 	server.mutex.Unlock()
-	conn.Close()
+	c.close()
 }
 
-// handleMessages reads messages from a client and publishes them to Redis for cross-instance broadcast
-func (server *WebSocketServer) handleMessages(conn *websocket.Conn, userId int) {
-	defer server.unregister(conn, userId)
+// handleMessages reads frames from a client and dispatches them to the handler of their type
+func (server *WebSocketServer) handleMessages(c *client) {
+	defer server.detach(c)
 
 	for {
-		_, message, err := conn.ReadMessage()
+		_, message, err := c.conn.ReadMessage()
 		if err != nil {
 			break
 		}
-		// Publish message to Redis for cross-instance distribution
-		err = server.redisClient.Publish(server.ctx, "messages", message).Err()
-		if err != nil {
-			log.Println("Redis publish error:", err)
+		c.touch()
+		server.dispatch(c, message)
+	}
+}
+
+// supportsProtocol reports whether a client's requested protocols include one this server speaks
+func supportsProtocol(requested []string) bool {
+	if len(requested) == 0 {
+		return true
+	}
+	for _, protocol := range requested {
+		if protocol == ProtocolV1 {
+			return true
 		}
 	}
+	return false
 }
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// HistoryStore keeps a bounded, ordered history of messages per room or recipient
+type HistoryStore interface {
+	// Append stores a message under key and returns the id assigned to it, ids grow across all keys
+	Append(ctx context.Context, key string, msg Message) (int64, error)
+	// Since returns the messages stored under key with an id greater than afterId, oldest first
+	Since(ctx context.Context, key string, afterId int64) ([]Message, error)
+}
+
+// roomHistoryKey is the history key of a room
+func roomHistoryKey(room string) string {
+	return "room:" + room
+}
+
+// userHistoryKey is the history key of the direct messages sent to a user
+func userHistoryKey(userId int) string {
+	return "user:" + strconv.Itoa(userId)
+}
+
+// historySeqKey holds the last id handed out by the Redis history store
+const historySeqKey = "ws:history:seq"
+
+// appendScript assigns the next id and appends the entry in one step, so stream ids never go backwards
+var appendScript = redis.NewScript(`
+local id = redis.call('INCR', KEYS[1])
+redis.call('XADD', KEYS[2], 'MAXLEN', '~', ARGV[2], id .. '-0', 'payload', ARGV[1])
+return id
+`)
+
+type redisHistoryStore struct {
+	client *redis.Client
+	limit  int
+}
+
+// NewRedisHistoryStore creates a history store that keeps roughly the last limit messages of every key in a Redis stream
+func NewRedisHistoryStore(client *redis.Client, limit int) HistoryStore {
+	return &redisHistoryStore{client, limit}
+}
+
+func historyStreamKey(key string) string {
+	return "ws:history:" + key
+}
+
+func (s *redisHistoryStore) Append(ctx context.Context, key string, msg Message) (int64, error) {
+	msg.ID = 0
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return 0, err
+	}
+	return appendScript.Run(ctx, s.client, []string{historySeqKey, historyStreamKey(key)}, payload, s.limit).Int64()
+}
+
+func (s *redisHistoryStore) Since(ctx context.Context, key string, afterId int64) ([]Message, error) {
+	entries, err := s.client.XRange(ctx, historyStreamKey(key), "("+strconv.FormatInt(afterId, 10)+"-0", "+").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, len(entries))
+	for _, entry := range entries {
+		seq, _, _ := strings.Cut(entry.ID, "-")
+		id, err := strconv.ParseInt(seq, 10, 64)
+		if err != nil {
+			continue
+		}
+		payload, _ := entry.Values["payload"].(string)
+		var msg Message
+		if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+			continue
+		}
+		msg.ID = id
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+type memoryHistoryStore struct {
+	mutex    sync.Mutex
+	lastId   int64
+	limit    int
+	messages map[string][]Message
+}
+
+// NewMemoryHistoryStore creates a history store for a single instance that keeps the last limit messages of every key
+func NewMemoryHistoryStore(limit int) HistoryStore {
+	return &memoryHistoryStore{
+		limit:    limit,
+		messages: make(map[string][]Message),
+	}
+}
+
+func (s *memoryHistoryStore) Append(ctx context.Context, key string, msg Message) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastId++
+	msg.ID = s.lastId
+	messages := append(s.messages[key], msg)
+	if len(messages) > s.limit {
+		messages = messages[len(messages)-s.limit:]
+	}
+	s.messages[key] = messages
+	return msg.ID, nil
+}
+
+func (s *memoryHistoryStore) Since(ctx context.Context, key string, afterId int64) ([]Message, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	messages := s.messages[key]
+	start := sort.Search(len(messages), func(i int) bool { return messages[i].ID > afterId })
+	return append([]Message(nil), messages[start:]...), nil
+}
+
+// replay queues everything stored after lastId in a client's rooms and inbox and returns the highest id sent
+func (server *WebSocketServer) replay(c *client, rooms []string, lastId int64) int64 {
+	keys := []string{userHistoryKey(c.userId)}
+	for _, room := range rooms {
+		keys = append(keys, roomHistoryKey(room))
+	}
+
+	var missed []Message
+	for _, key := range keys {
+		messages, err := server.stores.History.Since(server.ctx, key, lastId)
+		if err != nil {
+			slog.Error("History lookup error", "key", key, "error", err)
+			continue
+		}
+		missed = append(missed, messages...)
+	}
+	sort.Slice(missed, func(i, j int) bool { return missed[i].ID < missed[j].ID })
+
+	for _, msg := range missed {
+		frame, err := encodeFrame(msg)
+		if err != nil {
+			continue
+		}
+		c.enqueue(frame)
+		lastId = msg.ID
+	}
+	return lastId
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cupv/mux/pkg/broker"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryHistoryStoreIsBoundedAndOrdered(t *testing.T) {
+	store := NewMemoryHistoryStore(2)
+	ctx := context.Background()
+
+	var ids []int64
+	for _, content := range []string{"a", "b", "c"} {
+		id, err := store.Append(ctx, "room:deck-1", Message{Content: content})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	other, err := store.Append(ctx, "room:deck-2", Message{Content: "d"})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1, 2, 3}, ids)
+	assert.Equal(t, int64(4), other)
+
+	messages, err := store.Since(ctx, "room:deck-1", 0)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "b", messages[0].Content)
+	assert.Equal(t, "c", messages[1].Content)
+
+	messages, err = store.Since(ctx, "room:deck-1", 2)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, int64(3), messages[0].ID)
+}
+
+func TestReconnectReplaysMissedMessages(t *testing.T) {
+	b := broker.NewMemory()
+	defer b.Close()
+	server := NewWebSocketServer(b, NewMemoryStores(DefaultOptions()), DefaultOptions())
+	require.NoError(t, server.Start())
+	ts := httptest.NewServer(http.HandlerFunc(server.HandleConnections))
+	defer ts.Close()
+
+	alice := connect(t, ts, 1)
+	bob := connect(t, ts, 2)
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		send(t, conn, MessageTypeJoin, roomPayload{Room: "deck-1"})
+	}
+	require.Eventually(t, func() bool {
+		members, _ := server.RoomMembers("deck-1")
+		return len(members) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	send(t, alice, MessageTypeMessage, roomPayload{Room: "deck-1", Content: "seen"})
+	seen := readMessage(t, bob)
+	bob.Close()
+	require.Eventually(t, func() bool { return !server.hasClient(2) }, time.Second, 5*time.Millisecond)
+
+	send(t, alice, MessageTypeMessage, roomPayload{Room: "deck-1", Content: "missed"})
+	send(t, alice, MessageTypeDirect, directPayload{RecipientID: "2", Content: "psst"})
+	require.Eventually(t, func() bool {
+		missed, _ := server.stores.History.Since(context.Background(), userHistoryKey(2), 0)
+		return len(missed) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	header := http.Header{}
+	header.Set("X-User-Id", "2")
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "?last_id=" + strconv.FormatInt(seen.ID, 10)
+	bob, _, err := websocket.DefaultDialer.Dial(url, header)
+	require.NoError(t, err)
+	defer bob.Close()
+
+	var contents []string
+	for i := 0; i < 2; i++ {
+		msg := readMessage(t, bob)
+		assert.Greater(t, msg.ID, seen.ID)
+		contents = append(contents, msg.Content)
+	}
+	assert.Equal(t, []string{"missed", "psst"}, contents)
+}
@@ -1,8 +1,62 @@
 package handler
 
-// Message represents the message structure for sending and receiving
+import "encoding/json"
+
+// ProtocolV1 is the Sec-WebSocket-Protocol name of the first envelope version
+const ProtocolV1 = "card.v1"
+
+// Message types understood by the socket protocol
+const (
+	MessageTypeBroadcast = "broadcast"
+	MessageTypeJoin      = "join"
+	MessageTypeLeave     = "leave"
+	MessageTypeMessage   = "message"
+	MessageTypeDirect    = "direct"
+	MessageTypeAck       = "ack"
+	MessageTypeRead      = "read"
+	MessageTypeReceipt   = "receipt"
+	MessageTypePresence  = "presence"
+	MessageTypeTyping    = "typing"
+	MessageTypeError     = "error"
+)
+
+// Quiz frame types, clients send the first four and the server pushes the rest
+const (
+	MessageTypeQuizCreate   = "quiz_create"
+	MessageTypeQuizJoin     = "quiz_join"
+	MessageTypeQuizStart    = "quiz_start"
+	MessageTypeQuizAnswer   = "quiz_answer"
+	MessageTypeQuizCreated  = "quiz_created"
+	MessageTypeQuizLobby    = "quiz_lobby"
+	MessageTypeQuizQuestion = "quiz_question"
+	MessageTypeQuizAnswered = "quiz_answered"
+	MessageTypeQuizReveal   = "quiz_reveal"
+	MessageTypeQuizOver     = "quiz_over"
+)
+
+// Envelope is a single frame on the wire, the payload shape depends on the type
+type Envelope struct {
+	Type    string          `json:"type"`
+	ID      int64           `json:"id,omitempty"`
+	TS      int64           `json:"ts"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Message represents the message structure passed between instances and stored in history
 type Message struct {
-	RecipientID string `json:"recipient_id"`
-	SenderID    string `json:"sender_id"`
-	Content     string `json:"content"`
-}
\ No newline at end of file
+	ID          int64  `json:"id,omitempty"`
+	Type        string `json:"type,omitempty"`
+	TS          int64  `json:"ts,omitempty"`
+	Room        string `json:"room,omitempty"`
+	RecipientID string `json:"recipient_id,omitempty"`
+	SenderID    string `json:"sender_id,omitempty"`
+	Content     string `json:"content,omitempty"`
+	Status      string `json:"status,omitempty"`
+}
+
+// ErrorPayload describes why a client frame was rejected, Ref echoes the id of that frame
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Ref     int64  `json:"ref,omitempty"`
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"github.com/cupv/mux/pkg/metrics"
+)
+
+// Subsystem metrics, exposed on /metrics
+var (
+	connectedClients = metrics.Default.NewGaugeVec("ws_connected_clients",
+		"Connected clients by transport.", "transport")
+	messagesIn = metrics.Default.NewCounterVec("ws_messages_in_total",
+		"Frames received from clients by frame type, unknown and malformed frames included.", "type")
+	messagesOut = metrics.Default.NewCounter("ws_messages_out_total",
+		"Messages written to clients.")
+	messagesDropped = metrics.Default.NewCounterVec("ws_messages_dropped_total",
+		"Messages that never reached a client, because its queue was full or it disconnected.", "reason")
+	slowDisconnects = metrics.Default.NewCounter("ws_slow_disconnects_total",
+		"Clients disconnected because their queue was full.")
+	reapedConnections = metrics.Default.NewCounter("ws_reaped_connections_total",
+		"Stale connections closed by the reaper.")
+)
+
+// Reasons a message is dropped, for metric labels
+const (
+	dropQueueFull    = "queue_full"
+	dropDisconnected = "disconnected"
+)
+
+// transport names how a client is connected, for metric labels
+func (c *client) transport() string {
+	switch {
+	case c.conn != nil:
+		return "websocket"
+	case c.polling:
+		return "poll"
+	default:
+		return "sse"
+	}
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueMetrics(t *testing.T) {
+	opts := DefaultOptions()
+	opts.QueueSize = 1
+	c := newStreamClient(1, opts)
+	sent := messagesOut.Value()
+	full, disconnected := messagesDropped.With(dropQueueFull).Value(), messagesDropped.With(dropDisconnected).Value()
+
+	c.enqueue([]byte("first"))
+	c.enqueue([]byte("second"))
+	assert.Equal(t, sent, messagesOut.Value(), "queued messages are not sent yet")
+	assert.Equal(t, full+1, messagesDropped.With(dropQueueFull).Value())
+
+	// The queued message and anything sent after disconnecting are lost
+	c.close()
+	c.enqueue([]byte("third"))
+	assert.Equal(t, disconnected+2, messagesDropped.With(dropDisconnected).Value())
+
+	opts.OverflowPolicy = DisconnectSlow
+	c = newStreamClient(2, opts)
+	disconnects := slowDisconnects.Value()
+	c.enqueue([]byte("first"))
+	c.enqueue([]byte("second"))
+	assert.Equal(t, disconnects+1, slowDisconnects.Value())
+}
+
+func TestMessagesOutCountsWrites(t *testing.T) {
+	_, ts := startServer(t)
+	alice := connect(t, ts, 1)
+	bob := connect(t, ts, 2)
+
+	sent := messagesOut.Value()
+	send(t, alice, MessageTypeDirect, directPayload{RecipientID: "2", Content: "hello"})
+	readMessage(t, bob)
+	// The writer counts a message once WriteMessage returns, which may be just after bob has read it
+	require.Eventually(t, func() bool { return messagesOut.Value() >= sent+1 }, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,85 @@
+package handler
+
+import "time"
+
+// OverflowPolicy decides what happens when a client's outbound queue is full
+type OverflowPolicy int
+
+const (
+	// DropOldest discards the oldest queued message to make room for the new one
+	DropOldest OverflowPolicy = iota
+	// DisconnectSlow closes the connection of a client that cannot keep up
+	DisconnectSlow
+)
+
+// Options tunes how the server treats its clients
+type Options struct {
+	// QueueSize is how many outbound messages a client may have pending
+	QueueSize int
+	// OverflowPolicy decides what happens once that queue is full
+	OverflowPolicy OverflowPolicy
+	// WriteTimeout bounds every write to a client
+	WriteTimeout time.Duration
+	// RoomGrace is how long a disconnected user keeps their room memberships
+	RoomGrace time.Duration
+	// PingInterval is how often the server pings each client, it must be shorter than PongWait
+	PingInterval time.Duration
+	// PongWait is how long a client may stay silent before its read fails
+	PongWait time.Duration
+	// ReapInterval is how often stale connections are looked for
+	ReapInterval time.Duration
+	// MaxMessageSize is the largest frame in bytes a client may send
+	MaxMessageSize int64
+	// HistoryLimit is how many messages are kept per room or recipient for replay
+	HistoryLimit int
+	// ReceiptTTL is how long delivery and read state is kept per message
+	ReceiptTTL time.Duration
+	// PresenceTTL is how long a presence entry, or an instance's connection count, lives without a heartbeat
+	PresenceTTL time.Duration
+	// PresenceHeartbeat is how often local clients' presence entries and the instance's liveness are refreshed
+	PresenceHeartbeat time.Duration
+	// PresenceIdle is how long a user may send no frames before they are set away, zero keeps them online
+	PresenceIdle time.Duration
+	// PollTimeout is how long a long-poll waits for frames before returning empty, it must be shorter than PongWait
+	PollTimeout time.Duration
+	// ReconnectDelay is how long clients are told to wait before reconnecting when the server drains
+	ReconnectDelay time.Duration
+	// QuizQuestionTime is how long players have to answer a quiz question
+	QuizQuestionTime time.Duration
+	// QuizRevealTime is how long the answer and leaderboard stay up before the next question
+	QuizRevealTime time.Duration
+	// QuizLobbyTimeout is how long a game may wait in its lobby for the host to start it
+	QuizLobbyTimeout time.Duration
+	// QuizMaxGames is how many open games a host may have on an instance
+	QuizMaxGames int
+	// StreamTokenKey signs the tokens that identify EventSource and long-polling clients, tokens are off while it is empty
+	StreamTokenKey []byte
+	// StreamTokenTTL is how long a stream token may be used to open a feed or post frames
+	StreamTokenTTL time.Duration
+}
+
+// DefaultOptions returns the options used when none are configured
+func DefaultOptions() Options {
+	return Options{
+		QueueSize:         256,
+		OverflowPolicy:    DropOldest,
+		WriteTimeout:      10 * time.Second,
+		RoomGrace:         roomGracePeriod,
+		PingInterval:      54 * time.Second,
+		PongWait:          60 * time.Second,
+		ReapInterval:      30 * time.Second,
+		MaxMessageSize:    64 * 1024,
+		HistoryLimit:      1000,
+		ReceiptTTL:        7 * 24 * time.Hour,
+		PresenceTTL:       90 * time.Second,
+		PresenceHeartbeat: 30 * time.Second,
+		PresenceIdle:      5 * time.Minute,
+		PollTimeout:       25 * time.Second,
+		ReconnectDelay:    5 * time.Second,
+		QuizQuestionTime:  20 * time.Second,
+		QuizRevealTime:    5 * time.Second,
+		QuizLobbyTimeout:  10 * time.Minute,
+		QuizMaxGames:      3,
+		StreamTokenTTL:    time.Minute,
+	}
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// HandlePoll serves the feed over HTTP long-polling. The first poll opens a session that keeps queueing frames between polls,
+// every poll waits up to PollTimeout for frames and returns all queued ones as a JSON array.
+// Sessions that stop polling are closed by the reaper.
+func (server *WebSocketServer) HandlePoll(w http.ResponseWriter, r *http.Request) {
+	userId, err := server.requestUser(r)
+	if err != nil {
+		http.Error(w, "Invalid stream token", http.StatusUnauthorized)
+		return
+	}
+	c, ok := server.pollSession(r, userId)
+	if !ok {
+		server.refuseDraining(w)
+		return
+	}
+
+	c.touch()
+	frames := collect(r.Context(), c, server.options.PollTimeout)
+	c.touch()
+
+	// A draining server hands out the last frames and ends the session
+	select {
+	case <-c.leaving:
+		c.close()
+		w.Header().Set("Retry-After", retryAfter(c.reconnectAfter))
+	default:
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := json.NewEncoder(w).Encode(frames); err != nil {
+		messagesDropped.With(dropDisconnected).Add(float64(len(frames)))
+		return
+	}
+	messagesOut.Add(float64(len(frames)))
+}
+
+// pollSession returns the user's open long-polling session, or attaches a new one that resumes from last_id.
+// It reports false when the server is draining and no session is open.
+func (server *WebSocketServer) pollSession(r *http.Request, userId int) (*client, bool) {
+	server.mutex.RLock()
+	c, ok := server.clients[userId]
+	server.mutex.RUnlock()
+	if ok && c.polling {
+		select {
+		case <-c.done:
+		default:
+			return c, true
+		}
+	}
+	if server.draining.Load() {
+		return nil, false
+	}
+
+	c = newStreamClient(userId, server.options)
+	c.polling = true
+	resuming, lastId := resumePoint(r)
+	server.attach(c, resuming, lastId)
+	go func() {
+		<-c.done
+		server.detach(c)
+	}()
+	return c, true
+}
+
+// collect waits up to wait for a first frame, then takes whatever else is already queued
+func collect(ctx context.Context, c *client, wait time.Duration) []json.RawMessage {
+	frames := make([]json.RawMessage, 0)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case frame := <-c.send:
+		frames = append(frames, frame)
+	case <-timer.C:
+		return frames
+	case <-ctx.Done():
+		return frames
+	case <-c.done:
+		return frames
+	case <-c.leaving:
+	}
+
+	for _, frame := range c.pending() {
+		frames = append(frames, frame)
+	}
+	return frames
+}
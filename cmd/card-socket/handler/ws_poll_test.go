@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// poll makes one long-poll as a user and returns the frames it got
+func poll(t *testing.T, ts *httptest.Server, userId int) []Envelope {
+	req, err := http.NewRequest("GET", ts.URL+"/poll", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-User-Id", strconv.Itoa(userId))
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var frames []Envelope
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&frames))
+	return frames
+}
+
+func TestLongPollSharesRoomsWithWebSocketClients(t *testing.T) {
+	opts := DefaultOptions()
+	opts.PollTimeout = 100 * time.Millisecond
+	server, ts := startTransports(t, opts)
+
+	// The first poll opens the session and times out empty
+	assert.Empty(t, poll(t, ts, 2))
+	require.True(t, server.hasClient(2))
+	require.Equal(t, http.StatusAccepted, postFrame(t, ts, 2, MessageTypeJoin, roomPayload{Room: "deck-1"}))
+	require.Eventually(t, func() bool { return server.isMember("deck-1", 2) }, time.Second, 5*time.Millisecond)
+
+	alice := connect(t, ts, 1)
+	send(t, alice, MessageTypeJoin, roomPayload{Room: "deck-1"})
+	require.Eventually(t, func() bool { return server.isMember("deck-1", 1) }, time.Second, 5*time.Millisecond)
+	send(t, alice, MessageTypeMessage, roomPayload{Room: "deck-1", Content: "hello"})
+	send(t, alice, MessageTypeMessage, roomPayload{Room: "deck-1", Content: "again"})
+
+	var frames []Envelope
+	require.Eventually(t, func() bool {
+		frames = append(frames, poll(t, ts, 2)...)
+		return len(frames) >= 2
+	}, 2*time.Second, 5*time.Millisecond)
+
+	var msg Message
+	require.NoError(t, json.Unmarshal(frames[1].Payload, &msg))
+	assert.Equal(t, MessageTypeMessage, frames[1].Type)
+	assert.Equal(t, "again", msg.Content)
+
+	// The poll session answers errors on its feed
+	require.Equal(t, http.StatusAccepted, postFrame(t, ts, 2, MessageTypeMessage, roomPayload{Room: "deck-9", Content: "nope"}))
+	frames = poll(t, ts, 2)
+	require.Len(t, frames, 1)
+	assert.Equal(t, MessageTypeError, frames[0].Type)
+}
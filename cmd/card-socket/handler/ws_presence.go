@@ -0,0 +1,340 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Presence states a user moves through
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+// Presence is the last known state of a user
+type Presence struct {
+	UserID    int       `json:"user_id"`
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PresenceStore is the registry of who is online across all instances, entries expire unless refreshed
+type PresenceStore interface {
+	// Set records a user's status and returns the previous one, offline when there was none
+	Set(ctx context.Context, userId int, status string) (string, error)
+	// Refresh extends the lifetime of a user's entry without changing it
+	Refresh(ctx context.Context, userId int) error
+	// Remove drops a user's entry
+	Remove(ctx context.Context, userId int) error
+	// Get returns the presence of the given users, unknown users are reported offline
+	Get(ctx context.Context, userIds []int) ([]Presence, error)
+	// List returns every user that is not offline
+	List(ctx context.Context) ([]Presence, error)
+}
+
+const presenceKeyPrefix = "ws:presence:"
+
+func presenceKey(userId int) string {
+	return presenceKeyPrefix + strconv.Itoa(userId)
+}
+
+// setPresenceScript swaps a user's status and returns the previous one in one step, so concurrent updates
+// from several instances each see the status they replaced
+var setPresenceScript = redis.NewScript(`
+local previous = redis.call('HGET', KEYS[1], 'status')
+redis.call('HSET', KEYS[1], 'status', ARGV[1], 'updated_at', ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return previous
+`)
+
+type redisPresenceStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewRedisPresenceStore creates a presence registry in Redis hashes that expire after ttl
+func NewRedisPresenceStore(client *redis.Client, ttl time.Duration) PresenceStore {
+	return &redisPresenceStore{client, ttl}
+}
+
+func (s *redisPresenceStore) Set(ctx context.Context, userId int, status string) (string, error) {
+	previous, err := setPresenceScript.Run(ctx, s.client, []string{presenceKey(userId)}, status, time.Now().Unix(), s.ttl.Milliseconds()).Text()
+	if err == redis.Nil {
+		return PresenceOffline, nil
+	}
+	return previous, err
+}
+
+func (s *redisPresenceStore) Refresh(ctx context.Context, userId int) error {
+	return s.client.Expire(ctx, presenceKey(userId), s.ttl).Err()
+}
+
+func (s *redisPresenceStore) Remove(ctx context.Context, userId int) error {
+	return s.client.Del(ctx, presenceKey(userId)).Err()
+}
+
+func (s *redisPresenceStore) Get(ctx context.Context, userIds []int) ([]Presence, error) {
+	presences := make([]Presence, 0, len(userIds))
+	for _, userId := range userIds {
+		presence, err := s.get(ctx, userId)
+		if err != nil {
+			return nil, err
+		}
+		presences = append(presences, presence)
+	}
+	return presences, nil
+}
+
+func (s *redisPresenceStore) get(ctx context.Context, userId int) (Presence, error) {
+	fields, err := s.client.HGetAll(ctx, presenceKey(userId)).Result()
+	if err != nil {
+		return Presence{}, err
+	}
+	if len(fields) == 0 {
+		return Presence{UserID: userId, Status: PresenceOffline}, nil
+	}
+	updated, _ := strconv.ParseInt(fields["updated_at"], 10, 64)
+	return Presence{UserID: userId, Status: fields["status"], UpdatedAt: time.Unix(updated, 0)}, nil
+}
+
+func (s *redisPresenceStore) List(ctx context.Context) ([]Presence, error) {
+	var userIds []int
+	iter := s.client.Scan(ctx, 0, presenceKeyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		userId, err := strconv.Atoi(strings.TrimPrefix(iter.Val(), presenceKeyPrefix))
+		if err == nil {
+			userIds = append(userIds, userId)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	sort.Ints(userIds)
+
+	presences, err := s.Get(ctx, userIds)
+	if err != nil {
+		return nil, err
+	}
+	// Entries may expire between the scan and the lookup
+	online := presences[:0]
+	for _, presence := range presences {
+		if presence.Status != PresenceOffline {
+			online = append(online, presence)
+		}
+	}
+	return online, nil
+}
+
+type memoryPresence struct {
+	Presence
+	expires time.Time
+}
+
+type memoryPresenceStore struct {
+	mutex sync.Mutex
+	ttl   time.Duration
+	users map[int]memoryPresence
+}
+
+// NewMemoryPresenceStore creates a presence registry for a single instance
+func NewMemoryPresenceStore(ttl time.Duration) PresenceStore {
+	return &memoryPresenceStore{
+		ttl:   ttl,
+		users: make(map[int]memoryPresence),
+	}
+}
+
+// lookup returns a live entry, callers must hold the lock
+func (s *memoryPresenceStore) lookup(userId int) (memoryPresence, bool) {
+	entry, ok := s.users[userId]
+	if ok && time.Now().After(entry.expires) {
+		delete(s.users, userId)
+		return memoryPresence{}, false
+	}
+	return entry, ok
+}
+
+func (s *memoryPresenceStore) Set(ctx context.Context, userId int, status string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous := PresenceOffline
+	if entry, ok := s.lookup(userId); ok {
+		previous = entry.Status
+	}
+	now := time.Now()
+	s.users[userId] = memoryPresence{
+		Presence: Presence{UserID: userId, Status: status, UpdatedAt: now},
+		expires:  now.Add(s.ttl),
+	}
+	return previous, nil
+}
+
+func (s *memoryPresenceStore) Refresh(ctx context.Context, userId int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if entry, ok := s.lookup(userId); ok {
+		entry.expires = time.Now().Add(s.ttl)
+		s.users[userId] = entry
+	}
+	return nil
+}
+
+func (s *memoryPresenceStore) Remove(ctx context.Context, userId int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.users, userId)
+	return nil
+}
+
+func (s *memoryPresenceStore) Get(ctx context.Context, userIds []int) ([]Presence, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	presences := make([]Presence, 0, len(userIds))
+	for _, userId := range userIds {
+		if entry, ok := s.lookup(userId); ok {
+			presences = append(presences, entry.Presence)
+		} else {
+			presences = append(presences, Presence{UserID: userId, Status: PresenceOffline})
+		}
+	}
+	return presences, nil
+}
+
+func (s *memoryPresenceStore) List(ctx context.Context) ([]Presence, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	presences := make([]Presence, 0, len(s.users))
+	for userId := range s.users {
+		if entry, ok := s.lookup(userId); ok {
+			presences = append(presences, entry.Presence)
+		}
+	}
+	sort.Slice(presences, func(i, j int) bool { return presences[i].UserID < presences[j].UserID })
+	return presences, nil
+}
+
+// setPresence records a user's status and tells their rooms when it changed
+func (server *WebSocketServer) setPresence(userId int, status string) {
+	previous, err := server.stores.Presence.Set(server.ctx, userId, status)
+	if err != nil {
+		slog.Error("Presence update error", "user", userId, "error", err)
+		return
+	}
+	if previous != status {
+		server.announcePresence(userId, status)
+	}
+}
+
+// goOffline marks a user offline once their last connection on any instance has closed
+func (server *WebSocketServer) goOffline(userId int) {
+	conns, err := server.stores.Rooms.Connections(server.ctx, userId)
+	if err != nil || conns > 0 {
+		return
+	}
+	if err := server.stores.Presence.Remove(server.ctx, userId); err != nil {
+		slog.Error("Presence update error", "user", userId, "error", err)
+		return
+	}
+	server.announcePresence(userId, PresenceOffline)
+}
+
+// announcePresence sends a presence event to every room the user belongs to, presence is never stored in history
+func (server *WebSocketServer) announcePresence(userId int, status string) {
+	rooms, err := server.stores.Rooms.UserRooms(server.ctx, userId)
+	if err != nil {
+		slog.Error("Room store lookup error", "user", userId, "error", err)
+		return
+	}
+	for _, room := range rooms {
+		err := server.publishEphemeral(Message{
+			Type:     MessageTypePresence,
+			TS:       time.Now().UnixMilli(),
+			Room:     room,
+			SenderID: strconv.Itoa(userId),
+			Status:   status,
+		})
+		if err != nil {
+			slog.Error("Broker publish error", "user", userId, "error", err)
+		}
+	}
+}
+
+// heartbeat keeps the presence entries of local clients and this instance's connections alive while they stay connected
+func (server *WebSocketServer) heartbeat() {
+	ticker := time.NewTicker(server.options.PresenceHeartbeat)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		server.beat()
+	}
+}
+
+// beat refreshes local presence and cleans up after the users of instances that stopped without disconnecting them
+func (server *WebSocketServer) beat() {
+	server.mutex.RLock()
+	userIds := make([]int, 0, len(server.clients))
+	for userId := range server.clients {
+		userIds = append(userIds, userId)
+	}
+	server.mutex.RUnlock()
+
+	for _, userId := range userIds {
+		if err := server.stores.Presence.Refresh(server.ctx, userId); err != nil {
+			slog.Error("Presence heartbeat error", "user", userId, "error", err)
+		}
+	}
+	server.markIdle()
+
+	released, err := server.stores.Rooms.Heartbeat(server.ctx, server.instance)
+	if err != nil {
+		slog.Error("Instance heartbeat error", "instance", server.instance, "error", err)
+	}
+	for _, userId := range released {
+		slog.Info("Releasing user of a lost instance", "user", userId)
+		server.goOffline(userId)
+		server.dropRooms(userId)
+	}
+}
+
+// markIdle sets local users who have not sent a frame for PresenceIdle away, unless they chose a status themselves
+func (server *WebSocketServer) markIdle() {
+	if server.options.PresenceIdle <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-server.options.PresenceIdle)
+
+	server.mutex.RLock()
+	var idle []*client
+	for _, c := range server.clients {
+		if !c.away.Load() && c.activeSince().Before(cutoff) {
+			idle = append(idle, c)
+		}
+	}
+	server.mutex.RUnlock()
+
+	for _, c := range idle {
+		if c.idleAway.CompareAndSwap(false, true) {
+			server.setPresence(c.userId, PresenceAway)
+		}
+	}
+}
+
+// wake records a frame from a client and brings a user the server had set away back online
+func (server *WebSocketServer) wake(c *client) {
+	c.active()
+	if c.idleAway.CompareAndSwap(true, false) {
+		server.setPresence(c.userId, PresenceOnline)
+	}
+}
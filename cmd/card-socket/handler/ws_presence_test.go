@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cupv/mux/pkg/broker"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresenceAndTypingReachRoomMembers(t *testing.T) {
+	server, ts := startServer(t)
+	alice := connect(t, ts, 1)
+	bob := connect(t, ts, 2)
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		send(t, conn, MessageTypeJoin, roomPayload{Room: "deck-1"})
+	}
+	require.Eventually(t, func() bool {
+		members, _ := server.RoomMembers("deck-1")
+		return len(members) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	send(t, bob, MessageTypeTyping, roomPayload{Room: "deck-1"})
+	assert.Equal(t, Message{Type: MessageTypeTyping, Room: "deck-1", SenderID: "2"}, readMessage(t, alice))
+
+	send(t, bob, MessageTypePresence, presencePayload{Status: PresenceAway})
+	assert.Equal(t, Message{Type: MessageTypePresence, Room: "deck-1", SenderID: "2", Status: PresenceAway}, readMessage(t, alice))
+
+	rec := httptest.NewRecorder()
+	server.GetPresence(rec, httptest.NewRequest("GET", "/presence?user_id=2&user_id=3", nil))
+	var presences []Presence
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&presences))
+	require.Len(t, presences, 2)
+	assert.Equal(t, PresenceAway, presences[0].Status)
+	assert.Equal(t, PresenceOffline, presences[1].Status)
+
+	bob.Close()
+	assert.Equal(t, Message{Type: MessageTypePresence, Room: "deck-1", SenderID: "2", Status: PresenceOffline}, readMessage(t, alice))
+
+	// Typing events are never kept for replay
+	assert.Empty(t, mustSince(t, server, roomHistoryKey("deck-1")))
+}
+
+func TestIdleUsersGoAwayUntilTheySendAFrame(t *testing.T) {
+	opts := DefaultOptions()
+	opts.PresenceIdle = 50 * time.Millisecond
+	b := broker.NewMemory()
+	t.Cleanup(func() { b.Close() })
+	server := NewWebSocketServer(b, NewMemoryStores(opts), opts)
+	require.NoError(t, server.Start())
+	ts := httptest.NewServer(http.HandlerFunc(server.HandleConnections))
+	t.Cleanup(ts.Close)
+
+	alice := connect(t, ts, 1)
+	bob := connect(t, ts, 2)
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		send(t, conn, MessageTypeJoin, roomPayload{Room: "deck-1"})
+	}
+	require.Eventually(t, func() bool {
+		members, _ := server.RoomMembers("deck-1")
+		return len(members) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	// Only bob stays quiet past the idle time
+	time.Sleep(opts.PresenceIdle)
+	send(t, alice, MessageTypeTyping, roomPayload{Room: "deck-1"})
+	assert.Equal(t, MessageTypeTyping, readMessage(t, bob).Type)
+	server.beat()
+	assert.Equal(t, Message{Type: MessageTypePresence, Room: "deck-1", SenderID: "2", Status: PresenceAway}, readMessage(t, alice))
+
+	// Being idle is not announced twice, and the next frame brings bob back
+	server.beat()
+	send(t, bob, MessageTypeTyping, roomPayload{Room: "deck-1"})
+	assert.Equal(t, Message{Type: MessageTypePresence, Room: "deck-1", SenderID: "2", Status: PresenceOnline}, readMessage(t, alice))
+	assert.Equal(t, MessageTypeTyping, readMessage(t, alice).Type)
+
+	// A status the user chose is left alone
+	send(t, bob, MessageTypePresence, presencePayload{Status: PresenceAway})
+	assert.Equal(t, PresenceAway, readMessage(t, alice).Status)
+	time.Sleep(opts.PresenceIdle)
+	server.beat()
+	send(t, bob, MessageTypeTyping, roomPayload{Room: "deck-1"})
+	assert.Equal(t, MessageTypeTyping, readMessage(t, alice).Type)
+}
+
+func mustSince(t *testing.T, server *WebSocketServer, key string) []Message {
+	messages, err := server.stores.History.Since(server.ctx, key, 0)
+	require.NoError(t, err)
+	return messages
+}
@@ -0,0 +1,259 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/cupv/mux/pkg/broker"
+)
+
+// Error codes sent back in error frames
+const (
+	ErrCodeMalformed   = "malformed_frame"
+	ErrCodeUnknownType = "unknown_type"
+	ErrCodeInvalid     = "invalid_payload"
+	ErrCodeForbidden   = "forbidden"
+	ErrCodeInternal    = "internal_error"
+	ErrCodeUnavailable = "unavailable"
+)
+
+// frameError is a handler failure that is reported to the client
+type frameError struct {
+	code    string
+	message string
+}
+
+func (e *frameError) Error() string {
+	return e.code + ": " + e.message
+}
+
+func invalidPayload(message string) error {
+	return &frameError{ErrCodeInvalid, message}
+}
+
+func forbidden(message string) error {
+	return &frameError{ErrCodeForbidden, message}
+}
+
+// frameHandler applies one type of client frame
+type frameHandler func(c *client, env Envelope) error
+
+// Payloads of the frames clients send
+type roomPayload struct {
+	Room    string `json:"room"`
+	Content string `json:"content"`
+}
+
+type directPayload struct {
+	RecipientID string `json:"recipient_id"`
+	Content     string `json:"content"`
+}
+
+type receiptPayload struct {
+	ID int64 `json:"id"`
+}
+
+type presencePayload struct {
+	Status string `json:"status"`
+}
+
+type broadcastPayload struct {
+	Content string `json:"content"`
+}
+
+// handle registers the handler for a frame type, replacing any previous one
+func (server *WebSocketServer) handle(frameType string, handler frameHandler) {
+	server.handlers[frameType] = handler
+}
+
+// registerHandlers installs the handlers of every frame type the protocol defines
+func (server *WebSocketServer) registerHandlers() {
+	server.handle(MessageTypeBroadcast, server.onBroadcastFrame)
+	server.handle(MessageTypeJoin, server.onJoinFrame)
+	server.handle(MessageTypeLeave, server.onLeaveFrame)
+	server.handle(MessageTypeMessage, server.onRoomFrame)
+	server.handle(MessageTypeTyping, server.onTypingFrame)
+	server.handle(MessageTypeDirect, server.onDirectFrame)
+	server.handle(MessageTypeAck, server.onReceiptFrame(StatusDelivered))
+	server.handle(MessageTypeRead, server.onReceiptFrame(StatusRead))
+	server.handle(MessageTypePresence, server.onPresenceFrame)
+	server.handle(MessageTypeQuizCreate, server.onQuizCreateFrame)
+	server.handle(MessageTypeQuizJoin, server.onQuizJoinFrame)
+	server.handle(MessageTypeQuizStart, server.onQuizStartFrame)
+	server.handle(MessageTypeQuizAnswer, server.onQuizAnswerFrame)
+}
+
+// dispatch decodes a client frame and runs the handler of its type, failures are answered with an error frame
+func (server *WebSocketServer) dispatch(c *client, frame []byte) {
+	var env Envelope
+	if err := json.Unmarshal(frame, &env); err != nil || env.Type == "" {
+		messagesIn.With("malformed").Inc()
+		server.sendError(c, 0, &frameError{ErrCodeMalformed, "frame is not a JSON envelope with a type"})
+		return
+	}
+
+	handler, ok := server.handlers[env.Type]
+	if !ok {
+		messagesIn.With("unknown").Inc()
+		server.sendError(c, env.ID, &frameError{ErrCodeUnknownType, "unknown frame type " + strconv.Quote(env.Type)})
+		return
+	}
+	messagesIn.With(env.Type).Inc()
+	if env.Type != MessageTypePresence {
+		server.wake(c)
+	}
+	if err := handler(c, env); err != nil {
+		server.sendError(c, env.ID, err)
+	}
+}
+
+// sendError queues an error frame for a client, transient broker errors are reported as unavailable and other errors
+// that are not frame errors as internal
+func (server *WebSocketServer) sendError(c *client, ref int64, err error) {
+	var fe *frameError
+	if errors.Is(err, broker.ErrUnavailable) || errors.Is(err, broker.ErrQueueFull) {
+		// Both pass on their own, so the client is told to retry rather than an error being logged
+		fe = &frameError{ErrCodeUnavailable, "the message broker is unavailable, try again later"}
+	} else if !errors.As(err, &fe) {
+		slog.Error("Frame handler error", "user", c.userId, "error", err)
+		fe = &frameError{ErrCodeInternal, "the server could not process the frame"}
+	}
+
+	c.enqueue(errorFrame(fe, ref))
+}
+
+// errorFrame encodes the error frame reporting fe, ref is the id of the rejected frame
+func errorFrame(fe *frameError, ref int64) []byte {
+	payload, _ := json.Marshal(ErrorPayload{Code: fe.code, Message: fe.message, Ref: ref})
+	frame, _ := json.Marshal(Envelope{Type: MessageTypeError, TS: time.Now().UnixMilli(), Payload: payload})
+	return frame
+}
+
+// decodePayload unmarshals a frame's payload, reporting failures as invalid payloads
+func decodePayload(env Envelope, v interface{}) error {
+	if len(env.Payload) == 0 {
+		return invalidPayload("payload is required")
+	}
+	if err := json.Unmarshal(env.Payload, v); err != nil {
+		return invalidPayload(err.Error())
+	}
+	return nil
+}
+
+// encodeFrame wraps a message in the envelope sent to clients
+func encodeFrame(msg Message) ([]byte, error) {
+	if msg.TS == 0 {
+		msg.TS = time.Now().UnixMilli()
+	}
+	env := Envelope{Type: msg.Type, ID: msg.ID, TS: msg.TS}
+
+	msg.Type, msg.ID, msg.TS = "", 0, 0
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	env.Payload = payload
+	return json.Marshal(env)
+}
+
+// sender returns the sender id stamped on everything a client publishes
+func sender(c *client) string {
+	return strconv.Itoa(c.userId)
+}
+
+func (server *WebSocketServer) onBroadcastFrame(c *client, env Envelope) error {
+	var p broadcastPayload
+	if err := decodePayload(env, &p); err != nil {
+		return err
+	}
+	return server.publishBroadcast(Message{Type: MessageTypeBroadcast, TS: time.Now().UnixMilli(), SenderID: sender(c), Content: p.Content})
+}
+
+func (server *WebSocketServer) onJoinFrame(c *client, env Envelope) error {
+	var p roomPayload
+	if err := decodePayload(env, &p); err != nil {
+		return err
+	}
+	if p.Room == "" {
+		return invalidPayload("room is required")
+	}
+	return server.joinRoom(c.userId, p.Room)
+}
+
+func (server *WebSocketServer) onLeaveFrame(c *client, env Envelope) error {
+	var p roomPayload
+	if err := decodePayload(env, &p); err != nil {
+		return err
+	}
+	if p.Room == "" {
+		return invalidPayload("room is required")
+	}
+	return server.leaveRoom(c.userId, p.Room)
+}
+
+func (server *WebSocketServer) onRoomFrame(c *client, env Envelope) error {
+	var p roomPayload
+	if err := decodePayload(env, &p); err != nil {
+		return err
+	}
+	if !server.isMember(p.Room, c.userId) {
+		return forbidden("not a member of room " + strconv.Quote(p.Room))
+	}
+	return server.publishToRoom(Message{Type: MessageTypeMessage, TS: time.Now().UnixMilli(), Room: p.Room, SenderID: sender(c), Content: p.Content})
+}
+
+func (server *WebSocketServer) onTypingFrame(c *client, env Envelope) error {
+	var p roomPayload
+	if err := decodePayload(env, &p); err != nil {
+		return err
+	}
+	if !server.isMember(p.Room, c.userId) {
+		return forbidden("not a member of room " + strconv.Quote(p.Room))
+	}
+	return server.publishEphemeral(Message{Type: MessageTypeTyping, TS: time.Now().UnixMilli(), Room: p.Room, SenderID: sender(c)})
+}
+
+func (server *WebSocketServer) onDirectFrame(c *client, env Envelope) error {
+	var p directPayload
+	if err := decodePayload(env, &p); err != nil {
+		return err
+	}
+	if _, err := strconv.Atoi(p.RecipientID); err != nil {
+		return invalidPayload("recipient_id must be a user id")
+	}
+	return server.publishDirect(Message{Type: MessageTypeDirect, TS: time.Now().UnixMilli(), RecipientID: p.RecipientID, SenderID: sender(c), Content: p.Content})
+}
+
+// onReceiptFrame builds the handler of ack and read frames
+func (server *WebSocketServer) onReceiptFrame(status string) frameHandler {
+	return func(c *client, env Envelope) error {
+		var p receiptPayload
+		if err := decodePayload(env, &p); err != nil {
+			return err
+		}
+		err := server.acknowledge(c.userId, p.ID, status)
+		if errors.Is(err, ErrUnknownMessage) {
+			return invalidPayload("unknown message " + strconv.FormatInt(p.ID, 10))
+		}
+		return err
+	}
+}
+
+func (server *WebSocketServer) onPresenceFrame(c *client, env Envelope) error {
+	var p presencePayload
+	if err := decodePayload(env, &p); err != nil {
+		return err
+	}
+	// Clients may only switch between online and away, offline follows from disconnecting
+	if p.Status != PresenceOnline && p.Status != PresenceAway {
+		return invalidPayload("status must be online or away")
+	}
+	c.active()
+	c.away.Store(p.Status == PresenceAway)
+	c.idleAway.Store(false)
+	server.setPresence(c.userId, p.Status)
+	return nil
+}
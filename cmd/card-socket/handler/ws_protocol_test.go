@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cupv/mux/pkg/broker"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// send writes a client frame of the given type
+func send(t *testing.T, conn *websocket.Conn, frameType string, payload interface{}) {
+	raw, err := json.Marshal(payload)
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteJSON(Envelope{Type: frameType, TS: time.Now().UnixMilli(), Payload: raw}))
+}
+
+// readEnvelope reads the next server frame
+func readEnvelope(t *testing.T, conn *websocket.Conn) Envelope {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var env Envelope
+	require.NoError(t, conn.ReadJSON(&env))
+	require.NotZero(t, env.TS)
+	return env
+}
+
+// readMessage reads the next server frame as a message, leaving out the timestamp
+func readMessage(t *testing.T, conn *websocket.Conn) Message {
+	env := readEnvelope(t, conn)
+	var msg Message
+	require.NoError(t, json.Unmarshal(env.Payload, &msg))
+	msg.Type, msg.ID = env.Type, env.ID
+	return msg
+}
+
+func readError(t *testing.T, conn *websocket.Conn) ErrorPayload {
+	env := readEnvelope(t, conn)
+	require.Equal(t, MessageTypeError, env.Type)
+	var payload ErrorPayload
+	require.NoError(t, json.Unmarshal(env.Payload, &payload))
+	return payload
+}
+
+func TestMalformedFramesGetErrorReplies(t *testing.T) {
+	_, ts := startServer(t)
+	alice := connect(t, ts, 1)
+	bob := connect(t, ts, 2)
+
+	require.NoError(t, alice.WriteMessage(websocket.TextMessage, []byte("not json")))
+	assert.Equal(t, ErrCodeMalformed, readError(t, alice).Code)
+
+	require.NoError(t, alice.WriteJSON(Envelope{Type: "teleport", ID: 7}))
+	errPayload := readError(t, alice)
+	assert.Equal(t, ErrCodeUnknownType, errPayload.Code)
+	assert.Equal(t, int64(7), errPayload.Ref)
+
+	require.NoError(t, alice.WriteJSON(Envelope{Type: MessageTypeJoin, Payload: json.RawMessage(`{"room": 42}`)}))
+	assert.Equal(t, ErrCodeInvalid, readError(t, alice).Code)
+
+	send(t, alice, MessageTypeMessage, roomPayload{Room: "deck-1", Content: "hi"})
+	assert.Equal(t, ErrCodeForbidden, readError(t, alice).Code)
+
+	// Nothing malformed was forwarded to other clients
+	bob.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err := bob.ReadMessage()
+	assert.Error(t, err)
+}
+
+func TestBroadcastFramesReachEveryone(t *testing.T) {
+	server, ts := startServer(t)
+	alice := connect(t, ts, 1)
+	bob := connect(t, ts, 2)
+	require.Eventually(t, func() bool { return server.hasClient(1) && server.hasClient(2) }, time.Second, 5*time.Millisecond)
+
+	send(t, alice, MessageTypeBroadcast, broadcastPayload{Content: "hello all"})
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		assert.Equal(t, Message{Type: MessageTypeBroadcast, SenderID: "1", Content: "hello all"}, readMessage(t, conn))
+	}
+}
+
+func TestProtocolNegotiation(t *testing.T) {
+	_, ts := startServer(t)
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	dialer := websocket.Dialer{Subprotocols: []string{"card.v9", ProtocolV1}}
+	conn, _, err := dialer.Dial(url, http.Header{"X-User-Id": {"1"}})
+	require.NoError(t, err)
+	assert.Equal(t, ProtocolV1, conn.Subprotocol())
+	conn.Close()
+
+	dialer = websocket.Dialer{Subprotocols: []string{"card.v9"}}
+	_, resp, err := dialer.Dial(url, http.Header{"X-User-Id": {"1"}})
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestTransientBrokerErrorsAreUnavailable(t *testing.T) {
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	b := broker.NewMemory()
+	t.Cleanup(func() { b.Close() })
+	server := NewWebSocketServer(b, NewMemoryStores(DefaultOptions()), DefaultOptions())
+	for _, err := range []error{
+		fmt.Errorf("%w: connection refused", broker.ErrUnavailable),
+		fmt.Errorf("publish: %w", broker.ErrQueueFull),
+	} {
+		c := newStreamClient(1, server.options)
+		server.sendError(c, 7, err)
+
+		var env Envelope
+		require.NoError(t, json.Unmarshal(<-c.send, &env))
+		var payload ErrorPayload
+		require.NoError(t, json.Unmarshal(env.Payload, &payload))
+		assert.Equal(t, ErrorPayload{Code: ErrCodeUnavailable, Message: "the message broker is unavailable, try again later", Ref: 7}, payload, err)
+	}
+	assert.NotContains(t, logs.String(), "level=ERROR", "clients retry transient errors, they are not server faults")
+}
@@ -0,0 +1,684 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cupv/mux/internal/domain"
+	"github.com/cupv/mux/pkg/broker"
+)
+
+// quizTopic is the broker topic carrying quiz commands to the host's instance and quiz frames back to every instance.
+// A game lives only on the instance it was created on, which applies every command in order, so all players see the same game.
+const quizTopic = "quiz"
+
+// Kinds of messages on the quiz topic
+const (
+	quizKindJoin   = "join"
+	quizKindStart  = "start"
+	quizKindAnswer = "answer"
+	quizKindFrame  = "frame"
+	quizKindOver   = "over"
+)
+
+// Quiz limits
+const (
+	quizCodeTTL          = 2 * time.Hour
+	quizDefaultQuestions = 10
+	quizMaxQuestions     = 50
+	quizChoices          = 4
+	quizMaxPoints        = 1000
+	quizMaxCards         = 500
+)
+
+// quizRoom is the room every player and the host of a game are in
+func quizRoom(code string) string {
+	return "quiz:" + code
+}
+
+// QuizPlayer is a player's standing, LastPoints is what the last question earned
+type QuizPlayer struct {
+	UserID     int    `json:"user_id"`
+	Name       string `json:"name"`
+	Score      int    `json:"score"`
+	LastPoints int    `json:"last_points"`
+	Rank       int    `json:"rank"`
+}
+
+// Payloads of the quiz frames the server pushes
+type QuizLobby struct {
+	Code      string       `json:"code"`
+	Host      int          `json:"host"`
+	Questions int          `json:"questions"`
+	Players   []QuizPlayer `json:"players"`
+}
+
+type QuizQuestion struct {
+	Code     string   `json:"code"`
+	Index    int      `json:"index"`
+	Total    int      `json:"total"`
+	Word     string   `json:"word"`
+	Choices  []string `json:"choices"`
+	Deadline int64    `json:"deadline"`
+}
+
+type QuizAnswered struct {
+	Code     string `json:"code"`
+	Question int    `json:"question"`
+	Accepted bool   `json:"accepted"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type QuizReveal struct {
+	Code        string       `json:"code"`
+	Question    int          `json:"question"`
+	Answer      int          `json:"answer"`
+	Leaderboard []QuizPlayer `json:"leaderboard"`
+}
+
+type QuizOver struct {
+	Code        string       `json:"code"`
+	Leaderboard []QuizPlayer `json:"leaderboard"`
+	Cancelled   bool         `json:"cancelled,omitempty"`
+}
+
+// Payloads of the quiz frames clients send
+type quizCreatePayload struct {
+	CardIDs   []int `json:"card_ids"`
+	Questions int   `json:"questions"`
+}
+
+type quizJoinPayload struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+type quizStartPayload struct {
+	Code string `json:"code"`
+}
+
+type quizAnswerPayload struct {
+	Code     string `json:"code"`
+	Question int    `json:"question"`
+	Choice   int    `json:"choice"`
+}
+
+// quizMessage travels on the quiz topic, commands carry the sender in UserID and frames carry their only recipient, if any
+type quizMessage struct {
+	Kind     string          `json:"kind"`
+	Code     string          `json:"code"`
+	UserID   int             `json:"user_id,omitempty"`
+	Name     string          `json:"name,omitempty"`
+	Question int             `json:"question,omitempty"`
+	Choice   int             `json:"choice,omitempty"`
+	Frame    json.RawMessage `json:"frame,omitempty"`
+}
+
+type quizQuestion struct {
+	word    string
+	choices []string
+	answer  int
+}
+
+// quizGame is the state of a game on its host's instance
+type quizGame struct {
+	mutex     sync.Mutex
+	code      string
+	host      int
+	questions []quizQuestion
+	players   map[int]*QuizPlayer
+	joined    []int // join order, it breaks ties on the leaderboard
+	started   bool
+	current   int // index of the open question, -1 while none is open
+	opened    time.Time
+	deadline  time.Time
+	points    map[int]int // points earned on the open question
+	allIn     chan struct{}
+	expiry    *time.Timer // cancels the game if it is not started in time, guarded by mutex
+	stop      chan struct{}
+	endOnce   sync.Once
+}
+
+func (server *WebSocketServer) onQuizCreateFrame(c *client, env Envelope) error {
+	var p quizCreatePayload
+	if err := decodePayload(env, &p); err != nil {
+		return err
+	}
+	cards, err := server.quizCards(p.CardIDs)
+	if err != nil {
+		return err
+	}
+	questions, err := buildQuestions(cards, p.Questions)
+	if err != nil {
+		return invalidPayload(err.Error())
+	}
+
+	if server.hostedQuizzes(c.userId) >= server.options.QuizMaxGames {
+		return forbidden("too many open games")
+	}
+	code, err := server.claimQuizCode(c.userId)
+	if err != nil {
+		return err
+	}
+	game := &quizGame{
+		code:      code,
+		host:      c.userId,
+		questions: questions,
+		players:   make(map[int]*QuizPlayer),
+		current:   -1,
+		stop:      make(chan struct{}),
+	}
+	server.quizMutex.Lock()
+	server.quizzes[code] = game
+	server.quizMutex.Unlock()
+	game.mutex.Lock()
+	game.expiry = time.AfterFunc(server.options.QuizLobbyTimeout, func() { server.expireQuiz(game) })
+	game.mutex.Unlock()
+
+	if err := server.joinRoom(c.userId, quizRoom(code)); err != nil {
+		return err
+	}
+	frame, err := quizFrame(MessageTypeQuizCreated, game.lobby())
+	if err != nil {
+		return err
+	}
+	c.enqueue(frame)
+	return nil
+}
+
+func (server *WebSocketServer) onQuizJoinFrame(c *client, env Envelope) error {
+	var p quizJoinPayload
+	if err := decodePayload(env, &p); err != nil {
+		return err
+	}
+	host, err := server.quizHost(p.Code)
+	if err != nil {
+		return err
+	}
+	if host == c.userId {
+		return invalidPayload("the host cannot play their own game")
+	}
+	if p.Name == "" {
+		p.Name = "Player " + sender(c)
+	}
+
+	if err := server.joinRoom(c.userId, quizRoom(p.Code)); err != nil {
+		return err
+	}
+	return server.publishQuiz(quizMessage{Kind: quizKindJoin, Code: p.Code, UserID: c.userId, Name: p.Name})
+}
+
+func (server *WebSocketServer) onQuizStartFrame(c *client, env Envelope) error {
+	var p quizStartPayload
+	if err := decodePayload(env, &p); err != nil {
+		return err
+	}
+	host, err := server.quizHost(p.Code)
+	if err != nil {
+		return err
+	}
+	if host != c.userId {
+		return forbidden("only the host can start the game")
+	}
+	return server.publishQuiz(quizMessage{Kind: quizKindStart, Code: p.Code, UserID: c.userId})
+}
+
+func (server *WebSocketServer) onQuizAnswerFrame(c *client, env Envelope) error {
+	var p quizAnswerPayload
+	if err := decodePayload(env, &p); err != nil {
+		return err
+	}
+	if !server.isMember(quizRoom(p.Code), c.userId) {
+		return forbidden("not playing game " + strconv.Quote(p.Code))
+	}
+	return server.publishQuiz(quizMessage{Kind: quizKindAnswer, Code: p.Code, UserID: c.userId, Question: p.Question, Choice: p.Choice})
+}
+
+// quizHost returns the host of a running game, reporting unknown codes as invalid payloads
+func (server *WebSocketServer) quizHost(code string) (int, error) {
+	host, err := server.stores.Quizzes.Host(server.ctx, code)
+	if errors.Is(err, ErrUnknownGame) {
+		return 0, invalidPayload("unknown game " + strconv.Quote(code))
+	}
+	return host, err
+}
+
+// quizCards loads the cards a game is built from out of the card store, so questions and answers are never up to the host
+func (server *WebSocketServer) quizCards(ids []int) ([]domain.Card, error) {
+	if server.stores.Cards == nil {
+		return nil, &frameError{ErrCodeUnavailable, "quizzes need the card store, which is not configured"}
+	}
+	if len(ids) == 0 || len(ids) > quizMaxCards {
+		return nil, invalidPayload(fmt.Sprintf("card_ids must name between 1 and %d cards", quizMaxCards))
+	}
+	cards, err := server.stores.Cards.GetCardsByIDs(server.ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[int]bool, len(cards))
+	for _, card := range cards {
+		found[card.ID] = true
+	}
+	for _, id := range ids {
+		if !found[id] {
+			return nil, invalidPayload(fmt.Sprintf("unknown card %d", id))
+		}
+	}
+	return cards, nil
+}
+
+// hostedQuizzes counts the open games a user hosts on this instance
+func (server *WebSocketServer) hostedQuizzes(host int) int {
+	server.quizMutex.Lock()
+	defer server.quizMutex.Unlock()
+
+	count := 0
+	for _, game := range server.quizzes {
+		if game.host == host {
+			count++
+		}
+	}
+	return count
+}
+
+// claimQuizCode picks a random six digit code that no running game uses
+func (server *WebSocketServer) claimQuizCode(host int) (string, error) {
+	for attempt := 0; attempt < 10; attempt++ {
+		code := fmt.Sprintf("%06d", rand.IntN(1000000))
+		ok, err := server.stores.Quizzes.Create(server.ctx, code, host, quizCodeTTL)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return code, nil
+		}
+	}
+	return "", errors.New("no free quiz code")
+}
+
+// publishQuiz sends a quiz command or frame to every instance
+func (server *WebSocketServer) publishQuiz(m quizMessage) error {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return server.broker.Publish(server.ctx, quizTopic, payload)
+}
+
+// onQuizMessage applies commands to the games hosted here and delivers frames to local players
+func (server *WebSocketServer) onQuizMessage(msg broker.Message) {
+	var m quizMessage
+	if err := json.Unmarshal(msg.Payload, &m); err != nil {
+		slog.WarnContext(msg.Context(), "Invalid quiz message", "error", err)
+		return
+	}
+
+	switch m.Kind {
+	case quizKindFrame, quizKindOver:
+		server.deliverQuizFrame(m)
+		return
+	}
+
+	server.quizMutex.Lock()
+	game, ok := server.quizzes[m.Code]
+	server.quizMutex.Unlock()
+	if !ok {
+		return
+	}
+	switch m.Kind {
+	case quizKindJoin:
+		server.quizJoin(game, m)
+	case quizKindStart:
+		server.quizStart(game, m)
+	case quizKindAnswer:
+		server.quizAnswer(game, m)
+	}
+}
+
+// deliverQuizFrame queues a quiz frame for its recipient or the game's local players, the last frame of a game also ends their membership
+func (server *WebSocketServer) deliverQuizFrame(m quizMessage) {
+	if m.UserID != 0 {
+		server.mutex.RLock()
+		c, ok := server.clients[m.UserID]
+		server.mutex.RUnlock()
+		if ok {
+			c.enqueue(m.Frame)
+		}
+		return
+	}
+
+	room := quizRoom(m.Code)
+	server.deliverFrame(room, 0, 0, m.Frame)
+	if m.Kind != quizKindOver {
+		return
+	}
+
+	server.mutex.RLock()
+	members := make([]int, 0, len(server.rooms[room]))
+	for userId := range server.rooms[room] {
+		members = append(members, userId)
+	}
+	server.mutex.RUnlock()
+	for _, userId := range members {
+		if err := server.leaveRoom(userId, room); err != nil {
+			slog.Error("Quiz room cleanup error", "user", userId, "room", room, "error", err)
+		}
+	}
+}
+
+// sendQuiz publishes a frame of a game to all its players, or only to userId when it is not zero
+func (server *WebSocketServer) sendQuiz(kind string, code string, userId int, frame []byte) {
+	if err := server.publishQuiz(quizMessage{Kind: kind, Code: code, UserID: userId, Frame: frame}); err != nil {
+		slog.Error("Quiz publish error", "quiz", code, "error", err)
+	}
+}
+
+func (server *WebSocketServer) sendQuizFrame(code string, userId int, frameType string, payload interface{}) {
+	frame, err := quizFrame(frameType, payload)
+	if err != nil {
+		slog.Error("Quiz frame error", "quiz", code, "error", err)
+		return
+	}
+	server.sendQuiz(quizKindFrame, code, userId, frame)
+}
+
+// sendQuizError tells a player why a command was refused by the host's instance
+func (server *WebSocketServer) sendQuizError(code string, userId int, message string) {
+	server.sendQuiz(quizKindFrame, code, userId, errorFrame(&frameError{ErrCodeInvalid, message}, 0))
+}
+
+// quizJoin adds a player, players may join late and start from zero
+func (server *WebSocketServer) quizJoin(game *quizGame, m quizMessage) {
+	game.mutex.Lock()
+	if _, ok := game.players[m.UserID]; !ok {
+		game.players[m.UserID] = &QuizPlayer{UserID: m.UserID, Name: m.Name}
+		game.joined = append(game.joined, m.UserID)
+	}
+	lobby := game.lobby()
+	game.mutex.Unlock()
+
+	server.sendQuizFrame(game.code, 0, MessageTypeQuizLobby, lobby)
+}
+
+func (server *WebSocketServer) quizStart(game *quizGame, m quizMessage) {
+	var refusal string
+	game.mutex.Lock()
+	switch {
+	case m.UserID != game.host:
+		refusal = "only the host can start the game"
+	case game.started:
+		refusal = "the game has already started"
+	case len(game.players) == 0:
+		refusal = "no players have joined yet"
+	default:
+		game.started = true
+		if game.expiry != nil {
+			game.expiry.Stop()
+		}
+	}
+	game.mutex.Unlock()
+
+	if refusal != "" {
+		server.sendQuizError(game.code, m.UserID, refusal)
+		return
+	}
+	go server.runQuiz(game)
+}
+
+// quizAnswer records a player's first answer to the open question, correct answers earn more the sooner they arrive
+func (server *WebSocketServer) quizAnswer(game *quizGame, m quizMessage) {
+	result := QuizAnswered{Code: game.code, Question: m.Question}
+
+	game.mutex.Lock()
+	_, answered := game.points[m.UserID]
+	switch {
+	case game.players[m.UserID] == nil:
+		result.Reason = "not a player"
+	case game.current < 0 || game.current != m.Question:
+		result.Reason = "the question is closed"
+	case answered:
+		result.Reason = "already answered"
+	case m.Choice < 0 || m.Choice >= len(game.questions[game.current].choices):
+		result.Reason = "no such choice"
+	default:
+		result.Accepted = true
+		game.points[m.UserID] = game.score(m.Choice, time.Now())
+		if len(game.points) == len(game.players) {
+			// A late joiner may make everyone answered a second time
+			select {
+			case <-game.allIn:
+			default:
+				close(game.allIn)
+			}
+		}
+	}
+	game.mutex.Unlock()
+
+	server.sendQuizFrame(game.code, m.UserID, MessageTypeQuizAnswered, result)
+}
+
+// runQuiz asks every question in turn, closing each at its deadline or once every player has answered
+func (server *WebSocketServer) runQuiz(game *quizGame) {
+	for i := range game.questions {
+		question, allIn := game.open(i, server.options.QuizQuestionTime)
+		server.sendQuizFrame(game.code, 0, MessageTypeQuizQuestion, question)
+
+		timer := time.NewTimer(server.options.QuizQuestionTime)
+		select {
+		case <-timer.C:
+		case <-allIn:
+		case <-game.stop:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+		server.sendQuizFrame(game.code, 0, MessageTypeQuizReveal, game.close())
+
+		if i == len(game.questions)-1 {
+			break
+		}
+		select {
+		case <-time.After(server.options.QuizRevealTime):
+		case <-game.stop:
+			return
+		}
+	}
+	server.endQuiz(game, false)
+}
+
+// endQuiz announces the final leaderboard once and frees the game's code
+func (server *WebSocketServer) endQuiz(game *quizGame, cancelled bool) {
+	game.endOnce.Do(func() {
+		close(game.stop)
+		server.quizMutex.Lock()
+		delete(server.quizzes, game.code)
+		server.quizMutex.Unlock()
+		if err := server.stores.Quizzes.Remove(server.ctx, game.code); err != nil {
+			slog.Error("Quiz store cleanup error", "quiz", game.code, "error", err)
+		}
+
+		game.mutex.Lock()
+		if game.expiry != nil {
+			game.expiry.Stop()
+		}
+		over := QuizOver{Code: game.code, Leaderboard: game.leaderboard(), Cancelled: cancelled}
+		game.mutex.Unlock()
+		frame, err := quizFrame(MessageTypeQuizOver, over)
+		if err != nil {
+			slog.Error("Quiz frame error", "quiz", game.code, "error", err)
+			return
+		}
+		server.sendQuiz(quizKindOver, game.code, 0, frame)
+	})
+}
+
+// expireQuiz cancels a game still waiting in its lobby
+func (server *WebSocketServer) expireQuiz(game *quizGame) {
+	game.mutex.Lock()
+	started := game.started
+	game.mutex.Unlock()
+	if !started {
+		server.endQuiz(game, true)
+	}
+}
+
+// endQuizzes cancels every game hosted on this instance
+func (server *WebSocketServer) endQuizzes() {
+	server.endHostedQuizzes(0)
+}
+
+// endHostedQuizzes cancels the games host runs on this instance, or every game when host is zero
+func (server *WebSocketServer) endHostedQuizzes(host int) {
+	server.quizMutex.Lock()
+	games := make([]*quizGame, 0, len(server.quizzes))
+	for _, game := range server.quizzes {
+		if host == 0 || game.host == host {
+			games = append(games, game)
+		}
+	}
+	server.quizMutex.Unlock()
+
+	for _, game := range games {
+		server.endQuiz(game, true)
+	}
+}
+
+// open starts question i and returns its frame payload and a channel closed once every player has answered
+func (game *quizGame) open(i int, answerTime time.Duration) (QuizQuestion, chan struct{}) {
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+
+	game.current = i
+	game.opened = time.Now()
+	game.deadline = game.opened.Add(answerTime)
+	game.points = make(map[int]int)
+	game.allIn = make(chan struct{})
+
+	q := game.questions[i]
+	return QuizQuestion{
+		Code:     game.code,
+		Index:    i,
+		Total:    len(game.questions),
+		Word:     q.word,
+		Choices:  q.choices,
+		Deadline: game.deadline.UnixMilli(),
+	}, game.allIn
+}
+
+// close ends the open question, adds what it earned to the scores and returns the reveal payload
+func (game *quizGame) close() QuizReveal {
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+
+	for userId, player := range game.players {
+		player.LastPoints = game.points[userId]
+		player.Score += player.LastPoints
+	}
+	reveal := QuizReveal{
+		Code:        game.code,
+		Question:    game.current,
+		Answer:      game.questions[game.current].answer,
+		Leaderboard: game.leaderboard(),
+	}
+	game.current = -1
+	return reveal
+}
+
+// score is half the maximum for a correct answer plus up to the other half for the time left, callers must hold the lock
+func (game *quizGame) score(choice int, at time.Time) int {
+	if choice != game.questions[game.current].answer {
+		return 0
+	}
+	total := game.deadline.Sub(game.opened)
+	left := min(max(game.deadline.Sub(at), 0), total)
+	half := quizMaxPoints / 2
+	return half + int(float64(half)*float64(left)/float64(total))
+}
+
+// lobby describes the game before it starts, callers must hold the lock unless the game is not shared yet
+func (game *quizGame) lobby() QuizLobby {
+	return QuizLobby{Code: game.code, Host: game.host, Questions: len(game.questions), Players: game.leaderboard()}
+}
+
+// leaderboard ranks players by score, equal scores share a rank and keep their join order, callers must hold the lock
+func (game *quizGame) leaderboard() []QuizPlayer {
+	board := make([]QuizPlayer, 0, len(game.joined))
+	for _, userId := range game.joined {
+		board = append(board, *game.players[userId])
+	}
+	sort.SliceStable(board, func(i, j int) bool { return board[i].Score > board[j].Score })
+	for i := range board {
+		board[i].Rank = i + 1
+		if i > 0 && board[i].Score == board[i-1].Score {
+			board[i].Rank = board[i-1].Rank
+		}
+	}
+	return board
+}
+
+// buildQuestions turns a deck into up to count multiple-choice questions: a card's word, its meaning
+// and up to three meanings of other cards as the choices, in random order
+func buildQuestions(cards []domain.Card, count int) ([]quizQuestion, error) {
+	var playable []domain.Card
+	var meanings []string
+	seen := make(map[string]bool)
+	for _, card := range cards {
+		if card.Word == "" || card.Meaning == "" {
+			continue
+		}
+		playable = append(playable, card)
+		if !seen[card.Meaning] {
+			seen[card.Meaning] = true
+			meanings = append(meanings, card.Meaning)
+		}
+	}
+	if len(meanings) < 2 {
+		return nil, errors.New("the deck needs at least two cards with different meanings")
+	}
+
+	if count <= 0 {
+		count = quizDefaultQuestions
+	}
+	count = min(count, quizMaxQuestions, len(playable))
+
+	questions := make([]quizQuestion, 0, count)
+	for _, i := range rand.Perm(len(playable))[:count] {
+		card := playable[i]
+		choices := []string{card.Meaning}
+		for _, j := range rand.Perm(len(meanings)) {
+			if len(choices) == quizChoices {
+				break
+			}
+			if meanings[j] != card.Meaning {
+				choices = append(choices, meanings[j])
+			}
+		}
+		rand.Shuffle(len(choices), func(a, b int) { choices[a], choices[b] = choices[b], choices[a] })
+
+		q := quizQuestion{word: card.Word, choices: choices}
+		for k, choice := range choices {
+			if choice == card.Meaning {
+				q.answer = k
+			}
+		}
+		questions = append(questions, q)
+	}
+	return questions, nil
+}
+
+// quizFrame encodes a quiz frame pushed by the server
+func quizFrame(frameType string, payload interface{}) ([]byte, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Envelope{Type: frameType, TS: time.Now().UnixMilli(), Payload: raw})
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrUnknownGame is returned when no running quiz has the requested code
+var ErrUnknownGame = errors.New("unknown game")
+
+// QuizStore registers live quiz codes where every instance can see them, the game itself lives on the host's instance
+type QuizStore interface {
+	// Create claims a code for a game hosted by host, it reports false when the code is taken
+	Create(ctx context.Context, code string, host int, ttl time.Duration) (bool, error)
+	// Host returns the user hosting a game, or ErrUnknownGame
+	Host(ctx context.Context, code string) (int, error)
+	// Remove frees a code once its game is over
+	Remove(ctx context.Context, code string) error
+}
+
+func quizKey(code string) string {
+	return "ws:quiz:" + code
+}
+
+type redisQuizStore struct {
+	client *redis.Client
+}
+
+// NewRedisQuizStore creates a quiz store shared by every instance through Redis
+func NewRedisQuizStore(client *redis.Client) QuizStore {
+	return &redisQuizStore{client}
+}
+
+func (s *redisQuizStore) Create(ctx context.Context, code string, host int, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, quizKey(code), host, ttl).Result()
+}
+
+func (s *redisQuizStore) Host(ctx context.Context, code string) (int, error) {
+	host, err := s.client.Get(ctx, quizKey(code)).Int()
+	if err == redis.Nil {
+		return 0, ErrUnknownGame
+	}
+	return host, err
+}
+
+func (s *redisQuizStore) Remove(ctx context.Context, code string) error {
+	return s.client.Del(ctx, quizKey(code)).Err()
+}
+
+type memoryQuizGame struct {
+	host    int
+	expires time.Time
+}
+
+type memoryQuizStore struct {
+	mutex sync.Mutex
+	games map[string]memoryQuizGame
+}
+
+// NewMemoryQuizStore creates a quiz store for a single instance
+func NewMemoryQuizStore() QuizStore {
+	return &memoryQuizStore{games: make(map[string]memoryQuizGame)}
+}
+
+func (s *memoryQuizStore) Create(ctx context.Context, code string, host int, ttl time.Duration) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if game, ok := s.games[code]; ok && time.Now().Before(game.expires) {
+		return false, nil
+	}
+	s.games[code] = memoryQuizGame{host: host, expires: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (s *memoryQuizStore) Host(ctx context.Context, code string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	game, ok := s.games[code]
+	if !ok || !time.Now().Before(game.expires) {
+		return 0, ErrUnknownGame
+	}
+	return game.host, nil
+}
+
+func (s *memoryQuizStore) Remove(ctx context.Context, code string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.games, code)
+	return nil
+}
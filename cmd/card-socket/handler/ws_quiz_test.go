@@ -0,0 +1,286 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cupv/mux/internal/domain"
+	"github.com/cupv/mux/pkg/broker"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clusterBroker gives every instance its own in-process broker and publishes to all of them, as Redis would
+type clusterBroker struct {
+	*broker.Memory
+	members *[]*broker.Memory
+}
+
+func (b *clusterBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	for _, member := range *b.members {
+		if err := member.Publish(ctx, topic, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startCluster runs n instances that share stores and a broker
+func startCluster(t *testing.T, n int, opts Options) []*httptest.Server {
+	stores := NewMemoryStores(opts)
+	stores.Cards = quizCards(quizDeck)
+	members := make([]*broker.Memory, 0, n)
+	servers := make([]*httptest.Server, 0, n)
+	for i := 0; i < n; i++ {
+		b := &clusterBroker{Memory: broker.NewMemory(), members: &members}
+		members = append(members, b.Memory)
+		t.Cleanup(func() { b.Close() })
+
+		server := NewWebSocketServer(b, stores, opts)
+		require.NoError(t, server.Start())
+		ts := httptest.NewServer(http.HandlerFunc(server.HandleConnections))
+		t.Cleanup(ts.Close)
+		servers = append(servers, ts)
+	}
+	return servers
+}
+
+// readQuiz skips frames until one of frameType arrives and decodes its payload into v
+func readQuiz(t *testing.T, conn *websocket.Conn, frameType string, v interface{}) {
+	for {
+		env := readEnvelope(t, conn)
+		if env.Type == frameType {
+			require.NoError(t, json.Unmarshal(env.Payload, v))
+			return
+		}
+		require.NotEqual(t, MessageTypeError, env.Type, string(env.Payload))
+	}
+}
+
+// quizCards is a card store holding the given cards
+type quizCards []domain.Card
+
+func (s quizCards) GetCardsByIDs(ctx context.Context, ids []int) ([]domain.Card, error) {
+	var cards []domain.Card
+	for _, card := range s {
+		for _, id := range ids {
+			if card.ID == id {
+				cards = append(cards, card)
+				break
+			}
+		}
+	}
+	return cards, nil
+}
+
+var quizDeck = []domain.Card{
+	{ID: 1, Word: "hund", Meaning: "dog"},
+	{ID: 2, Word: "katze", Meaning: "cat"},
+	{ID: 3, Word: "maus", Meaning: "mouse"},
+	{ID: 4, Word: "vogel", Meaning: "bird"},
+}
+
+var quizDeckIDs = []int{1, 2, 3, 4}
+
+func TestQuizIsPlayedAcrossInstances(t *testing.T) {
+	opts := DefaultOptions()
+	opts.QuizQuestionTime = 300 * time.Millisecond
+	opts.QuizRevealTime = 20 * time.Millisecond
+	instances := startCluster(t, 2, opts)
+
+	host := connect(t, instances[0], 1)
+	ana := connect(t, instances[0], 2)
+	ben := connect(t, instances[1], 3)
+
+	send(t, host, MessageTypeQuizCreate, quizCreatePayload{CardIDs: quizDeckIDs, Questions: 2})
+	var created QuizLobby
+	readQuiz(t, host, MessageTypeQuizCreated, &created)
+	require.Len(t, created.Code, 6)
+	assert.Equal(t, 2, created.Questions)
+
+	// Players join by code on either instance and everyone sees the lobby fill up
+	send(t, ana, MessageTypeQuizJoin, quizJoinPayload{Code: created.Code, Name: "Ana"})
+	send(t, ben, MessageTypeQuizJoin, quizJoinPayload{Code: created.Code, Name: "Ben"})
+	for _, conn := range []*websocket.Conn{host, ana, ben} {
+		var lobby QuizLobby
+		for len(lobby.Players) < 2 {
+			readQuiz(t, conn, MessageTypeQuizLobby, &lobby)
+		}
+	}
+
+	// Only the host may start
+	send(t, ben, MessageTypeQuizStart, quizStartPayload{Code: created.Code})
+	assert.Equal(t, ErrCodeForbidden, readError(t, ben).Code)
+	send(t, host, MessageTypeQuizStart, quizStartPayload{Code: created.Code})
+
+	// Question one: Ana is right, Ben is wrong, and the question closes as soon as both have answered
+	var questions [3]QuizQuestion
+	for i, conn := range []*websocket.Conn{host, ana, ben} {
+		readQuiz(t, conn, MessageTypeQuizQuestion, &questions[i])
+	}
+	q := questions[0]
+	assert.Equal(t, questions[0], questions[2])
+	assert.Equal(t, 0, q.Index)
+	assert.Equal(t, 2, q.Total)
+	right, wrong := answerOf(t, q), (answerOf(t, q)+1)%len(q.Choices)
+
+	send(t, ben, MessageTypeQuizAnswer, quizAnswerPayload{Code: created.Code, Question: 0, Choice: wrong})
+	var answered QuizAnswered
+	readQuiz(t, ben, MessageTypeQuizAnswered, &answered)
+	assert.True(t, answered.Accepted)
+	send(t, ben, MessageTypeQuizAnswer, quizAnswerPayload{Code: created.Code, Question: 0, Choice: right})
+	readQuiz(t, ben, MessageTypeQuizAnswered, &answered)
+	assert.False(t, answered.Accepted)
+	assert.Equal(t, "already answered", answered.Reason)
+	send(t, ana, MessageTypeQuizAnswer, quizAnswerPayload{Code: created.Code, Question: 0, Choice: right})
+
+	var reveals [3]QuizReveal
+	for i, conn := range []*websocket.Conn{host, ana, ben} {
+		readQuiz(t, conn, MessageTypeQuizReveal, &reveals[i])
+	}
+	reveal := reveals[0]
+	assert.Equal(t, reveals[0], reveals[2])
+	assert.Equal(t, right, reveal.Answer)
+	require.Len(t, reveal.Leaderboard, 2)
+	assert.Equal(t, "Ana", reveal.Leaderboard[0].Name)
+	assert.Greater(t, reveal.Leaderboard[0].Score, 500)
+	assert.Equal(t, 0, reveal.Leaderboard[1].Score)
+	assert.Equal(t, 2, reveal.Leaderboard[1].Rank)
+
+	// Question two: nobody answers, so it closes at the deadline and scores stay put
+	readQuiz(t, ben, MessageTypeQuizQuestion, &q)
+	assert.Equal(t, 1, q.Index)
+	var over [3]QuizOver
+	for i, conn := range []*websocket.Conn{host, ana, ben} {
+		readQuiz(t, conn, MessageTypeQuizOver, &over[i])
+	}
+	assert.Equal(t, over[0], over[2])
+	assert.False(t, over[0].Cancelled)
+	assert.Equal(t, reveal.Leaderboard[0].Score, over[0].Leaderboard[0].Score)
+
+	// The code is free again
+	send(t, ben, MessageTypeQuizJoin, quizJoinPayload{Code: created.Code})
+	assert.Equal(t, ErrCodeInvalid, readError(t, ben).Code)
+}
+
+// answerOf finds the right choice of a question over quizDeck
+func answerOf(t *testing.T, q QuizQuestion) int {
+	for _, card := range quizDeck {
+		if card.Word != q.Word {
+			continue
+		}
+		for i, choice := range q.Choices {
+			if choice == card.Meaning {
+				return i
+			}
+		}
+	}
+	t.Fatalf("no right answer to %q among %v", q.Word, q.Choices)
+	return 0
+}
+
+func TestBuildQuestions(t *testing.T) {
+	_, err := buildQuestions([]domain.Card{{Word: "hund", Meaning: "dog"}, {Word: "chien", Meaning: "dog"}}, 5)
+	assert.Error(t, err)
+
+	questions, err := buildQuestions(quizDeck, 0)
+	require.NoError(t, err)
+	assert.Len(t, questions, len(quizDeck))
+	words := make(map[string]bool)
+	for _, q := range questions {
+		words[q.word] = true
+		assert.Len(t, q.choices, quizChoices)
+		assert.NotEqual(t, "", q.choices[q.answer])
+	}
+	assert.Len(t, words, len(quizDeck))
+}
+
+func TestQuizIsCancelledWhenTheHostLeaves(t *testing.T) {
+	instances := startCluster(t, 2, DefaultOptions())
+
+	host := connect(t, instances[0], 1)
+	ana := connect(t, instances[1], 2)
+	send(t, host, MessageTypeQuizCreate, quizCreatePayload{CardIDs: quizDeckIDs})
+	var created QuizLobby
+	readQuiz(t, host, MessageTypeQuizCreated, &created)
+	send(t, ana, MessageTypeQuizJoin, quizJoinPayload{Code: created.Code, Name: "Ana"})
+	var lobby QuizLobby
+	readQuiz(t, ana, MessageTypeQuizLobby, &lobby)
+
+	require.NoError(t, host.Close())
+	var over QuizOver
+	readQuiz(t, ana, MessageTypeQuizOver, &over)
+	assert.True(t, over.Cancelled)
+	assert.Equal(t, created.Code, over.Code)
+
+	// The code is free again
+	send(t, ana, MessageTypeQuizJoin, quizJoinPayload{Code: created.Code})
+	assert.Equal(t, ErrCodeInvalid, readError(t, ana).Code)
+}
+
+func TestQuizLobbyExpires(t *testing.T) {
+	opts := DefaultOptions()
+	opts.QuizLobbyTimeout = 50 * time.Millisecond
+	instances := startCluster(t, 1, opts)
+
+	host := connect(t, instances[0], 1)
+	send(t, host, MessageTypeQuizCreate, quizCreatePayload{CardIDs: quizDeckIDs})
+	var created QuizLobby
+	readQuiz(t, host, MessageTypeQuizCreated, &created)
+
+	var over QuizOver
+	readQuiz(t, host, MessageTypeQuizOver, &over)
+	assert.True(t, over.Cancelled)
+	send(t, host, MessageTypeQuizStart, quizStartPayload{Code: created.Code})
+	assert.Equal(t, ErrCodeInvalid, readError(t, host).Code)
+}
+
+func TestQuizGamesPerHostAreCapped(t *testing.T) {
+	opts := DefaultOptions()
+	opts.QuizMaxGames = 2
+	instances := startCluster(t, 1, opts)
+
+	host := connect(t, instances[0], 1)
+	for i := 0; i < opts.QuizMaxGames; i++ {
+		send(t, host, MessageTypeQuizCreate, quizCreatePayload{CardIDs: quizDeckIDs})
+		var created QuizLobby
+		readQuiz(t, host, MessageTypeQuizCreated, &created)
+	}
+	send(t, host, MessageTypeQuizCreate, quizCreatePayload{CardIDs: quizDeckIDs})
+	assert.Equal(t, ErrCodeForbidden, readError(t, host).Code)
+
+	// Other hosts are not affected
+	other := connect(t, instances[0], 2)
+	send(t, other, MessageTypeQuizCreate, quizCreatePayload{CardIDs: quizDeckIDs})
+	var created QuizLobby
+	readQuiz(t, other, MessageTypeQuizCreated, &created)
+}
+
+func TestQuizIsBuiltFromStoredCards(t *testing.T) {
+	instances := startCluster(t, 1, DefaultOptions())
+	host := connect(t, instances[0], 1)
+
+	send(t, host, MessageTypeQuizCreate, quizCreatePayload{CardIDs: []int{1, 2, 99}})
+	assert.Equal(t, ErrCodeInvalid, readError(t, host).Code, "unknown cards are refused")
+	send(t, host, MessageTypeQuizCreate, quizCreatePayload{})
+	assert.Equal(t, ErrCodeInvalid, readError(t, host).Code, "a game needs cards")
+
+	// Questions come from the stored cards only
+	send(t, host, MessageTypeQuizCreate, quizCreatePayload{CardIDs: []int{1, 2}})
+	var created QuizLobby
+	readQuiz(t, host, MessageTypeQuizCreated, &created)
+	assert.Equal(t, 2, created.Questions)
+}
+
+func TestQuizNeedsTheCardStore(t *testing.T) {
+	_, ts := startServer(t)
+	host := connect(t, ts, 1)
+
+	send(t, host, MessageTypeQuizCreate, quizCreatePayload{CardIDs: quizDeckIDs})
+	assert.Equal(t, ErrCodeUnavailable, readError(t, host).Code)
+}
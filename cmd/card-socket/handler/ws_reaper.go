@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"log/slog"
+	"time"
+)
+
+// reap periodically closes connections that have not been heard from within the pong wait
+func (server *WebSocketServer) reap() {
+	ticker := time.NewTicker(server.options.ReapInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if n := server.reapStale(time.Now()); n > 0 {
+			slog.Info("Reaped stale connections", "count", n)
+		}
+	}
+}
+
+// reapStale closes and unregisters every client idle since before the pong wait and returns how many it closed
+func (server *WebSocketServer) reapStale(now time.Time) int {
+	cutoff := now.Add(-server.options.PongWait)
+
+	server.mutex.RLock()
+	var stale []*client
+	for _, c := range server.clients {
+		if c.idleSince().Before(cutoff) {
+			stale = append(stale, c)
+		}
+	}
+	server.mutex.RUnlock()
+
+	for _, c := range stale {
+		server.unregister(c)
+	}
+	reapedConnections.Add(float64(len(stale)))
+	return len(stale)
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReapStaleClosesSilentClients(t *testing.T) {
+	server, ts := newTestServer(t, DefaultOptions())
+	dial(t, server, ts, 1)
+
+	assert.Equal(t, 0, server.reapStale(time.Now()))
+	assert.True(t, server.hasClient(1))
+
+	before := reapedConnections.Value()
+	assert.Equal(t, 1, server.reapStale(time.Now().Add(2*server.options.PongWait)))
+	assert.False(t, server.hasClient(1))
+	assert.Equal(t, before+1, reapedConnections.Value())
+}
+
+func TestPongsKeepReadingClientAlive(t *testing.T) {
+	opts := DefaultOptions()
+	opts.PingInterval = 20 * time.Millisecond
+	opts.PongWait = 100 * time.Millisecond
+	server, ts := newTestServer(t, opts)
+
+	conn := dial(t, server, ts, 1)
+	// Reading lets the client answer pings with pongs
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, 0, server.reapStale(time.Now()))
+	assert.True(t, server.hasClient(1))
+}
+
+func TestSilentClientTimesOut(t *testing.T) {
+	opts := DefaultOptions()
+	opts.PingInterval = time.Hour
+	opts.PongWait = 100 * time.Millisecond
+	server, ts := newTestServer(t, opts)
+
+	dial(t, server, ts, 1)
+	assert.Eventually(t, func() bool { return !server.hasClient(1) }, 2*time.Second, 10*time.Millisecond)
+}
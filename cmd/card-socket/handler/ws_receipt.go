@@ -0,0 +1,345 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cupv/mux/pkg/broker"
+	"github.com/go-redis/redis/v8"
+)
+
+// receiptTopic is the broker topic carrying receipt events to the sender's instance
+const receiptTopic = "receipts"
+
+// Receipt states, a message is read only after it was delivered
+const (
+	StatusDelivered = "delivered"
+	StatusRead      = "read"
+)
+
+// ErrUnknownMessage is returned when a receipt refers to a message that is not tracked
+var ErrUnknownMessage = errors.New("unknown message")
+
+// statusRank orders receipt states so they only move forward
+func statusRank(status string) int {
+	switch status {
+	case StatusDelivered:
+		return 1
+	case StatusRead:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// ReceiptStore tracks per-recipient delivery and read state of messages
+type ReceiptStore interface {
+	// Track remembers who sent a message and, for direct messages, keeps it pending until acknowledged
+	Track(ctx context.Context, msg Message) error
+	// Lookup returns the tracked header of a message
+	Lookup(ctx context.Context, id int64) (Message, error)
+	// Mark moves a recipient's state forward and reports whether it changed
+	Mark(ctx context.Context, id int64, recipientId int, status string) (bool, error)
+	// Receipts returns the state of every recipient that acknowledged a message
+	Receipts(ctx context.Context, id int64) (map[string]string, error)
+	// Pending returns the direct messages a user has not acknowledged yet, oldest first
+	Pending(ctx context.Context, userId int) ([]Message, error)
+}
+
+func receiptKey(id int64) string {
+	return "ws:receipt:" + strconv.FormatInt(id, 10)
+}
+
+func pendingKey(userId int) string {
+	return "ws:pending:" + strconv.Itoa(userId)
+}
+
+type redisReceiptStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewRedisReceiptStore creates a receipt store shared through Redis that forgets messages after ttl
+func NewRedisReceiptStore(client *redis.Client, ttl time.Duration) ReceiptStore {
+	return &redisReceiptStore{client, ttl}
+}
+
+func (s *redisReceiptStore) Track(ctx context.Context, msg Message) error {
+	key := receiptKey(msg.ID)
+	pipe := s.client.TxPipeline()
+	pipe.HSet(ctx, key, "_sender", msg.SenderID, "_recipient", msg.RecipientID, "_room", msg.Room)
+	pipe.Expire(ctx, key, s.ttl)
+	if msg.Type == MessageTypeDirect {
+		recipientId, err := strconv.Atoi(msg.RecipientID)
+		if err != nil {
+			return err
+		}
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		pipe.HSet(ctx, pendingKey(recipientId), strconv.FormatInt(msg.ID, 10), payload)
+		pipe.Expire(ctx, pendingKey(recipientId), s.ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisReceiptStore) Lookup(ctx context.Context, id int64) (Message, error) {
+	fields, err := s.client.HMGet(ctx, receiptKey(id), "_sender", "_recipient", "_room").Result()
+	if err != nil {
+		return Message{}, err
+	}
+	if fields[0] == nil {
+		return Message{}, ErrUnknownMessage
+	}
+
+	msg := Message{ID: id}
+	msg.SenderID, _ = fields[0].(string)
+	msg.RecipientID, _ = fields[1].(string)
+	msg.Room, _ = fields[2].(string)
+	if msg.Room == "" {
+		msg.Type = MessageTypeDirect
+	} else {
+		msg.Type = MessageTypeMessage
+	}
+	return msg, nil
+}
+
+func (s *redisReceiptStore) Mark(ctx context.Context, id int64, recipientId int, status string) (bool, error) {
+	key := receiptKey(id)
+	field := strconv.Itoa(recipientId)
+
+	current, err := s.client.HGet(ctx, key, field).Result()
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+	if statusRank(status) <= statusRank(current) {
+		return false, nil
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.HSet(ctx, key, field, status)
+	pipe.HDel(ctx, pendingKey(recipientId), strconv.FormatInt(id, 10))
+	_, err = pipe.Exec(ctx)
+	return err == nil, err
+}
+
+func (s *redisReceiptStore) Receipts(ctx context.Context, id int64) (map[string]string, error) {
+	fields, err := s.client.HGetAll(ctx, receiptKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, ErrUnknownMessage
+	}
+
+	receipts := make(map[string]string)
+	for field, status := range fields {
+		if field[0] != '_' {
+			receipts[field] = status
+		}
+	}
+	return receipts, nil
+}
+
+func (s *redisReceiptStore) Pending(ctx context.Context, userId int) ([]Message, error) {
+	payloads, err := s.client.HVals(ctx, pendingKey(userId)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, len(payloads))
+	for _, payload := range payloads {
+		var msg Message
+		if err := json.Unmarshal([]byte(payload), &msg); err == nil {
+			messages = append(messages, msg)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return messages, nil
+}
+
+type trackedMessage struct {
+	header   Message
+	receipts map[string]string
+}
+
+type memoryReceiptStore struct {
+	mutex    sync.Mutex
+	messages map[int64]*trackedMessage
+	pending  map[int]map[int64]Message
+}
+
+// NewMemoryReceiptStore creates a receipt store for a single instance
+func NewMemoryReceiptStore() ReceiptStore {
+	return &memoryReceiptStore{
+		messages: make(map[int64]*trackedMessage),
+		pending:  make(map[int]map[int64]Message),
+	}
+}
+
+func (s *memoryReceiptStore) Track(ctx context.Context, msg Message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.messages[msg.ID] = &trackedMessage{
+		header:   Message{ID: msg.ID, Type: msg.Type, Room: msg.Room, SenderID: msg.SenderID, RecipientID: msg.RecipientID},
+		receipts: make(map[string]string),
+	}
+	if msg.Type == MessageTypeDirect {
+		recipientId, err := strconv.Atoi(msg.RecipientID)
+		if err != nil {
+			return err
+		}
+		if s.pending[recipientId] == nil {
+			s.pending[recipientId] = make(map[int64]Message)
+		}
+		s.pending[recipientId][msg.ID] = msg
+	}
+	return nil
+}
+
+func (s *memoryReceiptStore) Lookup(ctx context.Context, id int64) (Message, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tracked, ok := s.messages[id]
+	if !ok {
+		return Message{}, ErrUnknownMessage
+	}
+	return tracked.header, nil
+}
+
+func (s *memoryReceiptStore) Mark(ctx context.Context, id int64, recipientId int, status string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tracked, ok := s.messages[id]
+	if !ok {
+		return false, ErrUnknownMessage
+	}
+	field := strconv.Itoa(recipientId)
+	if statusRank(status) <= statusRank(tracked.receipts[field]) {
+		return false, nil
+	}
+	tracked.receipts[field] = status
+	delete(s.pending[recipientId], id)
+	return true, nil
+}
+
+func (s *memoryReceiptStore) Receipts(ctx context.Context, id int64) (map[string]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tracked, ok := s.messages[id]
+	if !ok {
+		return nil, ErrUnknownMessage
+	}
+	receipts := make(map[string]string, len(tracked.receipts))
+	for recipient, status := range tracked.receipts {
+		receipts[recipient] = status
+	}
+	return receipts, nil
+}
+
+func (s *memoryReceiptStore) Pending(ctx context.Context, userId int) ([]Message, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	messages := make([]Message, 0, len(s.pending[userId]))
+	for _, msg := range s.pending[userId] {
+		messages = append(messages, msg)
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return messages, nil
+}
+
+// acknowledge records a client's delivery or read receipt and tells the sender about it
+func (server *WebSocketServer) acknowledge(userId int, id int64, status string) error {
+	header, err := server.stores.Receipts.Lookup(server.ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// Only the addressee of a direct message or a member of the room may acknowledge it
+	if header.Type == MessageTypeDirect && header.RecipientID != strconv.Itoa(userId) {
+		return forbidden("not the recipient of this message")
+	}
+	if header.Type == MessageTypeMessage && !server.isMember(header.Room, userId) {
+		return forbidden("not a member of room " + strconv.Quote(header.Room))
+	}
+
+	changed, err := server.stores.Receipts.Mark(server.ctx, id, userId, status)
+	if err != nil || !changed {
+		return err
+	}
+
+	payload, err := json.Marshal(Message{
+		ID:          id,
+		Type:        MessageTypeReceipt,
+		TS:          time.Now().UnixMilli(),
+		Room:        header.Room,
+		RecipientID: header.SenderID,
+		SenderID:    strconv.Itoa(userId),
+		Status:      status,
+	})
+	if err != nil {
+		return err
+	}
+	return server.broker.Publish(server.ctx, receiptTopic, payload)
+}
+
+// onReceipt pushes a receipt event to the original sender when they are connected here
+func (server *WebSocketServer) onReceipt(msg broker.Message) {
+	var receipt Message
+	if err := json.Unmarshal(msg.Payload, &receipt); err != nil {
+		slog.WarnContext(msg.Context(), "Invalid receipt", "error", err)
+		return
+	}
+	senderId, err := strconv.Atoi(receipt.RecipientID)
+	if err != nil {
+		return
+	}
+	frame, err := encodeFrame(receipt)
+	if err != nil {
+		return
+	}
+
+	server.mutex.RLock()
+	c, ok := server.clients[senderId]
+	server.mutex.RUnlock()
+	if ok {
+		c.deliver(0, frame)
+	}
+}
+
+// redeliver queues the direct messages a user has not acknowledged yet and returns the highest id sent,
+// a resuming client only gets those up to lastId because the replay covers the rest
+func (server *WebSocketServer) redeliver(c *client, resuming bool, lastId int64) int64 {
+	pending, err := server.stores.Receipts.Pending(server.ctx, c.userId)
+	if err != nil {
+		slog.Error("Pending lookup error", "user", c.userId, "error", err)
+		return 0
+	}
+
+	var sent int64
+	for _, msg := range pending {
+		if resuming && msg.ID > lastId {
+			break
+		}
+		frame, err := encodeFrame(msg)
+		if err != nil {
+			continue
+		}
+		c.enqueue(frame)
+		sent = msg.ID
+	}
+	return sent
+}
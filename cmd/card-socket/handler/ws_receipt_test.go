@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cupv/mux/pkg/broker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer runs a full server on the in-process broker
+func startServer(t *testing.T) (*WebSocketServer, *httptest.Server) {
+	b := broker.NewMemory()
+	t.Cleanup(func() { b.Close() })
+	server := NewWebSocketServer(b, NewMemoryStores(DefaultOptions()), DefaultOptions())
+	require.NoError(t, server.Start())
+	ts := httptest.NewServer(http.HandlerFunc(server.HandleConnections))
+	t.Cleanup(ts.Close)
+	return server, ts
+}
+
+func TestReceiptsReachTheSender(t *testing.T) {
+	server, ts := startServer(t)
+	alice := connect(t, ts, 1)
+	bob := connect(t, ts, 2)
+
+	send(t, alice, MessageTypeDirect, directPayload{RecipientID: "2", Content: "hello"})
+	msg := readMessage(t, bob)
+	require.NotZero(t, msg.ID)
+
+	send(t, bob, MessageTypeAck, receiptPayload{ID: msg.ID})
+	receipt := readMessage(t, alice)
+	assert.Equal(t, Message{ID: msg.ID, Type: MessageTypeReceipt, RecipientID: "1", SenderID: "2", Status: StatusDelivered}, receipt)
+
+	send(t, bob, MessageTypeRead, receiptPayload{ID: msg.ID})
+	receipt = readMessage(t, alice)
+	assert.Equal(t, StatusRead, receipt.Status)
+
+	receipts, err := server.stores.Receipts.Receipts(context.Background(), msg.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"2": StatusRead}, receipts)
+
+	// Only the addressee may acknowledge a direct message
+	send(t, alice, MessageTypeRead, receiptPayload{ID: msg.ID})
+	receipts, _ = server.stores.Receipts.Receipts(context.Background(), msg.ID)
+	assert.NotContains(t, receipts, "1")
+}
+
+func TestUnacknowledgedDirectMessagesAreRedelivered(t *testing.T) {
+	server, ts := startServer(t)
+	alice := connect(t, ts, 1)
+
+	send(t, alice, MessageTypeDirect, directPayload{RecipientID: "2", Content: "offline"})
+	require.Eventually(t, func() bool {
+		pending, _ := server.stores.Receipts.Pending(context.Background(), 2)
+		return len(pending) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	bob := connect(t, ts, 2)
+	assert.Equal(t, "offline", readMessage(t, bob).Content)
+	bob.Close()
+	require.Eventually(t, func() bool { return !server.hasClient(2) }, time.Second, 5*time.Millisecond)
+
+	// Never acknowledged, so it comes back on the next connection
+	bob = connect(t, ts, 2)
+	msg := readMessage(t, bob)
+	assert.Equal(t, "offline", msg.Content)
+	send(t, bob, MessageTypeAck, receiptPayload{ID: msg.ID})
+	require.Eventually(t, func() bool {
+		pending, _ := server.stores.Receipts.Pending(context.Background(), 2)
+		return len(pending) == 0
+	}, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// roomTopic is the broker topic carrying room messages between instances
+const roomTopic = "rooms"
+
+// roomGracePeriod is how long a disconnected user keeps their room memberships
+const roomGracePeriod = 30 * time.Second
+
+// RoomInfo describes a room and how many members it has
+type RoomInfo struct {
+	Name    string `json:"name"`
+	Members int64  `json:"members"`
+}
+
+// joinRoom adds a user to a room on this instance and in the shared registry
+func (server *WebSocketServer) joinRoom(userId int, room string) error {
+	server.mutex.Lock()
+	server.addLocalMember(room, userId)
+	server.mutex.Unlock()
+
+	return server.stores.Rooms.Join(server.ctx, userId, room)
+}
+
+// leaveRoom removes a user from a room on this instance and in the shared registry
+func (server *WebSocketServer) leaveRoom(userId int, room string) error {
+	server.mutex.Lock()
+	server.removeLocalMember(room, userId)
+	server.mutex.Unlock()
+
+	return server.stores.Rooms.Leave(server.ctx, userId, room)
+}
+
+// addLocalMember records a room membership on this instance, callers must hold the write lock
+func (server *WebSocketServer) addLocalMember(room string, userId int) {
+	members, ok := server.rooms[room]
+	if !ok {
+		members = make(map[int]struct{})
+		server.rooms[room] = members
+	}
+	members[userId] = struct{}{}
+}
+
+// removeLocalMember drops a room membership on this instance, callers must hold the write lock
+func (server *WebSocketServer) removeLocalMember(room string, userId int) {
+	members, ok := server.rooms[room]
+	if !ok {
+		return
+	}
+	delete(members, userId)
+	if len(members) == 0 {
+		delete(server.rooms, room)
+	}
+}
+
+// isMember reports whether a user belongs to a room on this instance
+func (server *WebSocketServer) isMember(room string, userId int) bool {
+	server.mutex.RLock()
+	defer server.mutex.RUnlock()
+	_, ok := server.rooms[room][userId]
+	return ok
+}
+
+// restoreRooms reloads a user's memberships after they (re)connect, cancels any pending expiry and returns the rooms
+func (server *WebSocketServer) restoreRooms(userId int) []string {
+	if err := server.stores.Rooms.Connected(server.ctx, server.instance, userId); err != nil {
+		slog.Error("Room store connection counter error", "user", userId, "error", err)
+	}
+
+	rooms, err := server.stores.Rooms.UserRooms(server.ctx, userId)
+	if err != nil {
+		slog.Error("Room store lookup error", "user", userId, "error", err)
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if timer, ok := server.expiring[userId]; ok {
+		timer.Stop()
+		delete(server.expiring, userId)
+	}
+	for _, room := range rooms {
+		server.addLocalMember(room, userId)
+	}
+	return rooms
+}
+
+// expireRooms keeps a user's memberships for the grace period after they disconnect
+func (server *WebSocketServer) expireRooms(userId int) {
+	if err := server.stores.Rooms.Disconnected(server.ctx, server.instance, userId); err != nil {
+		slog.Error("Room store connection counter error", "user", userId, "error", err)
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if timer, ok := server.expiring[userId]; ok {
+		timer.Stop()
+	}
+	server.expiring[userId] = time.AfterFunc(server.roomGrace, func() {
+		server.dropRooms(userId)
+	})
+}
+
+// dropRooms removes every membership of a user whose grace period ran out
+func (server *WebSocketServer) dropRooms(userId int) {
+	server.mutex.Lock()
+	delete(server.expiring, userId)
+	if _, connected := server.clients[userId]; connected {
+		server.mutex.Unlock()
+		return
+	}
+	for room := range server.rooms {
+		server.removeLocalMember(room, userId)
+	}
+	server.mutex.Unlock()
+
+	// The user may have reconnected to another instance in the meantime
+	conns, err := server.stores.Rooms.Connections(server.ctx, userId)
+	if err == nil && conns > 0 {
+		return
+	}
+
+	rooms, err := server.stores.Rooms.UserRooms(server.ctx, userId)
+	if err != nil {
+		slog.Error("Room store lookup error", "user", userId, "error", err)
+		return
+	}
+	for _, room := range rooms {
+		if err := server.stores.Rooms.Leave(server.ctx, userId, room); err != nil {
+			slog.Error("Room store cleanup error", "user", userId, "room", room, "error", err)
+		}
+	}
+}
+
+// publishToRoom stores a room message in the room's history and sends it to every instance through the broker
+func (server *WebSocketServer) publishToRoom(msg Message) error {
+	id, err := server.stores.History.Append(server.ctx, roomHistoryKey(msg.Room), msg)
+	if err != nil {
+		return err
+	}
+	msg.ID = id
+	if err := server.stores.Receipts.Track(server.ctx, msg); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return server.broker.Publish(server.ctx, roomTopic, payload)
+}
+
+// publishEphemeral sends a room event to every instance without storing it in history
+func (server *WebSocketServer) publishEphemeral(msg Message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return server.broker.Publish(server.ctx, roomTopic, payload)
+}
+
+// deliverToRoom writes a room message to the members connected to this instance
+func (server *WebSocketServer) deliverToRoom(msg Message) {
+	payload, err := encodeFrame(msg)
+	if err != nil {
+		return
+	}
+
+	// Ephemeral events are not echoed back to the user who caused them
+	var skip int
+	if msg.ID == 0 {
+		skip, _ = strconv.Atoi(msg.SenderID)
+	}
+	server.deliverFrame(msg.Room, msg.ID, skip, payload)
+}
+
+// deliverFrame queues an encoded frame for the local members of a room except skip
+func (server *WebSocketServer) deliverFrame(room string, id int64, skip int, frame []byte) {
+	server.mutex.RLock()
+	defer server.mutex.RUnlock()
+
+	for userId := range server.rooms[room] {
+		if userId == skip {
+			continue
+		}
+		if c, ok := server.clients[userId]; ok {
+			c.deliver(id, frame)
+		}
+	}
+}
+
+// ListRooms returns every room known across all instances
+func (server *WebSocketServer) ListRooms() ([]RoomInfo, error) {
+	return server.stores.Rooms.Rooms(server.ctx)
+}
+
+// RoomMembers returns the user ids that belong to a room across all instances
+func (server *WebSocketServer) RoomMembers(room string) ([]int, error) {
+	return server.stores.Rooms.Members(server.ctx, room)
+}
@@ -0,0 +1,374 @@
+package handler
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RoomStore keeps room membership where every instance of the server can see it
+type RoomStore interface {
+	// Join adds a user to a room
+	Join(ctx context.Context, userId int, room string) error
+	// Leave removes a user from a room and forgets the room once it is empty
+	Leave(ctx context.Context, userId int, room string) error
+	// UserRooms returns the rooms a user belongs to
+	UserRooms(ctx context.Context, userId int) ([]string, error)
+	// Connected records a new connection for a user on an instance
+	Connected(ctx context.Context, instance string, userId int) error
+	// Disconnected records that one of a user's connections on an instance closed
+	Disconnected(ctx context.Context, instance string, userId int) error
+	// Connections returns how many connections a user holds across all live instances
+	Connections(ctx context.Context, userId int) (int, error)
+	// Heartbeat keeps an instance alive and releases the connections of instances that stopped
+	// beating, returning the users those instances held
+	Heartbeat(ctx context.Context, instance string) ([]int, error)
+	// Rooms returns every room with its member count
+	Rooms(ctx context.Context) ([]RoomInfo, error)
+	// Members returns the user ids in a room
+	Members(ctx context.Context, room string) ([]int, error)
+}
+
+// Redis keys used to share room membership across instances
+const (
+	roomsKey     = "ws:rooms"
+	instancesKey = "ws:instances"
+)
+
+func roomMembersKey(room string) string {
+	return "ws:room:" + room + ":members"
+}
+
+func userRoomsKey(userId int) string {
+	return "ws:user:" + strconv.Itoa(userId) + ":rooms"
+}
+
+func userConnsKey(userId int) string {
+	return "ws:user:" + strconv.Itoa(userId) + ":conns"
+}
+
+func instanceUsersKey(instance string) string {
+	return "ws:instance:" + instance + ":users"
+}
+
+type redisRoomStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewRedisRoomStore creates a room store shared by every instance through Redis, an instance's
+// connections stop counting once it has not sent a heartbeat for ttl
+func NewRedisRoomStore(client *redis.Client, ttl time.Duration) RoomStore {
+	return &redisRoomStore{client, ttl}
+}
+
+func (s *redisRoomStore) Join(ctx context.Context, userId int, room string) error {
+	pipe := s.client.TxPipeline()
+	pipe.SAdd(ctx, roomsKey, room)
+	pipe.SAdd(ctx, roomMembersKey(room), strconv.Itoa(userId))
+	pipe.SAdd(ctx, userRoomsKey(userId), room)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisRoomStore) Leave(ctx context.Context, userId int, room string) error {
+	pipe := s.client.TxPipeline()
+	pipe.SRem(ctx, roomMembersKey(room), strconv.Itoa(userId))
+	pipe.SRem(ctx, userRoomsKey(userId), room)
+	remaining := pipe.SCard(ctx, roomMembersKey(room))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if remaining.Val() == 0 {
+		return s.client.SRem(ctx, roomsKey, room).Err()
+	}
+	return nil
+}
+
+func (s *redisRoomStore) UserRooms(ctx context.Context, userId int) ([]string, error) {
+	return s.client.SMembers(ctx, userRoomsKey(userId)).Result()
+}
+
+func (s *redisRoomStore) Connected(ctx context.Context, instance string, userId int) error {
+	pipe := s.client.TxPipeline()
+	pipe.ZAdd(ctx, instancesKey, &redis.Z{Score: s.expiry(), Member: instance})
+	pipe.HIncrBy(ctx, userConnsKey(userId), instance, 1)
+	pipe.SAdd(ctx, instanceUsersKey(instance), strconv.Itoa(userId))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisRoomStore) Disconnected(ctx context.Context, instance string, userId int) error {
+	conns, err := s.client.HIncrBy(ctx, userConnsKey(userId), instance, -1).Result()
+	if err != nil || conns > 0 {
+		return err
+	}
+	pipe := s.client.TxPipeline()
+	pipe.HDel(ctx, userConnsKey(userId), instance)
+	pipe.SRem(ctx, instanceUsersKey(instance), strconv.Itoa(userId))
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisRoomStore) Connections(ctx context.Context, userId int) (int, error) {
+	counts, err := s.client.HGetAll(ctx, userConnsKey(userId)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	now := float64(time.Now().UnixMilli())
+	total := 0
+	for instance, count := range counts {
+		expires, err := s.client.ZScore(ctx, instancesKey, instance).Result()
+		if err == redis.Nil || (err == nil && expires <= now) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		conns, _ := strconv.Atoi(count)
+		total += conns
+	}
+	return total, nil
+}
+
+func (s *redisRoomStore) Heartbeat(ctx context.Context, instance string) ([]int, error) {
+	if err := s.client.ZAdd(ctx, instancesKey, &redis.Z{Score: s.expiry(), Member: instance}).Err(); err != nil {
+		return nil, err
+	}
+
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	dead, err := s.client.ZRangeByScore(ctx, instancesKey, &redis.ZRangeBy{Min: "-inf", Max: now}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var released []int
+	for _, lost := range dead {
+		// Only the instance that removes the entry cleans up after it
+		removed, err := s.client.ZRem(ctx, instancesKey, lost).Result()
+		if err != nil {
+			return released, err
+		}
+		if removed == 0 {
+			continue
+		}
+		members, err := s.client.SMembers(ctx, instanceUsersKey(lost)).Result()
+		if err != nil {
+			return released, err
+		}
+		for _, member := range members {
+			userId, err := strconv.Atoi(member)
+			if err != nil {
+				continue
+			}
+			if err := s.client.HDel(ctx, userConnsKey(userId), lost).Err(); err != nil {
+				return released, err
+			}
+			released = append(released, userId)
+		}
+		if err := s.client.Del(ctx, instanceUsersKey(lost)).Err(); err != nil {
+			return released, err
+		}
+	}
+	return released, nil
+}
+
+// expiry returns when an instance that beats now stops counting, in Unix milliseconds
+func (s *redisRoomStore) expiry() float64 {
+	return float64(time.Now().Add(s.ttl).UnixMilli())
+}
+
+func (s *redisRoomStore) Rooms(ctx context.Context) ([]RoomInfo, error) {
+	names, err := s.client.SMembers(ctx, roomsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	rooms := make([]RoomInfo, 0, len(names))
+	for _, name := range names {
+		count, err := s.client.SCard(ctx, roomMembersKey(name)).Result()
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, RoomInfo{Name: name, Members: count})
+	}
+	return rooms, nil
+}
+
+func (s *redisRoomStore) Members(ctx context.Context, room string) ([]int, error) {
+	members, err := s.client.SMembers(ctx, roomMembersKey(room)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(members))
+	for _, member := range members {
+		id, err := strconv.Atoi(member)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+type memoryRoomStore struct {
+	mutex     sync.Mutex
+	ttl       time.Duration
+	rooms     map[string]map[int]struct{}
+	users     map[int]map[string]struct{}
+	conns     map[int]map[string]int
+	instances map[string]time.Time
+}
+
+// NewMemoryRoomStore creates a room store for a single process, an instance's connections stop
+// counting once it has not sent a heartbeat for ttl
+func NewMemoryRoomStore(ttl time.Duration) RoomStore {
+	return &memoryRoomStore{
+		ttl:       ttl,
+		rooms:     make(map[string]map[int]struct{}),
+		users:     make(map[int]map[string]struct{}),
+		conns:     make(map[int]map[string]int),
+		instances: make(map[string]time.Time),
+	}
+}
+
+func (s *memoryRoomStore) Join(ctx context.Context, userId int, room string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.rooms[room] == nil {
+		s.rooms[room] = make(map[int]struct{})
+	}
+	if s.users[userId] == nil {
+		s.users[userId] = make(map[string]struct{})
+	}
+	s.rooms[room][userId] = struct{}{}
+	s.users[userId][room] = struct{}{}
+	return nil
+}
+
+func (s *memoryRoomStore) Leave(ctx context.Context, userId int, room string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.rooms[room], userId)
+	if len(s.rooms[room]) == 0 {
+		delete(s.rooms, room)
+	}
+	delete(s.users[userId], room)
+	if len(s.users[userId]) == 0 {
+		delete(s.users, userId)
+	}
+	return nil
+}
+
+func (s *memoryRoomStore) UserRooms(ctx context.Context, userId int) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rooms := make([]string, 0, len(s.users[userId]))
+	for room := range s.users[userId] {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	return rooms, nil
+}
+
+func (s *memoryRoomStore) Connected(ctx context.Context, instance string, userId int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.instances[instance] = time.Now().Add(s.ttl)
+	if s.conns[userId] == nil {
+		s.conns[userId] = make(map[string]int)
+	}
+	s.conns[userId][instance]++
+	return nil
+}
+
+func (s *memoryRoomStore) Disconnected(ctx context.Context, instance string, userId int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.conns[userId][instance]--
+	if s.conns[userId][instance] <= 0 {
+		delete(s.conns[userId], instance)
+	}
+	if len(s.conns[userId]) == 0 {
+		delete(s.conns, userId)
+	}
+	return nil
+}
+
+func (s *memoryRoomStore) Connections(ctx context.Context, userId int) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	total := 0
+	for instance, conns := range s.conns[userId] {
+		if expires, ok := s.instances[instance]; ok && expires.After(now) {
+			total += conns
+		}
+	}
+	return total, nil
+}
+
+func (s *memoryRoomStore) Heartbeat(ctx context.Context, instance string) ([]int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	s.instances[instance] = now.Add(s.ttl)
+
+	var released []int
+	for lost, expires := range s.instances {
+		if expires.After(now) {
+			continue
+		}
+		delete(s.instances, lost)
+		for userId, conns := range s.conns {
+			if _, ok := conns[lost]; !ok {
+				continue
+			}
+			delete(conns, lost)
+			if len(conns) == 0 {
+				delete(s.conns, userId)
+			}
+			released = append(released, userId)
+		}
+	}
+	sort.Ints(released)
+	return released, nil
+}
+
+func (s *memoryRoomStore) Rooms(ctx context.Context) ([]RoomInfo, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rooms := make([]RoomInfo, 0, len(s.rooms))
+	for name, members := range s.rooms {
+		rooms = append(rooms, RoomInfo{Name: name, Members: int64(len(members))})
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Name < rooms[j].Name })
+	return rooms, nil
+}
+
+func (s *memoryRoomStore) Members(ctx context.Context, room string) ([]int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ids := make([]int, 0, len(s.rooms[room]))
+	for id := range s.rooms[room] {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cupv/mux/pkg/broker"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connect dials a server using the real connection handler
+func connect(t *testing.T, ts *httptest.Server, userId int) *websocket.Conn {
+	header := http.Header{}
+	header.Set("X-User-Id", strconv.Itoa(userId))
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), header)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestRoomMessagesReachOnlyMembers(t *testing.T) {
+	b := broker.NewMemory()
+	defer b.Close()
+	server := NewWebSocketServer(b, NewMemoryStores(DefaultOptions()), DefaultOptions())
+	require.NoError(t, server.Start())
+	ts := httptest.NewServer(http.HandlerFunc(server.HandleConnections))
+	defer ts.Close()
+
+	alice := connect(t, ts, 1)
+	bob := connect(t, ts, 2)
+	carol := connect(t, ts, 3)
+
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		send(t, conn, MessageTypeJoin, roomPayload{Room: "deck-1"})
+	}
+	require.Eventually(t, func() bool {
+		members, _ := server.RoomMembers("deck-1")
+		return len(members) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	send(t, alice, MessageTypeMessage, roomPayload{Room: "deck-1", Content: "hi"})
+
+	got := readMessage(t, bob)
+	assert.Equal(t, "hi", got.Content)
+	assert.Equal(t, "1", got.SenderID)
+
+	carol.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err := carol.ReadMessage()
+	assert.Error(t, err, "non-members must not receive room messages")
+
+	rooms, err := server.ListRooms()
+	require.NoError(t, err)
+	assert.Equal(t, []RoomInfo{{Name: "deck-1", Members: 2}}, rooms)
+
+	send(t, bob, MessageTypeLeave, roomPayload{Room: "deck-1"})
+	assert.Eventually(t, func() bool {
+		members, _ := server.RoomMembers("deck-1")
+		return len(members) == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestLostInstanceReleasesItsUsers(t *testing.T) {
+	opts := DefaultOptions()
+	opts.PresenceTTL = 200 * time.Millisecond
+	opts.PresenceHeartbeat = time.Hour
+	b := broker.NewMemory()
+	defer b.Close()
+	stores := NewMemoryStores(opts)
+	server := NewWebSocketServer(b, stores, opts)
+	require.NoError(t, server.Start())
+	ts := httptest.NewServer(http.HandlerFunc(server.HandleConnections))
+	defer ts.Close()
+
+	// Alice sits on an instance that is killed without disconnecting her
+	ctx := server.ctx
+	require.NoError(t, stores.Rooms.Connected(ctx, "lost", 1))
+	require.NoError(t, stores.Rooms.Join(ctx, 1, "deck-1"))
+	conns, err := stores.Rooms.Connections(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, conns)
+
+	bob := connect(t, ts, 2)
+	send(t, bob, MessageTypeJoin, roomPayload{Room: "deck-1"})
+	require.Eventually(t, func() bool {
+		members, _ := server.RoomMembers("deck-1")
+		return len(members) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		server.beat()
+		members, _ := server.RoomMembers("deck-1")
+		return len(members) == 1
+	}, 2*time.Second, 20*time.Millisecond)
+
+	conns, err = stores.Rooms.Connections(ctx, 1)
+	require.NoError(t, err)
+	assert.Zero(t, conns)
+	rooms, err := stores.Rooms.UserRooms(ctx, 1)
+	require.NoError(t, err)
+	assert.Empty(t, rooms)
+	assert.Equal(t, Message{Type: MessageTypePresence, Room: "deck-1", SenderID: "1", Status: PresenceOffline}, readMessage(t, bob))
+
+	// The surviving instance still counts its own connections
+	conns, err = stores.Rooms.Connections(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, conns)
+}
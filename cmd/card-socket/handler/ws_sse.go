@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HandleEvents streams the feed as Server-Sent Events for clients whose proxies block WebSocket upgrades.
+// Sequenced frames carry their message id as the event id, so a reconnect with Last-Event-ID resumes where the stream stopped.
+func (server *WebSocketServer) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	if server.draining.Load() {
+		server.refuseDraining(w)
+		return
+	}
+	userId, err := server.requestUser(r)
+	if err != nil {
+		http.Error(w, "Invalid stream token", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	c := newStreamClient(userId, server.options)
+	resuming, lastId := resumePoint(r)
+	server.attach(c, resuming, lastId)
+	defer server.detach(c)
+
+	rc := http.NewResponseController(w)
+	ticker := time.NewTicker(c.pingEvery)
+	defer ticker.Stop()
+
+	for leaving := false; !leaving; {
+		var event string
+		frames := 0
+		select {
+		case <-r.Context().Done():
+			return
+		case <-c.done:
+			return
+		case <-ticker.C:
+			// Comments keep proxies from timing the stream out and are ignored by EventSource
+			event = ": ping\n\n"
+		case frame := <-c.send:
+			event, frames = formatEvent(frame), 1
+		case <-c.leaving:
+			// Flush what is queued and tell EventSource when to reconnect
+			for _, frame := range c.pending() {
+				event += formatEvent(frame)
+				frames++
+			}
+			event += fmt.Sprintf("retry: %d\n\n", c.reconnectAfter.Milliseconds())
+			leaving = true
+		}
+
+		rc.SetWriteDeadline(time.Now().Add(c.writeWait))
+		if _, err := io.WriteString(w, event); err != nil {
+			messagesDropped.With(dropDisconnected).Add(float64(frames))
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			messagesDropped.With(dropDisconnected).Add(float64(frames))
+			return
+		}
+		messagesOut.Add(float64(frames))
+		c.touch()
+	}
+}
+
+// HandleFrames accepts a frame posted by a Server-Sent Events or long-polling client, replies arrive on its feed
+func (server *WebSocketServer) HandleFrames(w http.ResponseWriter, r *http.Request) {
+	userId, err := server.requestUser(r)
+	if err != nil {
+		http.Error(w, "Invalid stream token", http.StatusUnauthorized)
+		return
+	}
+	server.mutex.RLock()
+	c, ok := server.clients[userId]
+	server.mutex.RUnlock()
+	if !ok {
+		http.Error(w, "No open feed", http.StatusConflict)
+		return
+	}
+
+	frame, err := io.ReadAll(http.MaxBytesReader(w, r.Body, server.options.MaxMessageSize))
+	if err != nil {
+		http.Error(w, "Frame too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	c.touch()
+	server.dispatch(c, frame)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// formatEvent renders a frame as a Server-Sent Event, using the message id as the event id when it has one
+func formatEvent(frame []byte) string {
+	var envelope struct {
+		ID int64 `json:"id"`
+	}
+	json.Unmarshal(frame, &envelope)
+	if envelope.ID == 0 {
+		return fmt.Sprintf("data: %s\n\n", frame)
+	}
+	return fmt.Sprintf("id: %d\ndata: %s\n\n", envelope.ID, frame)
+}
+
+// resumePoint returns the last message id a client has seen, from Last-Event-ID or the last_id query parameter
+func resumePoint(r *http.Request) (bool, int64) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		if !r.URL.Query().Has("last_id") {
+			return false, 0
+		}
+		value = r.URL.Query().Get("last_id")
+	}
+	lastId, _ := strconv.ParseInt(value, 10, 64)
+	return true, lastId
+}
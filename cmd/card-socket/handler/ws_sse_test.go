@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cupv/mux/pkg/broker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTransports runs a full server with the WebSocket, Server-Sent Events and long-polling endpoints
+func startTransports(t *testing.T, opts Options) (*WebSocketServer, *httptest.Server) {
+	b := broker.NewMemory()
+	t.Cleanup(func() { b.Close() })
+	server := NewWebSocketServer(b, NewMemoryStores(opts), opts)
+	require.NoError(t, server.Start())
+
+	router := http.NewServeMux()
+	router.HandleFunc("/", server.HandleConnections)
+	router.HandleFunc("/events", server.HandleEvents)
+	router.HandleFunc("/events/token", server.HandleStreamToken)
+	router.HandleFunc("/poll", server.HandlePoll)
+	router.HandleFunc("/frames", server.HandleFrames)
+	ts := httptest.NewServer(router)
+	t.Cleanup(ts.Close)
+	return server, ts
+}
+
+// openEvents subscribes to the event stream as a user, resuming after lastEventId when it is not empty
+func openEvents(t *testing.T, ts *httptest.Server, userId int, lastEventId string) *http.Response {
+	req, err := http.NewRequest("GET", ts.URL+"/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-User-Id", strconv.Itoa(userId))
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	require.NoError(t, err)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// readEvent reads the next event from a stream, skipping keep-alive comments
+func readEvent(t *testing.T, reader *bufio.Reader) (string, Envelope) {
+	var id string
+	var env Envelope
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &env))
+		case line == "" && env.Type != "":
+			return id, env
+		}
+	}
+}
+
+// postFrame sends a frame over HTTP as a user and returns the status code
+func postFrame(t *testing.T, ts *httptest.Server, userId int, frameType string, payload interface{}) int {
+	raw, err := json.Marshal(payload)
+	require.NoError(t, err)
+	body, err := json.Marshal(Envelope{Type: frameType, TS: time.Now().UnixMilli(), Payload: raw})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("POST", ts.URL+"/frames", bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("X-User-Id", strconv.Itoa(userId))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestEventStreamResumesFromLastEventID(t *testing.T) {
+	server, ts := startTransports(t, DefaultOptions())
+	alice := connect(t, ts, 1)
+
+	resp := openEvents(t, ts, 2, "")
+	require.Eventually(t, func() bool { return server.hasClient(2) }, time.Second, 5*time.Millisecond)
+
+	send(t, alice, MessageTypeDirect, directPayload{RecipientID: "2", Content: "first"})
+	id, env := readEvent(t, bufio.NewReader(resp.Body))
+	assert.Equal(t, MessageTypeDirect, env.Type)
+	assert.Equal(t, strconv.FormatInt(env.ID, 10), id)
+
+	assert.Equal(t, http.StatusAccepted, postFrame(t, ts, 2, MessageTypeAck, receiptPayload{ID: env.ID}))
+	assert.Equal(t, MessageTypeReceipt, readMessage(t, alice).Type)
+
+	resp.Body.Close()
+	require.Eventually(t, func() bool { return !server.hasClient(2) }, time.Second, 5*time.Millisecond)
+	send(t, alice, MessageTypeDirect, directPayload{RecipientID: "2", Content: "missed"})
+	require.Eventually(t, func() bool {
+		pending, _ := server.stores.Receipts.Pending(context.Background(), 2)
+		return len(pending) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	// Only what came after the last seen event is sent again
+	resp = openEvents(t, ts, 2, id)
+	_, env = readEvent(t, bufio.NewReader(resp.Body))
+	var msg Message
+	require.NoError(t, json.Unmarshal(env.Payload, &msg))
+	assert.Equal(t, "missed", msg.Content)
+}
+
+func TestFramesNeedAnOpenFeed(t *testing.T) {
+	_, ts := startTransports(t, DefaultOptions())
+	assert.Equal(t, http.StatusConflict, postFrame(t, ts, 3, MessageTypeJoin, roomPayload{Room: "deck-1"}))
+}
+
+func TestStreamTokensIdentifyEventSourceClients(t *testing.T) {
+	opts := DefaultOptions()
+	opts.StreamTokenKey = []byte("stream-key")
+	opts.PollTimeout = 50 * time.Millisecond
+	server, ts := startTransports(t, opts)
+
+	// A raw user id in the URL is not an identity
+	resp, err := http.Get(ts.URL + "/poll?user_id=2")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.False(t, server.hasClient(2))
+
+	req, err := http.NewRequest("POST", ts.URL+"/events/token", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-User-Id", "2")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var issued streamTokenResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&issued))
+	assert.WithinDuration(t, time.Now().Add(time.Minute), issued.ExpiresAt, 2*time.Second)
+
+	events, err := http.Get(ts.URL + "/events?token=" + issued.Token)
+	require.NoError(t, err)
+	t.Cleanup(func() { events.Body.Close() })
+	require.Equal(t, http.StatusOK, events.StatusCode)
+	require.Eventually(t, func() bool { return server.hasClient(2) }, time.Second, 5*time.Millisecond)
+
+	// Tokens signed with another key, for another user or past their expiry are refused
+	for _, token := range []string{
+		signStreamToken([]byte("other-key"), 2, time.Now().Add(time.Minute)),
+		strings.Replace(issued.Token, "2.", "3.", 1),
+		signStreamToken(opts.StreamTokenKey, 2, time.Now().Add(-time.Second)),
+	} {
+		resp, err := http.Get(ts.URL + "/events?token=" + token)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, token)
+	}
+
+	anonymous, err := http.Post(ts.URL+"/events/token", "", nil)
+	require.NoError(t, err)
+	anonymous.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, anonymous.StatusCode)
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/cupv/mux/internal/domain"
+	"github.com/go-redis/redis/v8"
+)
+
+// Stores groups the state the server shares with its other instances
+type Stores struct {
+	Rooms    RoomStore
+	History  HistoryStore
+	Receipts ReceiptStore
+	Presence PresenceStore
+	Quizzes  QuizStore
+	// Cards is the card API's store quizzes are built from, quizzes are unavailable without it
+	Cards CardStore
+}
+
+// CardStore loads cards by id, the card repository implements it
+type CardStore interface {
+	GetCardsByIDs(ctx context.Context, ids []int) ([]domain.Card, error)
+}
+
+// NewRedisStores creates stores shared by every instance through Redis
+func NewRedisStores(client *redis.Client, opts Options) Stores {
+	return Stores{
+		Rooms:    NewRedisRoomStore(client, opts.PresenceTTL),
+		History:  NewRedisHistoryStore(client, opts.HistoryLimit),
+		Receipts: NewRedisReceiptStore(client, opts.ReceiptTTL),
+		Presence: NewRedisPresenceStore(client, opts.PresenceTTL),
+		Quizzes:  NewRedisQuizStore(client),
+	}
+}
+
+// NewMemoryStores creates stores for a single instance
+func NewMemoryStores(opts Options) Stores {
+	return Stores{
+		Rooms:    NewMemoryRoomStore(opts.PresenceTTL),
+		History:  NewMemoryHistoryStore(opts.HistoryLimit),
+		Receipts: NewMemoryReceiptStore(),
+		Presence: NewMemoryPresenceStore(opts.PresenceTTL),
+		Quizzes:  NewMemoryQuizStore(),
+	}
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errBadStreamToken is returned for stream tokens that are malformed, expired or not signed with the server's key
+var errBadStreamToken = errors.New("invalid stream token")
+
+// streamTokenResponse is the body returned by HandleStreamToken
+type streamTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// HandleStreamToken issues a short-lived token for the user in X-User-Id, for clients such as EventSource that cannot
+// set headers on /events, /poll and /frames and pass it as the token query parameter instead.
+func (server *WebSocketServer) HandleStreamToken(w http.ResponseWriter, r *http.Request) {
+	if len(server.options.StreamTokenKey) == 0 {
+		http.Error(w, "Stream tokens are not configured", http.StatusNotFound)
+		return
+	}
+	userId, err := strconv.Atoi(r.Header.Get("X-User-Id"))
+	if err != nil || userId <= 0 {
+		http.Error(w, "X-User-Id required", http.StatusUnauthorized)
+		return
+	}
+
+	expiresAt := time.Now().Add(server.options.StreamTokenTTL).Truncate(time.Second)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(streamTokenResponse{
+		Token:     signStreamToken(server.options.StreamTokenKey, userId, expiresAt),
+		ExpiresAt: expiresAt,
+	})
+}
+
+// signStreamToken returns "<user id>.<expiry unix seconds>.<hex HMAC-SHA256 of both>"
+func signStreamToken(key []byte, userId int, expiresAt time.Time) string {
+	claims := strconv.Itoa(userId) + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(claims))
+	return claims + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyStreamToken returns the user a token was issued for, as long as it has not expired
+func verifyStreamToken(key []byte, token string) (int, error) {
+	parts := strings.Split(token, ".")
+	if len(key) == 0 || len(parts) != 3 {
+		return 0, errBadStreamToken
+	}
+	userId, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, errBadStreamToken
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().After(time.Unix(expiry, 0)) {
+		return 0, errBadStreamToken
+	}
+	if !hmac.Equal([]byte(token), []byte(signStreamToken(key, userId, time.Unix(expiry, 0)))) {
+		return 0, errBadStreamToken
+	}
+	return userId, nil
+}
+
+// requestUser is the user a stream request is made for, from X-User-Id like WebSocket upgrades or from a stream token
+func (server *WebSocketServer) requestUser(r *http.Request) (int, error) {
+	if token := r.URL.Query().Get("token"); token != "" && r.Header.Get("X-User-Id") == "" {
+		return verifyStreamToken(server.options.StreamTokenKey, token)
+	}
+	userId, _ := strconv.Atoi(r.Header.Get("X-User-Id"))
+	return userId, nil
+}
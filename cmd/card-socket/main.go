@@ -1,18 +1,237 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"net/http"
+	"log/slog"
+	"os"
+	"strconv"
+
 	"github.com/cupv/mux/cmd/card-socket/handler"
+	"github.com/cupv/mux/internal/config"
+	"github.com/cupv/mux/internal/repository"
+	"github.com/cupv/mux/internal/secrets"
+	"github.com/cupv/mux/pkg/admin"
+	"github.com/cupv/mux/pkg/broker"
+	"github.com/cupv/mux/pkg/health"
+	"github.com/cupv/mux/pkg/lifecycle"
+	"github.com/cupv/mux/pkg/logging"
+	"github.com/cupv/mux/pkg/metrics"
+	mysql "github.com/cupv/mux/pkg/mysql"
+	"github.com/cupv/mux/pkg/trace"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
 )
 
+// streamMaxLen caps every topic's stream when the Redis Streams broker is used
+const streamMaxLen = 10000
+
+// newBroker builds the message broker and shared stores selected by kind
+func newBroker(kind string, rdb *redis.Client, opts handler.Options) (broker.Broker, handler.Stores, error) {
+	switch kind {
+	case "redis":
+		return broker.NewRedisPubSub(rdb), handler.NewRedisStores(rdb, opts), nil
+	case "streams":
+		return broker.NewRedisStreams(rdb, streamMaxLen), handler.NewRedisStores(rdb, opts), nil
+	case "memory":
+		return broker.NewMemory(), handler.NewMemoryStores(opts), nil
+	default:
+		return nil, handler.Stores{}, fmt.Errorf("unknown broker %q", kind)
+	}
+}
+
+// socketOptions maps the ws settings onto the server's options, the rest keep their defaults
+func socketOptions(ws config.WS) handler.Options {
+	opts := handler.DefaultOptions()
+	opts.QueueSize = ws.QueueSize
+	opts.OverflowPolicy = handler.DropOldest
+	if ws.Overflow == "disconnect" {
+		opts.OverflowPolicy = handler.DisconnectSlow
+	}
+	opts.WriteTimeout = ws.WriteTimeout
+	opts.PongWait = ws.PongWait
+	opts.PingInterval = ws.PingInterval
+	opts.MaxMessageSize = int64(ws.MaxMessageSize)
+	opts.ReapInterval = ws.ReapInterval
+	opts.PollTimeout = ws.PollTimeout
+	if ws.StreamTokenKey != "" {
+		opts.StreamTokenKey = []byte(ws.StreamTokenKey)
+	}
+	opts.StreamTokenTTL = ws.StreamTokenTTL
+	return opts
+}
+
+// newRedisClient connects to Redis, authenticating each new connection with the current password so rotation needs no restart
+func newRedisClient(addr string, password *secrets.Value) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr: addr,
+		OnConnect: func(ctx context.Context, cn *redis.Conn) error {
+			if password.Get() == "" {
+				return nil
+			}
+			return cn.Auth(ctx, password.Get()).Err()
+		},
+	})
+}
+
 func main() {
-	server := handler.NewWebSocketServer("localhost:6379","abcde12345-")
-	server.Start()
-	http.HandleFunc("/ws", server.HandleConnections)
-	fmt.Println("WebSocket server started on :8080")
-	err := http.ListenAndServe(":8080", nil)
+	os.Exit(run())
+}
+
+// run wires the server together and returns the exit code once it has stopped
+func run() int {
+	// Load config from defaults, the config file, the environment and flags
+	cfg, err := config.Load(config.Options{
+		Name: "card-socket",
+		Args: os.Args[1:],
+		Defaults: map[string]string{
+			"redis.addr": "localhost:6379",
+		},
+	})
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return lifecycle.ExitConfig
+	}
+	if cfg.Print {
+		fmt.Print(cfg.Redacted())
+		return 0
+	}
+
+	// Set up logger, levels can be changed while running
+	levels := logging.NewLevels(cfg.Log.SlogLevel(), cfg.Log.PackageLevels())
+	logger := logging.New(os.Stdout, cfg.Log.Format, levels)
+	slog.SetDefault(logger)
+
+	// Spans are exported in the background, the tracer is shut down last to flush them
+	tracer, err := cfg.Tracing.Tracer("card-socket")
 	if err != nil {
-		fmt.Println("Error starting server:", err)
+		logger.Error("Failed to set up tracing", "error", err)
+		return lifecycle.ExitStartup
 	}
+	trace.SetDefault(tracer)
+
+	rdb := newRedisClient(cfg.Redis.Addr, cfg.Secret("redis.password"))
+
+	opts := socketOptions(cfg.WS)
+	b, stores, err := newBroker(cfg.Events.Broker, rdb, opts)
+	if err != nil {
+		logger.Error("Failed to create broker", "error", err)
+		return lifecycle.ExitStartup
+	}
+
+	// Quizzes are built from the card API's cards, so they need its database
+	var db *mysql.Database
+	if cfg.DB.Host != "" {
+		db, err = mysql.Open(cfg.DB.User, cfg.Secret("db.password").Get, cfg.DB.Host, cfg.DB.Name)
+		if err != nil {
+			logger.Error("Failed to set up MySQL", "error", err)
+			return lifecycle.ExitStartup
+		}
+		stores.Cards = repository.NewCardRepository(db.Conn)
+	}
+
+	server := handler.NewWebSocketServer(b, stores, opts)
+
+	// Readiness depends on Redis, the broker subscription and MySQL when quizzes use it; the process itself is live while it serves
+	checks := health.NewRegistry()
+	if cfg.Events.Broker != "memory" {
+		checks.Add(health.Readiness, "redis", func(ctx context.Context) error { return rdb.Ping(ctx).Err() })
+	}
+	checks.Add(health.Readiness, "broker", broker.Check(b))
+	if db != nil {
+		checks.Add(health.Readiness, "mysql", health.Ping(db.Conn))
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/livez", checks.Livez).Methods("GET")
+	router.HandleFunc("/readyz", checks.Readyz).Methods("GET")
+	router.Handle("/metrics", metrics.Default.Handler()).Methods("GET")
+
+	// Every other request gets a server span, WebSocket upgrades included, probes and metrics are left out
+	api := router.NewRoute().Subrouter()
+	api.Use(trace.Middleware)
+	api.HandleFunc("/ws", server.HandleConnections)
+	api.HandleFunc("/events", server.HandleEvents).Methods("GET")
+	api.HandleFunc("/events/token", server.HandleStreamToken).Methods("POST")
+	api.HandleFunc("/poll", server.HandlePoll).Methods("GET")
+	api.HandleFunc("/frames", server.HandleFrames).Methods("POST")
+	api.HandleFunc("/rooms", server.GetRooms).Methods("GET")
+	api.HandleFunc("/rooms/{room}/members", server.GetRoomMembers).Methods("GET")
+	api.HandleFunc("/messages/{id}/receipts", server.GetReceipts).Methods("GET")
+	api.HandleFunc("/presence", server.GetPresence).Methods("GET")
+	api.HandleFunc("/health", server.GetHealth).Methods("GET")
+
+	// Requests are labelled with the template of the route they match rather than their path.
+	// Every request gets an ID first, so the access log and every record logged while serving it carry it.
+	httpServer := lifecycle.NewServer(":"+strconv.Itoa(cfg.Port),
+		logging.RequestID(logging.AccessLog(metrics.Default.InstrumentHTTP(router, router), router)))
+
+	// The admin server has its own port and token, and only runs when admin.port is set.
+	// Besides the shared routes it lists this instance's connections and disconnects users.
+	var adminServer *lifecycle.Server
+	if cfg.Admin.Port != 0 {
+		adminRouter := admin.New(admin.Options{
+			Token:  cfg.Secret("admin.token").Get,
+			Levels: levels,
+			Config: cfg.Redacted,
+		})
+		adminRouter.HandleFunc("/connections", server.GetConnections).Methods("GET")
+		adminRouter.HandleFunc("/connections/{user}", server.DisconnectUser).Methods("DELETE")
+		adminServer = lifecycle.NewServer(":"+strconv.Itoa(cfg.Admin.Port),
+			logging.RequestID(logging.AccessLog(adminRouter, adminRouter)))
+	}
+
+	// Components start in this order, each once the previous is ready, and stop in reverse
+	app := lifecycle.New(logger)
+	if tracer != nil {
+		app.Add(lifecycle.Component{Name: "tracer", Stop: tracer.Shutdown})
+	}
+	if adminServer != nil {
+		// Started first and stopped last, so operators can look into the server while it starts and drains
+		app.Add(lifecycle.HTTPServer("admin", adminServer, cfg.WS.DrainTimeout))
+	}
+	if db != nil {
+		app.Add(lifecycle.Component{
+			Name:  "mysql",
+			Start: db.Ping,
+			Stop:  func(ctx context.Context) error { return db.Close() },
+		})
+	}
+	app.Add(
+		lifecycle.Component{
+			// Read secret references again periodically, new connections use the rotated secrets
+			Name: "secrets",
+			Run: func(ctx context.Context) error {
+				cfg.RotateSecrets(ctx)
+				return nil
+			},
+		},
+		lifecycle.Component{
+			Name: "redis",
+			Stop: func(ctx context.Context) error { return rdb.Close() },
+		},
+		lifecycle.Component{
+			Name: "broker",
+			// Subscribing to the broker's topics is what makes the server ready
+			Start: func(ctx context.Context) error { return server.Start() },
+			Stop:  func(ctx context.Context) error { return b.Close() },
+		},
+		lifecycle.HTTPServer("http", httpServer, cfg.WS.DrainTimeout),
+		lifecycle.Component{
+			// Hijacked WebSocket connections are not tracked by http.Server.Shutdown, so clients are drained before it
+			Name:        "clients",
+			Stop:        server.Drain,
+			StopTimeout: cfg.WS.DrainTimeout,
+		},
+	)
+	// Readiness fails until every component has started and again as soon as shutdown begins
+	checks.Gate(app.Ready)
+
+	logger.Info("Starting WebSocket server", "address", httpServer.Addr())
+	return lifecycle.ExitCode(app.Run(context.Background()))
 }
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cupv/mux/cmd/card-socket/handler"
+	"github.com/cupv/mux/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSocketOptions(t *testing.T) {
+	cfg, err := config.Load(config.Options{LookupEnv: func(string) (string, bool) { return "", false }})
+	require.NoError(t, err)
+	assert.Equal(t, handler.DefaultOptions(), socketOptions(cfg.WS), "the ws defaults are the server's")
+
+	opts := socketOptions(config.WS{
+		QueueSize:      64,
+		Overflow:       "disconnect",
+		WriteTimeout:   time.Second,
+		PongWait:       30 * time.Second,
+		PingInterval:   20 * time.Second,
+		MaxMessageSize: 4096,
+		ReapInterval:   5 * time.Second,
+		PollTimeout:    15 * time.Second,
+		StreamTokenKey: "stream-key",
+		StreamTokenTTL: 30 * time.Second,
+	})
+	assert.Equal(t, 64, opts.QueueSize)
+	assert.Equal(t, handler.DisconnectSlow, opts.OverflowPolicy)
+	assert.Equal(t, time.Second, opts.WriteTimeout)
+	assert.Equal(t, 30*time.Second, opts.PongWait)
+	assert.Equal(t, 20*time.Second, opts.PingInterval)
+	assert.Equal(t, int64(4096), opts.MaxMessageSize)
+	assert.Equal(t, 5*time.Second, opts.ReapInterval)
+	assert.Equal(t, 15*time.Second, opts.PollTimeout)
+	assert.Equal(t, []byte("stream-key"), opts.StreamTokenKey)
+	assert.Equal(t, 30*time.Second, opts.StreamTokenTTL)
+	assert.Equal(t, handler.DefaultOptions().HistoryLimit, opts.HistoryLimit)
+}
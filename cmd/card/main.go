@@ -2,128 +2,258 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
+	"strconv"
 	"time"
 
 	"github.com/cupv/mux/internal/config"
 	cardHttp "github.com/cupv/mux/internal/delivery/http"
+	"github.com/cupv/mux/internal/outbox"
 	"github.com/cupv/mux/internal/repository"
+	"github.com/cupv/mux/internal/secrets"
 	"github.com/cupv/mux/internal/usecase"
+	"github.com/cupv/mux/internal/webhook"
+	"github.com/cupv/mux/pkg/admin"
+	"github.com/cupv/mux/pkg/broker"
+	"github.com/cupv/mux/pkg/health"
+	"github.com/cupv/mux/pkg/lifecycle"
+	"github.com/cupv/mux/pkg/logging"
+	"github.com/cupv/mux/pkg/metrics"
 	mysql "github.com/cupv/mux/pkg/mysql"
+	"github.com/cupv/mux/pkg/trace"
+	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 )
 
-// Runner defines the interface for running and shutting down a server
-type Runner interface {
-	ListenAndServe() error
-	Shutdown(ctx context.Context) error
-}
+// eventStreamMaxLen caps the card events stream when the Redis Streams broker is used
+const eventStreamMaxLen = 10000
 
-// Server is a concrete implementation of ServerRunner
-type Server struct {
-	server *http.Server
-}
+// shutdownTimeout bounds how long in-flight requests get to finish
+const shutdownTimeout = 10 * time.Second
 
-// NewRealServer creates a new RealServer instance
-func NewRealServer(addr string, handler http.Handler) *Server {
-	return &Server{
-		server: &http.Server{
-			Addr:    addr,
-			Handler: handler,
-		},
+// newEventBroker creates the broker card events are relayed to, it returns nil when no Redis is configured
+func newEventBroker(cfg *config.Config) (broker.Broker, func(), error) {
+	if cfg.Redis.Addr == "" {
+		return nil, func() {}, nil
 	}
-}
-
-func (s *Server) ListenAndServe() error {
-	return s.server.ListenAndServe()
-}
 
-func (s *Server) Shutdown(ctx context.Context) error {
-	return s.server.Shutdown(ctx)
-}
+	rdb := newRedisClient(cfg.Redis.Addr, cfg.Secret("redis.password"))
+	var b broker.Broker
+	switch cfg.Events.Broker {
+	case "redis":
+		b = broker.NewRedisPubSub(rdb)
+	case "streams":
+		b = broker.NewRedisStreams(rdb, eventStreamMaxLen)
+	default:
+		rdb.Close()
+		return nil, nil, fmt.Errorf("unknown events broker %q", cfg.Events.Broker)
+	}
 
-// setupLogger initializes the structured logger
-func setupLogger() *slog.Logger {
-	return slog.New(slog.NewTextHandler(os.Stdout, nil))
+	closeFn := func() {
+		b.Close()
+		rdb.Close()
+	}
+	return b, closeFn, nil
 }
 
-// serveGracefully manages the server's lifecycle with graceful shutdown
-func serveGracefully(runner Runner, logger *slog.Logger, addr string) int {
-	// Channels for errors and shutdown signals
-	errChan := make(chan error, 1)
-	shutdownChan := make(chan os.Signal, 1)
-	signal.Notify(shutdownChan, syscall.SIGINT, syscall.SIGTERM)
-
-	// Start server in a goroutine
-	go func() {
-		logger.Info("Starting Card Service", "address", addr)
-		if err := runner.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			errChan <- err
-		}
-	}()
-
-	// Wait for either an error or shutdown signal
-	select {
-	case err := <-errChan:
-		logger.Error("Server failed to start", "error", err)
-		return 1
-	case <-shutdownChan:
-		logger.Info("Shutdown signal received, initiating graceful shutdown")
-
-		// Create a context with timeout for shutdown
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		// Perform graceful shutdown
-		if err := runner.Shutdown(ctx); err != nil {
-			logger.Error("Server shutdown failed", "error", err)
-			return 1
-		}
-		logger.Info("Server gracefully stopped")
-		return 0
-	}
+// newRedisClient connects to Redis, authenticating each new connection with the current password so rotation needs no restart
+func newRedisClient(addr string, password *secrets.Value) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr: addr,
+		OnConnect: func(ctx context.Context, cn *redis.Conn) error {
+			if password.Get() == "" {
+				return nil
+			}
+			return cn.Auth(ctx, password.Get()).Err()
+		},
+	})
 }
 
 func main() {
-	// Parse command-line flags for port
-	port := flag.String("port", "8080", "Port to run the server on")
-	flag.Parse()
-
-	// Load config
-	config, err := config.LoadConfig()
+	// Load config from defaults, the config file, the environment and flags
+	opts := config.Options{
+		Name:     "card",
+		Args:     os.Args[1:],
+		Required: []string{"db.host", "db.name", "db.user", "db.password"},
+	}
+	cfg, err := config.Load(opts)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
 	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(lifecycle.ExitConfig)
+	}
+	if cfg.Print {
+		fmt.Print(cfg.Redacted())
 		return
 	}
 
-	// Set up logger
-	logger := setupLogger()
+	// Reload the settings that can change while running on SIGHUP
+	live := newLiveConfig(opts, cfg)
+	reloadCtx, stopReload := context.WithCancel(context.Background())
+	defer stopReload()
+	live.reloadOnHangup(reloadCtx)
+
+	// Set up logger, its levels are read on every record so they can be reloaded
+	logger := logging.New(os.Stdout, cfg.Log.Format, live.Levels())
 	slog.SetDefault(logger)
 
-	// Set up db
-	db, dbErr := mysql.Serve(config.DBUser, config.DBPassword, config.DBHost, config.DBName)
-	if dbErr != nil {
-		return
+	// Spans are exported in the background, the tracer is shut down last to flush them
+	tracer, err := cfg.Tracing.Tracer("card")
+	if err != nil {
+		logger.Error("Failed to set up tracing", "error", err)
+		os.Exit(lifecycle.ExitStartup)
+	}
+	trace.SetDefault(tracer)
+
+	// Set up db, it is connected to when the application starts
+	db, err := mysql.Open(cfg.DB.User, cfg.Secret("db.password").Get, cfg.DB.Host, cfg.DB.Name)
+	if err != nil {
+		logger.Error("Failed to set up MySQL", "error", err)
+		os.Exit(lifecycle.ExitStartup)
+	}
+
+	// Relay card events from the outbox to webhooks, and to the broker when one is configured
+	eventBroker, closeBroker, err := newEventBroker(cfg)
+	if err != nil {
+		logger.Error("Failed to set up card events", "error", err)
+		os.Exit(lifecycle.ExitStartup)
+	}
+
+	webhookRepository := repository.NewWebhookRepository(db.Conn)
+	reviewRepository := repository.NewReviewRepository(db.Conn)
+	webhookOptions := webhook.DefaultOptions()
+	webhookOptions.AllowedNetworks = cfg.Webhooks.Networks()
+	dispatcher := webhook.NewDispatcher(webhookRepository, webhookOptions)
+	// The broker goes first, while it is down rows are retried before any webhook delivery is queued
+	var publishers []outbox.Publisher
+	if eventBroker != nil {
+		publishers = append(publishers, eventBroker)
+	}
+	publishers = append(publishers, dispatcher)
+	relay := outbox.NewRelay(db.Conn, outbox.Fanout(publishers...), outbox.DefaultOptions())
+
+	// Readiness depends on the database, its schema and the broker; the process itself is live while it serves
+	checks := health.NewRegistry()
+	checks.Add(health.Readiness, "mysql", health.Ping(db.Conn))
+	checks.Add(health.Readiness, "schema", repository.CheckSchema(db.Conn))
+	if eventBroker != nil {
+		checks.Add(health.Readiness, "broker", broker.Check(eventBroker))
 	}
-	defer db.Close()
 
 	// Set up layers for clean arch
 	repository := repository.NewCardRepository(db.Conn)
 	service := usecase.NewCardUsecase(repository)
 	handler := cardHttp.NewCardHandler(service)
+	reviewHandler := cardHttp.NewReviewHandler(usecase.NewReviewUsecase(reviewRepository))
+	webhookHandler := cardHttp.NewWebhookHandler(usecase.NewWebhookUsecase(webhookRepository, cfg.Webhooks.Networks()))
 
 	// Initialize router and server
 	router := mux.NewRouter()
 	router.HandleFunc("/cards", handler.GetCards).Methods("GET")
 	router.HandleFunc("/card", handler.Create).Methods("POST")
+	router.HandleFunc("/card/{id}", handler.Update).Methods("PUT")
+	router.HandleFunc("/card/{id}", handler.Delete).Methods("DELETE")
+	router.HandleFunc("/card/{id}/reviews", reviewHandler.Review).Methods("POST")
+	router.HandleFunc("/webhooks", webhookHandler.Register).Methods("POST")
+	router.HandleFunc("/webhooks", webhookHandler.GetWebhooks).Methods("GET")
+	router.HandleFunc("/webhooks/{id}", webhookHandler.Delete).Methods("DELETE")
+	router.HandleFunc("/webhooks/{id}/deliveries", webhookHandler.GetDeliveries).Methods("GET")
+	router.HandleFunc("/webhooks/deliveries/{id}/retry", webhookHandler.Redeliver).Methods("POST")
+	// Every routed request gets a server span, continuing the caller's trace when it sends a traceparent,
+	// and carries the user from X-User-Id so card and review events name who made the change
+	router.Use(trace.Middleware, cardHttp.Identify)
+
+	// CORS wraps the router rather than being router middleware, which does not run for unmatched preflight requests
+	var httpHandler http.Handler = cardHttp.RateLimit(live.Limits)(router)
+	httpHandler = cardHttp.CORS(live.CORSOrigins)(httpHandler)
+
+	// Probes and metrics are served ahead of CORS and rate limiting
+	root := mux.NewRouter()
+	root.HandleFunc("/livez", checks.Livez).Methods("GET")
+	root.HandleFunc("/readyz", checks.Readyz).Methods("GET")
+	root.Handle("/metrics", metrics.Default.Handler()).Methods("GET")
+	root.NotFoundHandler = httpHandler
 
-	addr := ":" + *port
-	server := NewRealServer(addr, router)
+	// Requests are labelled with the template of the route they match rather than their path.
+	// Every request gets an ID first, so the access log and every record logged while serving it carry it.
+	metrics.Default.DBStats(db.Conn)
+	addr := ":" + strconv.Itoa(cfg.Port)
+	server := lifecycle.NewServer(addr,
+		logging.RequestID(logging.AccessLog(metrics.Default.InstrumentHTTP(root, root, router), root, router)))
+	servers := []*lifecycle.Server{server}
+
+	// The admin server has its own port and token, and only runs when admin.port is set
+	var adminServer *lifecycle.Server
+	if cfg.Admin.Port != 0 {
+		adminRouter := admin.New(admin.Options{
+			Token:  cfg.Secret("admin.token").Get,
+			Levels: live.Levels(),
+			Config: func() string { return live.Config().Redacted() },
+		})
+		adminServer = lifecycle.NewServer(":"+strconv.Itoa(cfg.Admin.Port),
+			logging.RequestID(logging.AccessLog(adminRouter, adminRouter)))
+		servers = append(servers, adminServer)
+	}
+
+	// Components start in this order, each once the previous is ready, and stop in reverse
+	app := lifecycle.New(logger)
+	if tracer != nil {
+		app.Add(lifecycle.Component{Name: "tracer", Stop: tracer.Shutdown})
+	}
+	if adminServer != nil {
+		// Started first and stopped last, so operators can look into the server while it starts and drains
+		app.Add(lifecycle.HTTPServer("admin", adminServer, shutdownTimeout))
+	}
+	app.Add(
+		lifecycle.Component{
+			Name:  "mysql",
+			Start: db.Ping,
+			Stop:  func(ctx context.Context) error { return db.Close() },
+		},
+		lifecycle.Component{
+			// Read secret references again periodically, new connections use the rotated secrets
+			Name: "secrets",
+			Run: func(ctx context.Context) error {
+				cfg.RotateSecrets(ctx)
+				return nil
+			},
+		},
+		lifecycle.Component{
+			Name: "broker",
+			Stop: func(ctx context.Context) error {
+				closeBroker()
+				return nil
+			},
+		},
+		lifecycle.Component{
+			Name: "outbox relay",
+			Run: func(ctx context.Context) error {
+				relay.Run(ctx)
+				return nil
+			},
+		},
+		lifecycle.Component{
+			Name: "webhook dispatcher",
+			Run: func(ctx context.Context) error {
+				dispatcher.Run(ctx)
+				return nil
+			},
+		},
+		lifecycle.HTTPServer("http", server, shutdownTimeout),
+	)
+	// SIGUSR2 starts a new binary on the same sockets and drains this one once it is ready
+	app.Add(lifecycle.Upgrade(logger, servers...))
+	// Readiness fails until every component has started and again as soon as shutdown begins
+	checks.Gate(app.Ready)
 
 	// Run server and exit with appropriate code
-	os.Exit(serveGracefully(server, logger, addr))
+	logger.Info("Starting Card Service", "address", addr)
+	os.Exit(lifecycle.ExitCode(app.Run(context.Background())))
 }
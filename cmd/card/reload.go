@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/cupv/mux/internal/config"
+	"github.com/cupv/mux/pkg/logging"
+)
+
+// liveConfig holds the configuration in effect. A reload swaps it as a whole,
+// so a request sees either the old settings or the new ones, never a mix.
+type liveConfig struct {
+	opts    config.Options
+	current atomic.Pointer[config.Config]
+	// levels are the log levels in effect, a reload only touches those whose configuration changed
+	// so levels set at runtime through the admin server survive it
+	levels *logging.Levels
+	// mutex serializes reloads
+	mutex sync.Mutex
+}
+
+func newLiveConfig(opts config.Options, cfg *config.Config) *liveConfig {
+	live := &liveConfig{opts: opts, levels: logging.NewLevels(cfg.Log.SlogLevel(), cfg.Log.PackageLevels())}
+	live.current.Store(cfg)
+	return live
+}
+
+// Config returns the configuration in effect
+func (l *liveConfig) Config() *config.Config {
+	return l.current.Load()
+}
+
+// Levels returns the log levels in effect, they may also be changed at runtime
+func (l *liveConfig) Levels() *logging.Levels {
+	return l.levels
+}
+
+// Limits returns the rate limit in effect
+func (l *liveConfig) Limits() (float64, int) {
+	cfg := l.Config()
+	return cfg.HTTP.RateLimit, cfg.HTTP.RateBurst
+}
+
+// CORSOrigins returns the origins allowed in effect
+func (l *liveConfig) CORSOrigins() []string {
+	return l.Config().HTTP.CORSOrigins
+}
+
+// Enabled reports whether a feature flag is on
+func (l *liveConfig) Enabled(feature string) bool {
+	return l.Config().Features.On(feature)
+}
+
+// Reload loads the configuration again and applies the settings that can change while running.
+// An invalid configuration is rejected as a whole and the current one stays in effect.
+func (l *liveConfig) Reload() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	next, err := config.Load(l.opts)
+	if err != nil {
+		return err
+	}
+	previous := l.Config()
+	applied, changed, restart := previous.Reload(next)
+	l.current.Store(applied)
+	l.applyLevels(previous.Log, applied.Log, changed)
+
+	if len(restart) > 0 {
+		slog.Warn("Configuration changes need a restart to apply", "keys", restart)
+	}
+	slog.Info("Configuration reloaded", "changed", changed)
+	return nil
+}
+
+// applyLevels changes the log levels whose configuration changed from old to new and leaves the others,
+// including those set at runtime, as they are
+func (l *liveConfig) applyLevels(old, new config.Log, changed []string) {
+	if slices.Contains(changed, "log.level") {
+		l.levels.SetDefault(new.SlogLevel())
+	}
+	if !slices.Contains(changed, "log.packages") {
+		return
+	}
+	before, after := old.PackageLevels(), new.PackageLevels()
+	for pkg := range before {
+		if _, ok := after[pkg]; !ok {
+			l.levels.Unset(pkg)
+		}
+	}
+	for pkg, level := range after {
+		if previous, ok := before[pkg]; !ok || previous != level {
+			l.levels.Set(pkg, level)
+		}
+	}
+}
+
+// reloadOnHangup reloads the configuration on every SIGHUP until ctx is done.
+// The signal is caught from when it returns, the reloads happen in the background.
+func (l *liveConfig) reloadOnHangup(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hangup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangup:
+				slog.Info("Hangup signal received, reloading configuration")
+				if err := l.Reload(); err != nil {
+					slog.Error("Configuration reload failed, keeping the current configuration", "error", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cupv/mux/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadOnHangup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "card.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("log:\n  level: info\n"), 0o600))
+	opts := config.Options{
+		Args:      []string{"-config", path},
+		LookupEnv: func(string) (string, bool) { return "", false },
+	}
+	cfg, err := config.Load(opts)
+	require.NoError(t, err)
+
+	live := newLiveConfig(opts, cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	live.reloadOnHangup(ctx)
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+log:
+  level: debug
+  packages: [outbox=warn]
+http:
+  rate_limit: 10
+  rate_burst: 5
+port: 9090
+`), 0o600))
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	assert.Eventually(t, func() bool { return live.Levels().Level() == slog.LevelDebug }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, slog.LevelWarn, live.Levels().For("github.com/cupv/mux/internal/outbox"))
+	rate, burst := live.Limits()
+	assert.Equal(t, 10.0, rate)
+	assert.Equal(t, 5, burst)
+	assert.Equal(t, 8080, live.Config().Port, "the port needs a restart")
+
+	// An invalid configuration is rejected and the current one stays
+	require.NoError(t, os.WriteFile(path, []byte("log:\n  level: loud\n"), 0o600))
+	assert.Error(t, live.Reload())
+	assert.Equal(t, slog.LevelDebug, live.Levels().Level())
+}
+
+func TestReloadKeepsRuntimeLevels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "card.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("log:\n  level: info\n  packages: [outbox=warn]\n"), 0o600))
+	opts := config.Options{
+		Args:      []string{"-config", path},
+		LookupEnv: func(string) (string, bool) { return "", false },
+	}
+	cfg, err := config.Load(opts)
+	require.NoError(t, err)
+	live := newLiveConfig(opts, cfg)
+
+	// An operator turns on debug logging through the admin server
+	live.Levels().SetDefault(slog.LevelDebug)
+	live.Levels().Set("webhook", slog.LevelDebug)
+
+	// Reloading an unrelated change keeps them
+	require.NoError(t, os.WriteFile(path, []byte("log:\n  level: info\n  packages: [outbox=warn]\nfeatures:\n  enabled: [quiz]\n"), 0o600))
+	require.NoError(t, live.Reload())
+	assert.Equal(t, slog.LevelDebug, live.Levels().Level())
+	assert.Equal(t, map[string]slog.Level{"outbox": slog.LevelWarn, "webhook": slog.LevelDebug}, live.Levels().Packages())
+	assert.True(t, live.Enabled("quiz"))
+
+	// Changed levels are applied, the override of a package the file does not name stays
+	require.NoError(t, os.WriteFile(path, []byte("log:\n  level: warn\n  packages: [broker=error]\n"), 0o600))
+	require.NoError(t, live.Reload())
+	assert.Equal(t, slog.LevelWarn, live.Levels().Level())
+	assert.Equal(t, map[string]slog.Level{"broker": slog.LevelError, "webhook": slog.LevelDebug}, live.Levels().Packages())
+	assert.False(t, live.Enabled("quiz"))
+}
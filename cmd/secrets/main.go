@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cupv/mux/internal/secrets"
+)
+
+const usage = `Manage the encrypted secrets file read by enc:// references.
+
+Usage:
+  secrets [flags] keygen              save a new key to the key file, or print it without one
+  secrets [flags] set <name>          store a secret read from stdin
+  secrets [flags] get <name>          print a secret
+  secrets [flags] delete <name>       remove a secret
+  secrets [flags] list                print the secret names
+
+Flags:
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes one command and returns the exit code: 0 on success, 1 when it failed and 2 for bad usage.
+// Output goes to stdout, errors and usage to stderr.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("secrets", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	path := flags.String("file", os.Getenv("SECRETS_FILE"), "Encrypted secrets file (SECRETS_FILE)")
+	keyPath := flags.String("key-file", os.Getenv("SECRETS_KEY_FILE"), "File holding the hex key (SECRETS_KEY_FILE)")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	command, rest := flags.Arg(0), flags.Args()[1:]
+	if command == "keygen" {
+		key, err := secrets.GenerateKey()
+		if err != nil {
+			fmt.Fprintln(stderr, "Error generating key:", err)
+			return 1
+		}
+		if *keyPath == "" {
+			fmt.Fprintln(stdout, key)
+			return 0
+		}
+		if err := writeKey(*keyPath, key); err != nil {
+			fmt.Fprintln(stderr, "Error saving key:", err)
+			return 1
+		}
+		return 0
+	}
+
+	if *path == "" || *keyPath == "" {
+		fmt.Fprintln(stderr, "Error: -file and -key-file are required")
+		return 2
+	}
+	key, err := secrets.ReadKey(*keyPath)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error reading key:", err)
+		return 1
+	}
+	file, err := secrets.NewEncryptedFile(*path, key)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error opening secrets:", err)
+		return 1
+	}
+	values, err := file.Read()
+	if err != nil {
+		fmt.Fprintln(stderr, "Error reading secrets:", err)
+		return 1
+	}
+
+	if command == "list" {
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(stdout, name)
+		}
+		return 0
+	}
+
+	if len(rest) != 1 {
+		flags.Usage()
+		return 2
+	}
+	name := rest[0]
+	switch command {
+	case "get":
+		value, ok := values[name]
+		if !ok {
+			fmt.Fprintln(stderr, "Error:", name, secrets.ErrNotFound)
+			return 1
+		}
+		fmt.Fprintln(stdout, value)
+		return 0
+	case "set":
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			fmt.Fprintln(stderr, "Error reading secret:", err)
+			return 1
+		}
+		values[name] = strings.TrimRight(line, "\r\n")
+	case "delete":
+		delete(values, name)
+	default:
+		flags.Usage()
+		return 2
+	}
+
+	if err := file.Write(values); err != nil {
+		fmt.Fprintln(stderr, "Error writing secrets:", err)
+		return 1
+	}
+	return 0
+}
+
+// writeKey saves a new key readable by its owner only, an existing key file is never replaced
+// since the secrets encrypted with it could not be read any more
+func writeKey(path, key string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, key); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
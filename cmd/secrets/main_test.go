@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cupv/mux/internal/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// secretsCLI runs the command with the given stdin and returns its exit code and output, errors are logged
+func secretsCLI(t *testing.T, stdin string, args ...string) (int, string) {
+	code, stdout, stderr := secretsRun(stdin, args...)
+	if stderr != "" {
+		t.Log(stderr)
+	}
+	return code, stdout
+}
+
+// secretsRun runs the command with the given stdin and returns its exit code, output and errors
+func secretsRun(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestKeygen(t *testing.T) {
+	t.Setenv("SECRETS_FILE", "")
+	t.Setenv("SECRETS_KEY_FILE", "")
+	keyFile := filepath.Join(t.TempDir(), "secrets.key")
+
+	code, out := secretsCLI(t, "", "-key-file", keyFile, "keygen")
+	require.Equal(t, 0, code)
+	assert.Empty(t, out, "a saved key is not printed")
+	info, err := os.Stat(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	key, err := secrets.ReadKey(keyFile)
+	require.NoError(t, err)
+
+	// An existing key is never replaced
+	code, _ = secretsCLI(t, "", "-key-file", keyFile, "keygen")
+	assert.Equal(t, 1, code)
+	again, err := secrets.ReadKey(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, key, again)
+
+	// Without a key file the key is printed
+	code, out = secretsCLI(t, "", "keygen")
+	require.Equal(t, 0, code)
+	assert.Len(t, strings.TrimSpace(out), 2*secrets.KeySize)
+}
+
+func TestSecrets(t *testing.T) {
+	t.Setenv("SECRETS_FILE", "")
+	t.Setenv("SECRETS_KEY_FILE", "")
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "secrets.key")
+	file := filepath.Join(dir, "secrets.enc")
+	flags := []string{"-file", file, "-key-file", keyFile}
+	cli := func(stdin string, args ...string) (int, string) {
+		return secretsCLI(t, stdin, append(flags, args...)...)
+	}
+
+	code, _ := cli("", "keygen")
+	require.Equal(t, 0, code)
+
+	code, _ = cli("swordfish\n", "set", "redis_password")
+	require.Equal(t, 0, code)
+	code, _ = cli("hunter2", "set", "db_password")
+	require.Equal(t, 0, code)
+
+	code, out := cli("", "get", "redis_password")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "swordfish\n", out)
+	code, out = cli("", "list")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "db_password\nredis_password\n", out)
+
+	code, _ = cli("", "delete", "redis_password")
+	assert.Equal(t, 0, code)
+	code, out, errs := secretsRun("", append(flags, "get", "redis_password")...)
+	assert.Equal(t, 1, code)
+	assert.Empty(t, out, "errors never mix with secret values")
+	assert.Equal(t, "Error: redis_password secret not found\n", errs)
+	code, out = cli("", "list")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "db_password\n", out)
+
+	// The file is encrypted
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2")
+
+	// Commands other than keygen need both files
+	code, out, errs = secretsRun("", "-file", file, "list")
+	assert.Equal(t, 2, code)
+	assert.Empty(t, out)
+	assert.Equal(t, "Error: -file and -key-file are required\n", errs)
+}
@@ -1,54 +1,118 @@
 package config
 
 import (
-	"errors"
-	"log"
-	"os"
+	"time"
 
-	"github.com/joho/godotenv"
+	"github.com/cupv/mux/internal/secrets"
 )
 
+// Config is the configuration shared by every binary, each one reads the sections it needs.
+//
+// Every setting has a key (the dotted path in the config file), an environment variable and a flag.
+// They are merged in this order, later layers win:
+//  1. defaults
+//  2. the config file given by -config or CONFIG_FILE, YAML (.yaml, .yml) or TOML (.toml)
+//  3. environment variables, including those in a .env file in the working directory
+//  4. command-line flags
+//
+// Secrets (db.password, redis.password) may be references instead of values: file:///run/secrets/db_password
+// reads a mounted file and enc://db_password a key of the encrypted secrets file. References are read again
+// every secrets.refresh once RotateSecrets runs.
+//
+// Settings tagged reload:"true" can change while the server runs, see Reload.
 type Config struct {
-	DBHost     string
-	DBName     string
-	DBUser     string
-	DBPassword string
-}
-
-func LoadConfig() (*Config, error) {
-
-	err := godotenv.Load()
-
-	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
-		return nil, errors.New("Error loading .env file")
-	}
-
-	// Load environment variables
-	mysqlDatabase := os.Getenv("MYSQL_DATABASE")
-	if mysqlDatabase == "" {
-		log.Fatal("MYSQL_DATABASE not set")
-	}
-
-	mysqlUser := os.Getenv("MYSQL_USER")
-	if mysqlUser == "" {
-		log.Fatal("MYSQL_USER not set")
-	}
-
-	mysqlPassword := os.Getenv("MYSQL_PASSWORD")
-	if mysqlPassword == "" {
-		log.Fatal("MYSQL_PASSWORD not set")
-	}
-
-	mysqlHost := os.Getenv("MYSQL_HOST")
-	if mysqlHost == "" {
-		log.Fatal("MYSQL_HOST not set")
-	}
-
-	return &Config{
-		DBName:     mysqlDatabase,
-		DBUser:     mysqlUser,
-		DBPassword: mysqlPassword,
-		DBHost:     mysqlHost,
-	}, nil
+	File  string `conf:"config_file" env:"CONFIG_FILE" flag:"config" file:"-" usage:"Path of a YAML or TOML config file"`
+	Print bool   `conf:"print_config" env:"-" flag:"print-config" file:"-" usage:"Print the effective configuration with secrets redacted and exit"`
+
+	Port     int      `conf:"port" env:"PORT" flag:"port" default:"8080" usage:"Port to run the server on"`
+	Log      Log      `conf:"log"`
+	HTTP     HTTP     `conf:"http"`
+	DB       DB       `conf:"db"`
+	Redis    Redis    `conf:"redis"`
+	Events   Events   `conf:"events"`
+	Secrets  Secrets  `conf:"secrets"`
+	Tracing  Tracing  `conf:"tracing"`
+	Admin    Admin    `conf:"admin"`
+	Webhooks Webhooks `conf:"webhooks"`
+	WS       WS       `conf:"ws"`
+	Features Features `conf:"features"`
+
+	// sources records which layer set each key, for Redacted
+	sources  map[string]string
+	resolver *secrets.Resolver
+	secrets  map[string]*secrets.Value
+}
+
+type Log struct {
+	Level  string `conf:"level" env:"LOG_LEVEL" default:"info" oneof:"debug info warn error" reload:"true" usage:"Minimum log level"`
+	Format string `conf:"format" env:"LOG_FORMAT" default:"text" oneof:"text json" usage:"Log output format"`
+	// Packages override Level for some packages, e.g. outbox=debug,broker=warn
+	Packages []string `conf:"packages" env:"LOG_PACKAGES" reload:"true" usage:"Per-package log levels, comma-separated package=level"`
+}
+
+type HTTP struct {
+	RateLimit   float64  `conf:"rate_limit" env:"HTTP_RATE_LIMIT" default:"0" reload:"true" usage:"Requests per second allowed per client IP, 0 for no limit"`
+	RateBurst   int      `conf:"rate_burst" env:"HTTP_RATE_BURST" default:"20" reload:"true" usage:"Requests a client IP may make at once above the rate limit"`
+	CORSOrigins []string `conf:"cors_origins" env:"HTTP_CORS_ORIGINS" reload:"true" usage:"Origins browsers may call the API from, comma-separated, * for any"`
+}
+
+type DB struct {
+	Host     string `conf:"host" env:"MYSQL_HOST" usage:"MySQL host"`
+	Name     string `conf:"name" env:"MYSQL_DATABASE" usage:"MySQL database"`
+	User     string `conf:"user" env:"MYSQL_USER" usage:"MySQL user"`
+	Password string `conf:"password" env:"MYSQL_PASSWORD" secret:"true" usage:"MySQL password"`
+}
+
+type Redis struct {
+	// The card API only publishes card events when Addr is set
+	Addr     string `conf:"addr" env:"REDIS_ADDR" flag:"redis-addr" usage:"Redis address"`
+	Password string `conf:"password" env:"REDIS_PASSWORD" flag:"redis-password" secret:"true" usage:"Redis password"`
+}
+
+type Events struct {
+	Broker string `conf:"broker" env:"EVENTS_BROKER" flag:"broker" default:"redis" oneof:"redis streams memory" usage:"Message broker: redis, streams or memory"`
+}
+
+type Secrets struct {
+	File    string        `conf:"file" env:"SECRETS_FILE" usage:"Encrypted secrets file read by enc:// references"`
+	KeyFile string        `conf:"key_file" env:"SECRETS_KEY_FILE" usage:"File holding the hex key of the encrypted secrets file"`
+	Refresh time.Duration `conf:"refresh" env:"SECRETS_REFRESH" default:"1m" usage:"How often secret references are read again"`
+}
+
+type Tracing struct {
+	Exporter    string  `conf:"exporter" env:"TRACING_EXPORTER" default:"none" oneof:"none otlp stdout file" usage:"Where spans go: none, otlp, stdout or file"`
+	Endpoint    string  `conf:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" default:"http://localhost:4318" usage:"OTLP/HTTP collector the otlp exporter sends to"`
+	File        string  `conf:"file" env:"TRACING_FILE" default:"traces.jsonl" usage:"File the file exporter appends to"`
+	SampleRatio float64 `conf:"sample_ratio" env:"TRACING_SAMPLE_RATIO" default:"1" usage:"Share of new traces recorded, from 0 to 1"`
+}
+
+type Webhooks struct {
+	// Webhooks never reach loopback, link-local or private addresses, except in these networks
+	AllowedNetworks []string `conf:"allowed_networks" env:"WEBHOOKS_ALLOWED_NETWORKS" usage:"Private networks webhooks may reach, comma-separated CIDRs"`
+}
+
+// Features are flags turning optional behaviour on, code asks for them by name
+type Features struct {
+	Enabled []string `conf:"enabled" env:"FEATURES" reload:"true" usage:"Feature flags turned on, comma-separated"`
+}
+
+// WS tunes how the socket server treats its clients
+type WS struct {
+	QueueSize      int           `conf:"queue_size" env:"WS_QUEUE_SIZE" default:"256" usage:"Outbound messages a client may have pending"`
+	Overflow       string        `conf:"overflow" env:"WS_OVERFLOW" default:"drop_oldest" oneof:"drop_oldest disconnect" usage:"What happens when a client's queue is full: drop_oldest or disconnect"`
+	WriteTimeout   time.Duration `conf:"write_timeout" env:"WS_WRITE_TIMEOUT" default:"10s" usage:"Bound on every write to a client"`
+	PongWait       time.Duration `conf:"pong_wait" env:"WS_PONG_WAIT" default:"60s" usage:"How long a client may stay silent before it is dropped"`
+	PingInterval   time.Duration `conf:"ping_interval" env:"WS_PING_INTERVAL" default:"54s" usage:"How often clients are pinged, shorter than ws.pong_wait"`
+	MaxMessageSize int           `conf:"max_message_size" env:"WS_MAX_MESSAGE_SIZE" default:"65536" usage:"Largest frame in bytes a client may send"`
+	ReapInterval   time.Duration `conf:"reap_interval" env:"WS_REAP_INTERVAL" default:"30s" usage:"How often stale connections are looked for"`
+	PollTimeout    time.Duration `conf:"poll_timeout" env:"WS_POLL_TIMEOUT" default:"25s" usage:"How long a long-poll waits for frames, shorter than ws.pong_wait"`
+	DrainTimeout   time.Duration `conf:"drain_timeout" env:"WS_DRAIN_TIMEOUT" default:"10s" usage:"How long clients get to flush their queues on shutdown"`
+	StreamTokenKey string        `conf:"stream_token_key" env:"WS_STREAM_TOKEN_KEY" secret:"true" usage:"Key that signs stream tokens for EventSource and long-polling clients, empty to turn tokens off"`
+	StreamTokenTTL time.Duration `conf:"stream_token_ttl" env:"WS_STREAM_TOKEN_TTL" default:"1m" usage:"How long a stream token stays valid"`
+}
+
+type Admin struct {
+	// The admin server only runs when Port is set, on its own listener so it can be kept off public networks
+	Port  int    `conf:"port" env:"ADMIN_PORT" default:"0" usage:"Port of the admin server, 0 to turn it off"`
+	Token string `conf:"token" env:"ADMIN_TOKEN" secret:"true" usage:"Bearer token the admin server requires"`
 }
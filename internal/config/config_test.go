@@ -0,0 +1,252 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cupv/mux/internal/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// env fakes the environment
+func env(values map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := values[key]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "card.yaml", `
+port: 9000
+log:
+  level: debug
+db:
+  host: file-host
+  name: cards
+redis:
+  addr: file-redis:6379
+`)
+
+	cfg, err := Load(Options{
+		Args:      []string{"-config", path, "-redis-addr", "flag-redis:6379"},
+		LookupEnv: env(map[string]string{"MYSQL_HOST": "env-host", "PORT": "9100"}),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 9100, cfg.Port, "env beats file")
+	assert.Equal(t, "debug", cfg.Log.Level, "file beats default")
+	assert.Equal(t, "text", cfg.Log.Format, "default")
+	assert.Equal(t, "env-host", cfg.DB.Host, "env beats file")
+	assert.Equal(t, "cards", cfg.DB.Name)
+	assert.Equal(t, "flag-redis:6379", cfg.Redis.Addr, "flag beats file")
+	assert.Equal(t, "redis", cfg.Events.Broker)
+}
+
+func TestLoadBinaryDefaults(t *testing.T) {
+	cfg, err := Load(Options{
+		Defaults:  map[string]string{"redis.addr": "localhost:6379"},
+		LookupEnv: env(nil),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:6379", cfg.Redis.Addr)
+	assert.Equal(t, 8080, cfg.Port)
+}
+
+func TestLoadTOMLFromEnv(t *testing.T) {
+	path := writeFile(t, "card.toml", `
+port = 7000
+
+[events]
+broker = "streams"
+`)
+
+	cfg, err := Load(Options{LookupEnv: env(map[string]string{"CONFIG_FILE": path})})
+	require.NoError(t, err)
+	assert.Equal(t, 7000, cfg.Port)
+	assert.Equal(t, "streams", cfg.Events.Broker)
+	assert.Equal(t, path, cfg.File)
+}
+
+func TestLoadAggregatesProblems(t *testing.T) {
+	path := writeFile(t, "card.yaml", "colour: blue\n")
+
+	_, err := Load(Options{
+		Args:     []string{"-config", path, "-broker", "kafka"},
+		Required: []string{"db.host", "db.password"},
+		LookupEnv: env(map[string]string{
+			"PORT":                      "eighty",
+			"LOG_LEVEL":                 "loud",
+			"TRACING_SAMPLE_RATIO":      "1.5",
+			"LOG_PACKAGES":              "outbox",
+			"ADMIN_PORT":                "8080",
+			"WEBHOOKS_ALLOWED_NETWORKS": "10.20.0.0/16,10.30.0.1",
+		}),
+	})
+
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	assert.Len(t, verr.Problems, 11)
+	for _, want := range []string{
+		"colour: unknown key",
+		`port: invalid value "eighty" from env PORT`,
+		"db.host: required, set MYSQL_HOST, -db-host, db.host in the config file",
+		"db.password: required",
+		`log.level: "loud" is not one of debug, info, warn, error`,
+		`events.broker: "kafka" is not one of redis, streams, memory`,
+		"tracing.sample_ratio: 1.5 is not between 0 and 1",
+		`log.packages: "outbox" is not package=level`,
+		"admin.port: 8080 is the port of the server",
+		"admin.token: required when admin.port is set, set ADMIN_TOKEN, -admin-token",
+		`webhooks.allowed_networks: netip.ParsePrefix("10.30.0.1"): no '/'`,
+	} {
+		assert.Contains(t, err.Error(), want)
+	}
+}
+
+func TestLoadHelp(t *testing.T) {
+	_, err := Load(Options{Args: []string{"-h"}, LookupEnv: env(nil), Output: io.Discard})
+	assert.True(t, errors.Is(err, flag.ErrHelp))
+}
+
+func TestRedacted(t *testing.T) {
+	cfg, err := Load(Options{
+		Args:      []string{"-db-password", "hunter2"},
+		LookupEnv: env(map[string]string{"REDIS_PASSWORD": "swordfish"}),
+	})
+	require.NoError(t, err)
+
+	out := cfg.Redacted()
+	assert.NotContains(t, out, "hunter2")
+	assert.NotContains(t, out, "swordfish")
+	assert.Regexp(t, `db.password += \*+ +# flag -db-password`, out)
+	assert.Regexp(t, `redis.password += \*+ +# env REDIS_PASSWORD`, out)
+	assert.Regexp(t, `port += 8080 +# default`, out)
+}
+
+func TestLoadResolvesSecretReferences(t *testing.T) {
+	dir := t.TempDir()
+	dbPassword := filepath.Join(dir, "db_password")
+	require.NoError(t, os.WriteFile(dbPassword, []byte("hunter2\n"), 0o600))
+	key, err := secrets.GenerateKey()
+	require.NoError(t, err)
+	keyFile := writeFile(t, "key", key)
+	rawKey, err := secrets.ReadKey(keyFile)
+	require.NoError(t, err)
+	secretsFile := filepath.Join(dir, "secrets.enc")
+	encrypted, err := secrets.NewEncryptedFile(secretsFile, rawKey)
+	require.NoError(t, err)
+	require.NoError(t, encrypted.Write(map[string]string{"redis": "swordfish"}))
+
+	cfg, err := Load(Options{
+		Args: []string{"-db-password", "file://" + dbPassword},
+		LookupEnv: env(map[string]string{
+			"REDIS_PASSWORD":   "enc://redis",
+			"SECRETS_FILE":     secretsFile,
+			"SECRETS_KEY_FILE": keyFile,
+		}),
+		Required: []string{"db.password"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", cfg.DB.Password)
+	assert.Equal(t, "swordfish", cfg.Redis.Password)
+	assert.Contains(t, cfg.Redacted(), "enc://redis")
+
+	// Rewriting the secrets rotates them
+	require.NoError(t, os.WriteFile(dbPassword, []byte("hunter3"), 0o600))
+	require.NoError(t, cfg.resolver.Refresh(context.Background()))
+	assert.Equal(t, "hunter3", cfg.Secret("db.password").Get())
+	assert.Equal(t, "swordfish", cfg.Secret("redis.password").Get())
+
+	// A reference that does not resolve fails the load
+	_, err = Load(Options{LookupEnv: env(map[string]string{"MYSQL_PASSWORD": "file://" + dbPassword + ".missing"})})
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	assert.Contains(t, verr.Problems[0], "db.password: secret file://")
+}
+
+func TestReload(t *testing.T) {
+	path := writeFile(t, "card.yaml", "log:\n  level: info\nport: 8080\n")
+	opts := Options{Args: []string{"-config", path}, LookupEnv: env(nil)}
+	cfg, err := Load(opts)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+log:
+  level: debug
+  format: json
+http:
+  rate_limit: 5
+  cors_origins: [https://app.example.com]
+port: 9090
+`), 0o600))
+	next, err := Load(opts)
+	require.NoError(t, err)
+
+	applied, changed, restart := cfg.Reload(next)
+	assert.ElementsMatch(t, []string{"log.level", "http.rate_limit", "http.cors_origins"}, changed)
+	assert.ElementsMatch(t, []string{"log.format", "port"}, restart)
+	assert.Equal(t, "debug", applied.Log.Level)
+	assert.Equal(t, 5.0, applied.HTTP.RateLimit)
+	assert.Equal(t, []string{"https://app.example.com"}, applied.HTTP.CORSOrigins)
+	assert.Equal(t, "text", applied.Log.Format, "needs a restart")
+	assert.Equal(t, 8080, applied.Port, "needs a restart")
+	assert.Equal(t, "info", cfg.Log.Level, "the original is left alone")
+	assert.Regexp(t, `log.level += debug +# file`, applied.Redacted())
+}
+
+func TestLoadWS(t *testing.T) {
+	path := writeFile(t, "card-socket.yaml", `
+ws:
+  queue_size: 64
+  overflow: disconnect
+  ping_interval: 20s
+`)
+
+	cfg, err := Load(Options{
+		Args:      []string{"-config", path, "-ws-drain-timeout", "30s"},
+		LookupEnv: env(map[string]string{"WS_PONG_WAIT": "30s", "WS_MAX_MESSAGE_SIZE": "4096"}),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, WS{
+		QueueSize:      64,
+		Overflow:       "disconnect",
+		WriteTimeout:   10 * time.Second,
+		PongWait:       30 * time.Second,
+		PingInterval:   20 * time.Second,
+		MaxMessageSize: 4096,
+		ReapInterval:   30 * time.Second,
+		PollTimeout:    25 * time.Second,
+		DrainTimeout:   30 * time.Second,
+		StreamTokenTTL: time.Minute,
+	}, cfg.WS)
+
+	_, err = Load(Options{LookupEnv: env(map[string]string{
+		"WS_OVERFLOW":      "block",
+		"WS_QUEUE_SIZE":    "0",
+		"WS_PONG_WAIT":     "20s",
+		"WS_DRAIN_TIMEOUT": "0s",
+	})})
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	assert.Equal(t, []string{
+		`ws.overflow: "block" is not one of drop_oldest, disconnect`,
+		"ws.queue_size: 0 is below 1",
+		"ws.drain_timeout: 0s is not positive",
+		"ws.ping_interval: 54s is not between 0 and ws.pong_wait",
+		"ws.poll_timeout: 25s is not between 0 and ws.pong_wait",
+	}, verr.Problems)
+}
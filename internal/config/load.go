@@ -0,0 +1,620 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/cupv/mux/internal/secrets"
+	"github.com/cupv/mux/pkg/logging"
+	"github.com/cupv/mux/pkg/trace"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Options describes how one binary loads its configuration
+type Options struct {
+	// Name is the binary name shown in flag usage
+	Name string
+	// Args are the command-line arguments without the program name
+	Args []string
+	// Defaults replace the shared defaults of some keys for this binary
+	Defaults map[string]string
+	// Required lists the keys the binary cannot run without
+	Required []string
+	// LookupEnv reads environment variables, os.LookupEnv backed by ./.env when nil
+	LookupEnv func(key string) (string, bool)
+	// Output receives flag usage and errors, os.Stderr when nil
+	Output io.Writer
+	// Providers resolve secret references with other schemes than file:// and enc://
+	Providers map[string]secrets.Provider
+}
+
+// ValidationError lists every problem found while loading, so they can all be fixed at once
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// Load merges defaults, the config file, the environment and flags, then validates the result.
+// It returns flag.ErrHelp when -h was given and a *ValidationError listing every problem otherwise.
+func Load(opts Options) (*Config, error) {
+	cfg := &Config{sources: make(map[string]string)}
+	fields := fieldsOf(reflect.ValueOf(cfg).Elem(), "")
+	var problems []string
+	set := func(f field, raw, source string) {
+		if err := f.set(raw); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: invalid value %q from %s: %v", f.key, raw, source, err))
+			return
+		}
+		cfg.sources[f.key] = source
+	}
+
+	// Defaults
+	for _, f := range fields {
+		def, ok := opts.Defaults[f.key]
+		if !ok {
+			def = f.def
+		}
+		if def != "" {
+			set(f, def, "default")
+		}
+	}
+
+	// Flags are parsed first to find -config, but applied last
+	flags := flag.NewFlagSet(opts.Name, flag.ContinueOnError)
+	if opts.Output != nil {
+		flags.SetOutput(opts.Output)
+	}
+	byFlag := make(map[string]field)
+	for _, f := range fields {
+		if f.flag != "" {
+			byFlag[f.flag] = f
+			usage := f.usage
+			if ways := f.describe(false); ways != "" {
+				usage += " (" + ways + ")"
+			}
+			switch {
+			case f.value.Kind() == reflect.Bool:
+				flags.Bool(f.flag, f.value.Bool(), usage)
+			case f.secret:
+				// Secrets keep their default out of the usage text
+				flags.String(f.flag, "", usage)
+			default:
+				flags.String(f.flag, f.String(), usage)
+			}
+		}
+	}
+	if err := flags.Parse(opts.Args); err != nil {
+		return nil, err
+	}
+
+	lookupEnv := opts.LookupEnv
+	if lookupEnv == nil {
+		dotEnv, err := readDotEnv(".env")
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		lookupEnv = func(key string) (string, bool) {
+			if value, ok := os.LookupEnv(key); ok {
+				return value, true
+			}
+			value, ok := dotEnv[key]
+			return value, ok
+		}
+	}
+
+	// Config file
+	path, _ := lookupEnv("CONFIG_FILE")
+	if fl := flags.Lookup("config"); fl != nil && flagSet(flags, "config") {
+		path = fl.Value.String()
+	}
+	if path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		byKey := make(map[string]field)
+		for _, f := range fields {
+			if !f.noFile {
+				byKey[f.key] = f
+			}
+		}
+		for _, key := range sortedKeys(values) {
+			f, ok := byKey[key]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: unknown key in %s", key, path))
+				continue
+			}
+			set(f, values[key], "file "+path)
+		}
+	}
+
+	// Environment
+	for _, f := range fields {
+		if f.env == "" {
+			continue
+		}
+		if value, ok := lookupEnv(f.env); ok {
+			set(f, value, "env "+f.env)
+		}
+	}
+
+	// Flags
+	flags.Visit(func(fl *flag.Flag) {
+		set(byFlag[fl.Name], fl.Value.String(), "flag -"+fl.Name)
+	})
+
+	// Secret references are resolved before validation, so a required secret must actually be found
+	problems = append(problems, resolveSecrets(cfg, fields, opts.Providers)...)
+	problems = append(problems, validate(cfg, fields, opts.Required)...)
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// resolveSecrets replaces secret references by the secrets they point to
+func resolveSecrets(cfg *Config, fields []field, providers map[string]secrets.Provider) []string {
+	var problems []string
+	cfg.resolver = secrets.NewResolver()
+	cfg.secrets = make(map[string]*secrets.Value)
+	cfg.resolver.Register("enc", secrets.ProviderFunc(func(ctx context.Context, name string) (string, error) {
+		return "", errors.New("set secrets.file to use enc:// references")
+	}))
+	if cfg.Secrets.File != "" {
+		if cfg.Secrets.KeyFile == "" {
+			return []string{"secrets.key_file: required when secrets.file is set"}
+		}
+		key, err := secrets.ReadKey(cfg.Secrets.KeyFile)
+		if err != nil {
+			return []string{"secrets.key_file: " + err.Error()}
+		}
+		provider, err := secrets.NewEncryptedFile(cfg.Secrets.File, key)
+		if err != nil {
+			return []string{"secrets.file: " + err.Error()}
+		}
+		cfg.resolver.Register("enc", provider)
+	}
+	for scheme, provider := range providers {
+		cfg.resolver.Register(scheme, provider)
+	}
+
+	for _, f := range fields {
+		if !f.secret {
+			continue
+		}
+		value, err := cfg.resolver.Value(context.Background(), f.String())
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", f.key, err))
+			continue
+		}
+		f.value.SetString(value.Get())
+		cfg.secrets[f.key] = value
+	}
+	return problems
+}
+
+// validate checks required keys, allowed values and ranges
+func validate(cfg *Config, fields []field, required []string) []string {
+	var problems []string
+	byKey := make(map[string]field)
+	for _, f := range fields {
+		byKey[f.key] = f
+	}
+
+	for _, key := range required {
+		f, ok := byKey[key]
+		if !ok {
+			problems = append(problems, key+": unknown required key")
+			continue
+		}
+		if f.value.IsZero() {
+			problems = append(problems, fmt.Sprintf("%s: required, set %s", key, f.describe(true)))
+		}
+	}
+	for _, f := range fields {
+		if len(f.oneof) > 0 && !f.value.IsZero() && !contains(f.oneof, f.String()) {
+			problems = append(problems, fmt.Sprintf("%s: %q is not one of %s", f.key, f.String(), strings.Join(f.oneof, ", ")))
+		}
+	}
+
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("port: %d is not between 1 and 65535", cfg.Port))
+	}
+	if cfg.HTTP.RateLimit < 0 {
+		problems = append(problems, fmt.Sprintf("http.rate_limit: %v is negative", cfg.HTTP.RateLimit))
+	}
+	if cfg.HTTP.RateLimit > 0 && cfg.HTTP.RateBurst < 1 {
+		problems = append(problems, fmt.Sprintf("http.rate_burst: %d is below 1", cfg.HTTP.RateBurst))
+	}
+	if cfg.Secrets.Refresh <= 0 {
+		problems = append(problems, fmt.Sprintf("secrets.refresh: %s is not positive", cfg.Secrets.Refresh))
+	}
+	if cfg.Admin.Port != 0 {
+		switch {
+		case cfg.Admin.Port < 1 || cfg.Admin.Port > 65535:
+			problems = append(problems, fmt.Sprintf("admin.port: %d is not between 1 and 65535", cfg.Admin.Port))
+		case cfg.Admin.Port == cfg.Port:
+			problems = append(problems, fmt.Sprintf("admin.port: %d is the port of the server", cfg.Admin.Port))
+		}
+		if cfg.Admin.Token == "" {
+			problems = append(problems, "admin.token: required when admin.port is set, set "+byKey["admin.token"].describe(true))
+		}
+	}
+	if _, err := logging.ParseLevels(cfg.Log.Packages); err != nil {
+		problems = append(problems, fmt.Sprintf("log.packages: %v", err))
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		problems = append(problems, fmt.Sprintf("tracing.sample_ratio: %v is not between 0 and 1", cfg.Tracing.SampleRatio))
+	}
+	for _, cidr := range cfg.Webhooks.AllowedNetworks {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			problems = append(problems, fmt.Sprintf("webhooks.allowed_networks: %v", err))
+		}
+	}
+	if cfg.WS.QueueSize < 1 {
+		problems = append(problems, fmt.Sprintf("ws.queue_size: %d is below 1", cfg.WS.QueueSize))
+	}
+	if cfg.WS.MaxMessageSize < 1 {
+		problems = append(problems, fmt.Sprintf("ws.max_message_size: %d is below 1", cfg.WS.MaxMessageSize))
+	}
+	for _, key := range []string{"ws.write_timeout", "ws.pong_wait", "ws.reap_interval", "ws.drain_timeout", "ws.stream_token_ttl"} {
+		if d := time.Duration(byKey[key].value.Int()); d <= 0 {
+			problems = append(problems, fmt.Sprintf("%s: %s is not positive", key, d))
+		}
+	}
+	// Pings and empty long-poll responses must reach clients before their reads time out
+	for _, key := range []string{"ws.ping_interval", "ws.poll_timeout"} {
+		if d := time.Duration(byKey[key].value.Int()); d <= 0 || d >= cfg.WS.PongWait {
+			problems = append(problems, fmt.Sprintf("%s: %s is not between 0 and ws.pong_wait", key, d))
+		}
+	}
+	return problems
+}
+
+// SlogLevel returns the configured log level
+func (l Log) SlogLevel() slog.Level {
+	var level slog.Level
+	level.UnmarshalText([]byte(l.Level))
+	return level
+}
+
+// PackageLevels returns the configured per-package log levels
+func (l Log) PackageLevels() map[string]slog.Level {
+	packages, _ := logging.ParseLevels(l.Packages)
+	return packages
+}
+
+// Networks returns the networks webhooks may reach even though they are private
+func (w Webhooks) Networks() []netip.Prefix {
+	networks := make([]netip.Prefix, 0, len(w.AllowedNetworks))
+	for _, cidr := range w.AllowedNetworks {
+		if network, err := netip.ParsePrefix(cidr); err == nil {
+			networks = append(networks, network.Masked())
+		}
+	}
+	return networks
+}
+
+// On reports whether a feature flag is turned on
+func (f Features) On(name string) bool {
+	return contains(f.Enabled, name)
+}
+
+// Tracer creates the tracer of the service from the tracing settings, nil when tracing.exporter is none
+func (t Tracing) Tracer(service string) (*trace.Tracer, error) {
+	var exporter trace.Exporter
+	switch t.Exporter {
+	case "otlp":
+		exporter = trace.NewOTLP(t.Endpoint)
+	case "stdout":
+		exporter = trace.NewWriter(os.Stdout)
+	case "file":
+		file, err := trace.NewFile(t.File)
+		if err != nil {
+			return nil, err
+		}
+		exporter = file
+	default:
+		return nil, nil
+	}
+	return trace.NewTracer(trace.Options{Service: service, SampleRatio: t.SampleRatio, Exporter: exporter}), nil
+}
+
+// Secret returns a secret setting, kept up to date by RotateSecrets when it was given as a reference.
+// Read it with Get each time it is used, e.g. when a connection is opened.
+func (c *Config) Secret(key string) *secrets.Value {
+	if value, ok := c.secrets[key]; ok {
+		return value
+	}
+	return secrets.Static("")
+}
+
+// RotateSecrets reads secret references again every secrets.refresh until ctx is done
+func (c *Config) RotateSecrets(ctx context.Context) {
+	c.resolver.Run(ctx, c.Secrets.Refresh)
+}
+
+// Reload returns a copy of c with the reloadable settings taken from next, the keys of the reloadable
+// settings that changed, and the keys of the other settings that changed but need a restart to apply.
+func (c *Config) Reload(next *Config) (applied *Config, changed, restart []string) {
+	applied = &Config{}
+	*applied = *c
+	applied.sources = make(map[string]string, len(c.sources))
+	for key, source := range c.sources {
+		applied.sources[key] = source
+	}
+
+	current := fieldsOf(reflect.ValueOf(applied).Elem(), "")
+	for i, f := range fieldsOf(reflect.ValueOf(next).Elem(), "") {
+		if !c.differs(next, current[i], f) {
+			continue
+		}
+		if !f.reload {
+			restart = append(restart, f.key)
+			continue
+		}
+		current[i].value.Set(f.value)
+		applied.sources[f.key] = next.sources[f.key]
+		changed = append(changed, f.key)
+	}
+	return applied, changed, restart
+}
+
+// differs compares a setting of c and next, secrets given as references compare by reference
+// as their values may have been rotated since c was loaded
+func (c *Config) differs(next *Config, old, f field) bool {
+	if f.secret {
+		oldRef, nextRef := c.Secret(f.key).Ref(), next.Secret(f.key).Ref()
+		if oldRef != "" || nextRef != "" {
+			return oldRef != nextRef
+		}
+	}
+	return old.String() != f.String()
+}
+
+// Redacted renders the effective configuration one key per line with where each value came from.
+// Secrets are masked, references to them are shown as they are not secret.
+func (c *Config) Redacted() string {
+	var b strings.Builder
+	for _, f := range fieldsOf(reflect.ValueOf(c).Elem(), "") {
+		value := f.String()
+		if f.secret && value != "" {
+			value = "******"
+			if ref := c.Secret(f.key).Ref(); ref != "" {
+				value = ref
+			}
+		}
+		source := c.sources[f.key]
+		if source == "" {
+			source = "unset"
+		}
+		fmt.Fprintf(&b, "%-20s = %-24s # %s\n", f.key, value, source)
+	}
+	return b.String()
+}
+
+// field is one setting of Config, found through its struct tags
+type field struct {
+	key    string
+	env    string
+	flag   string
+	def    string
+	usage  string
+	secret bool
+	noFile bool
+	reload bool
+	oneof  []string
+	value  reflect.Value
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// fieldsOf lists the settings of a config struct, sections are nested structs and prefix their keys
+func fieldsOf(v reflect.Value, prefix string) []field {
+	var fields []field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, ok := sf.Tag.Lookup("conf")
+		if !ok {
+			continue
+		}
+		key := prefix + name
+		if sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, fieldsOf(v.Field(i), key+".")...)
+			continue
+		}
+
+		f := field{
+			key:    key,
+			env:    sf.Tag.Get("env"),
+			flag:   sf.Tag.Get("flag"),
+			def:    sf.Tag.Get("default"),
+			usage:  sf.Tag.Get("usage"),
+			secret: sf.Tag.Get("secret") == "true",
+			noFile: sf.Tag.Get("file") == "-",
+			reload: sf.Tag.Get("reload") == "true",
+			oneof:  strings.Fields(sf.Tag.Get("oneof")),
+			value:  v.Field(i),
+		}
+		if f.env == "" {
+			f.env = strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+		} else if f.env == "-" {
+			f.env = ""
+		}
+		if f.flag == "" {
+			f.flag = strings.ReplaceAll(strings.ReplaceAll(key, ".", "-"), "_", "-")
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// set parses raw into the field according to its type
+func (f field) set(raw string) error {
+	switch {
+	case f.value.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(d))
+	case f.value.Kind() == reflect.String:
+		f.value.SetString(raw)
+	case f.value.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return errors.New("not an integer")
+		}
+		f.value.SetInt(int64(n))
+	case f.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return errors.New("not a boolean")
+		}
+		f.value.SetBool(b)
+	case f.value.Kind() == reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return errors.New("not a number")
+		}
+		f.value.SetFloat(n)
+	case f.value.Kind() == reflect.Slice && f.value.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", f.value.Type())
+	}
+	return nil
+}
+
+// String formats the field's value the way set parses it
+func (f field) String() string {
+	if f.value.Type() == durationType {
+		return time.Duration(f.value.Int()).String()
+	}
+	if f.value.Kind() == reflect.Slice {
+		return strings.Join(f.value.Interface().([]string), ",")
+	}
+	return fmt.Sprint(f.value.Interface())
+}
+
+// describe names the ways a field can be set, the flag only when withFlag is true
+func (f field) describe(withFlag bool) string {
+	ways := make([]string, 0, 3)
+	if f.env != "" {
+		ways = append(ways, f.env)
+	}
+	if withFlag {
+		ways = append(ways, "-"+f.flag)
+	}
+	if !f.noFile {
+		ways = append(ways, f.key+" in the config file")
+	}
+	return strings.Join(ways, ", ")
+}
+
+// readFile flattens a YAML or TOML file into dotted keys
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+
+	tree := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("config file %s: unknown format, use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	flatten("", tree, values)
+	return values, nil
+}
+
+func flatten(prefix string, tree map[string]interface{}, values map[string]string) {
+	for key, value := range tree {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flatten(prefix+key+".", v, values)
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[prefix+key] = strings.Join(items, ",")
+		default:
+			values[prefix+key] = fmt.Sprint(v)
+		}
+	}
+}
+
+// readDotEnv reads a .env file if there is one
+func readDotEnv(path string) (map[string]string, error) {
+	values, err := godotenv.Read(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+// flagSet reports whether a flag was given on the command line
+func flagSet(flags *flag.FlagSet, name string) bool {
+	found := false
+	flags.Visit(func(fl *flag.Flag) {
+		if fl.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
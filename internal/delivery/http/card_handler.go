@@ -2,9 +2,15 @@ package http
 
 import (
 	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
+	"strconv"
 
+	"github.com/cupv/mux/internal/repository"
 	"github.com/cupv/mux/internal/usecase"
+	"github.com/cupv/mux/pkg/logging"
+	"github.com/gorilla/mux"
 )
 
 type CreateCardDto struct {
@@ -12,6 +18,11 @@ type CreateCardDto struct {
 	Meaning string `json:"meaning"`
 }
 
+type UpdateCardDto struct {
+	Word    string `json:"word"`
+	Meaning string `json:"meaning"`
+}
+
 type CardHandler struct {
 	usecase usecase.CardUsecase
 }
@@ -20,10 +31,16 @@ func NewCardHandler(u usecase.CardUsecase) *CardHandler {
 	return &CardHandler{u}
 }
 
+// serverError logs err with the request's context and answers 500 with message, the error itself is not shown to clients
+func serverError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	slog.ErrorContext(r.Context(), message, "error", err)
+	http.Error(w, message, http.StatusInternalServerError)
+}
+
 func (h *CardHandler) GetCards(w http.ResponseWriter, r *http.Request) {
-	cards, err := h.usecase.FetchCards()
+	cards, err := h.usecase.FetchCards(r.Context())
 	if err != nil {
-		http.Error(w, "Failed to retrieve cards", http.StatusInternalServerError)
+		serverError(w, r, "Failed to retrieve cards", err)
 		return
 	}
 
@@ -40,15 +57,67 @@ func (h *CardHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cardId, err := h.usecase.Create(usecase.CreateCardItem{
+	cardId, err := h.usecase.Create(r.Context(), usecase.CreateCardItem{
 		Word:    dto.Word,
 		Meaning: dto.Meaning,
 	})
 	if err != nil {
-		http.Error(w, "Failed to retrieve cards", http.StatusInternalServerError)
+		serverError(w, r, "Failed to retrieve cards", err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(cardId)
 }
+
+func (h *CardHandler) Update(w http.ResponseWriter, r *http.Request) {
+	cardId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid card id", http.StatusBadRequest)
+		return
+	}
+
+	var dto UpdateCardDto
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Records logged while updating the card name it
+	r = r.WithContext(logging.With(r.Context(), slog.Int("card.id", cardId)))
+	err = h.usecase.Update(r.Context(), cardId, usecase.UpdateCardItem{
+		Word:    dto.Word,
+		Meaning: dto.Meaning,
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "Card not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Failed to update card", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *CardHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	cardId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid card id", http.StatusBadRequest)
+		return
+	}
+
+	r = r.WithContext(logging.With(r.Context(), slog.Int("card.id", cardId)))
+	err = h.usecase.Delete(r.Context(), cardId)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "Card not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Failed to delete card", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
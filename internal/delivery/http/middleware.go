@@ -0,0 +1,134 @@
+package http
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cupv/mux/internal/domain"
+	"github.com/cupv/mux/pkg/logging"
+	"github.com/gorilla/mux"
+)
+
+// CORS lets browsers on the allowed origins call the API and answers their preflight requests.
+// origins is read on every request, so a reload applies to the next one. "*" allows any origin.
+func CORS(origins func() []string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || !originAllowed(origins(), origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+				w.Header().Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func originAllowed(origins []string, origin string) bool {
+	for _, allowed := range origins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimit allows each client IP rate requests per second with bursts of up to burst, and answers 429 beyond that.
+// The limits are read on every request, so a reload applies right away. A rate of 0 turns limiting off.
+func RateLimit(limits func() (rate float64, burst int)) mux.MiddlewareFunc {
+	l := &limiter{buckets: make(map[string]*bucket), now: time.Now}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rate, burst := limits()
+			if rate <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if wait, ok := l.take(clientIP(r), rate, burst); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bucket is a token bucket, refilled lazily when a request takes from it
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type limiter struct {
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// bucketIdle is how long an unused bucket is kept, it would be full again by then at any sensible rate
+const bucketIdle = 10 * time.Minute
+
+// take spends a token of the client's bucket, or reports how long until one is available
+func (l *limiter) take(client string, rate float64, burst int) (time.Duration, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) > bucketIdle {
+		for key, b := range l.buckets {
+			if now.Sub(b.last) > bucketIdle {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rate * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// clientIP is the address the request came from, without its port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Identify puts the user named by the X-User-Id header into the request context, so the events of
+// their changes say who made them. Requests without a valid id are served with no user.
+func Identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userId, err := strconv.Atoi(r.Header.Get(logging.UserHeader)); err == nil && userId > 0 {
+			r = r.WithContext(domain.ContextWithUser(r.Context(), userId))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cupv/mux/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func TestCORS(t *testing.T) {
+	origins := []string{"https://app.example.com"}
+	handler := CORS(func() []string { return origins })(okHandler)
+
+	req := httptest.NewRequest(http.MethodGet, "/cards", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+
+	req = httptest.NewRequest(http.MethodOptions, "/card/1", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Contains(t, rec.Header().Get("Access-Control-Allow-Methods"), "PUT")
+
+	// A reload applies to the next request
+	origins = nil
+	req = httptest.NewRequest(http.MethodGet, "/cards", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestRateLimit(t *testing.T) {
+	rate, burst := 0.0, 2
+	handler := RateLimit(func() (float64, int) { return rate, burst })(okHandler)
+	get := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/cards", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Off until a rate is set
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, get("10.0.0.1:1000").Code)
+	}
+
+	rate = 1
+	assert.Equal(t, http.StatusOK, get("10.0.0.1:1000").Code)
+	assert.Equal(t, http.StatusOK, get("10.0.0.1:1001").Code)
+	rec := get("10.0.0.1:1002")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	// Other clients have their own bucket
+	assert.Equal(t, http.StatusOK, get("10.0.0.2:1000").Code)
+}
+
+func TestLimiterRefills(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := &limiter{buckets: make(map[string]*bucket), now: func() time.Time { return now }}
+
+	_, allowed := l.take("a", 2, 1)
+	assert.True(t, allowed)
+	wait, allowed := l.take("a", 2, 1)
+	assert.False(t, allowed)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	now = now.Add(500 * time.Millisecond)
+	_, allowed = l.take("a", 2, 1)
+	assert.True(t, allowed)
+
+	// Idle buckets are dropped
+	now = now.Add(2 * bucketIdle)
+	l.take("b", 2, 1)
+	assert.Len(t, l.buckets, 1)
+}
+
+func TestIdentify(t *testing.T) {
+	var got int
+	handler := Identify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = domain.UserFromContext(r.Context())
+	}))
+
+	for header, want := range map[string]int{"42": 42, "": 0, "abc": 0, "-1": 0} {
+		req := httptest.NewRequest(http.MethodPut, "/card/1", nil)
+		req.Header.Set("X-User-Id", header)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(t, want, got, header)
+	}
+}
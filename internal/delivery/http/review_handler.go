@@ -0,0 +1,59 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/cupv/mux/internal/repository"
+	"github.com/cupv/mux/internal/usecase"
+	"github.com/cupv/mux/pkg/logging"
+	"github.com/gorilla/mux"
+)
+
+type ReviewCardDto struct {
+	Grade *int `json:"grade"`
+}
+
+type ReviewHandler struct {
+	usecase usecase.ReviewUsecase
+}
+
+func NewReviewHandler(u usecase.ReviewUsecase) *ReviewHandler {
+	return &ReviewHandler{u}
+}
+
+func (h *ReviewHandler) Review(w http.ResponseWriter, r *http.Request) {
+	cardId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid card id", http.StatusBadRequest)
+		return
+	}
+
+	var dto ReviewCardDto
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil || dto.Grade == nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	r = r.WithContext(logging.With(r.Context(), slog.Int("card.id", cardId)))
+	review, err := h.usecase.Review(r.Context(), cardId, *dto.Grade)
+	if errors.Is(err, usecase.ErrInvalidReview) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "Card not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Failed to record review", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(review)
+}
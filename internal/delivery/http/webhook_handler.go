@@ -0,0 +1,163 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/cupv/mux/internal/domain"
+	"github.com/cupv/mux/internal/repository"
+	"github.com/cupv/mux/internal/usecase"
+	"github.com/gorilla/mux"
+)
+
+type RegisterWebhookDto struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+// RegisteredWebhookDto is the only response that shows the secret
+type RegisteredWebhookDto struct {
+	domain.Webhook
+	Secret string `json:"secret"`
+}
+
+type WebhookHandler struct {
+	usecase usecase.WebhookUsecase
+}
+
+func NewWebhookHandler(u usecase.WebhookUsecase) *WebhookHandler {
+	return &WebhookHandler{u}
+}
+
+// identified answers 401 unless Identify found the user the request acts for, webhooks always belong to one
+func identified(w http.ResponseWriter, r *http.Request) bool {
+	if domain.UserFromContext(r.Context()) == 0 {
+		http.Error(w, "X-User-Id required", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (h *WebhookHandler) Register(w http.ResponseWriter, r *http.Request) {
+	if !identified(w, r) {
+		return
+	}
+	var dto RegisterWebhookDto
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	hook, err := h.usecase.Register(r.Context(), usecase.RegisterWebhookItem{
+		URL:    dto.URL,
+		Secret: dto.Secret,
+		Events: dto.Events,
+	})
+	if errors.Is(err, usecase.ErrInvalidWebhook) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Failed to register webhook", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(RegisteredWebhookDto{Webhook: hook, Secret: hook.Secret})
+}
+
+func (h *WebhookHandler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !identified(w, r) {
+		return
+	}
+	hooks, err := h.usecase.FetchWebhooks(r.Context())
+	if err != nil {
+		serverError(w, r, "Failed to retrieve webhooks", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hooks)
+}
+
+func (h *WebhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if !identified(w, r) {
+		return
+	}
+	hookId, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webhook id", http.StatusBadRequest)
+		return
+	}
+
+	err = h.usecase.Delete(r.Context(), hookId)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Failed to delete webhook", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetDeliveries serves the delivery log, ?status=dead lists the dead letters
+func (h *WebhookHandler) GetDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !identified(w, r) {
+		return
+	}
+	hookId, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webhook id", http.StatusBadRequest)
+		return
+	}
+
+	deliveries, err := h.usecase.Deliveries(r.Context(), hookId, r.URL.Query().Get("status"))
+	if errors.Is(err, usecase.ErrInvalidWebhook) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Failed to retrieve deliveries", err)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []domain.Delivery{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+func (h *WebhookHandler) Redeliver(w http.ResponseWriter, r *http.Request) {
+	if !identified(w, r) {
+		return
+	}
+	deliveryId, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid delivery id", http.StatusBadRequest)
+		return
+	}
+
+	err = h.usecase.Redeliver(r.Context(), deliveryId)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Failed to redeliver", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
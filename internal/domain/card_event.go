@@ -0,0 +1,22 @@
+package domain
+
+import "time"
+
+// Card event types, shared with the socket server through the broker
+const (
+	CardCreated = "card.created"
+	CardUpdated = "card.updated"
+	CardDeleted = "card.deleted"
+)
+
+// CardEventsTopic is the broker topic card events are published on
+const CardEventsTopic = "cards"
+
+// CardEvent records a change to a card, events for deleted cards only carry the card id.
+// UserID is the user who made the change, 0 when the request did not say.
+type CardEvent struct {
+	Type       string    `json:"type"`
+	Card       Card      `json:"card"`
+	UserID     int       `json:"user_id,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
@@ -0,0 +1,33 @@
+package domain
+
+import "time"
+
+// Review grades how well a learner recalled a card, from 0 (forgot it) to 5 (perfect recall)
+type Review struct {
+	ID         int64     `json:"id"`
+	CardID     int       `json:"card_id"`
+	UserID     int       `json:"user_id,omitempty"`
+	Grade      int       `json:"grade"`
+	ReviewedAt time.Time `json:"reviewed_at"`
+}
+
+// Review grades
+const (
+	MinGrade = 0
+	MaxGrade = 5
+)
+
+// ReviewCompleted is the event type of a finished review
+const ReviewCompleted = "review.completed"
+
+// ReviewEventsTopic is the broker topic review events are published on
+const ReviewEventsTopic = "reviews"
+
+// ReviewEvent records a finished review.
+// UserID is the reviewer, as on card events, 0 when the request did not say.
+type ReviewEvent struct {
+	Type       string    `json:"type"`
+	Review     Review    `json:"review"`
+	UserID     int       `json:"user_id,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
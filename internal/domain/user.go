@@ -0,0 +1,16 @@
+package domain
+
+import "context"
+
+type userKey struct{}
+
+// ContextWithUser returns a copy of ctx that carries the id of the user making the request
+func ContextWithUser(ctx context.Context, userId int) context.Context {
+	return context.WithValue(ctx, userKey{}, userId)
+}
+
+// UserFromContext returns the user ctx was made for, or 0 when it is unknown
+func UserFromContext(ctx context.Context) int {
+	userId, _ := ctx.Value(userKey{}).(int)
+	return userId
+}
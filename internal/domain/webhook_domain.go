@@ -0,0 +1,53 @@
+package domain
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// Webhook is an endpoint that is sent the events matching its filters
+type Webhook struct {
+	ID        int64     `json:"id"`
+	UserID    int       `json:"user_id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Matches reports whether an event type passes the webhook's filters.
+// A filter is an exact type such as "card.created", a family such as "card.*", or "*" for everything.
+func (w Webhook) Matches(eventType string) bool {
+	for _, filter := range w.Events {
+		if filter == "*" || filter == eventType {
+			return true
+		}
+		if family, ok := strings.CutSuffix(filter, ".*"); ok && strings.HasPrefix(eventType, family+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is one event on its way to one webhook, with the outcome of its last attempt
+type Delivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int64           `json:"webhook_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
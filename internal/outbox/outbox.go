@@ -0,0 +1,223 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/cupv/mux/pkg/broker"
+	"github.com/cupv/mux/pkg/trace"
+)
+
+// Insert records a message in the caller's transaction, so it is only published if the transaction commits.
+// The trace in ctx is stored with it, the relay publishes the message in that trace.
+func Insert(ctx context.Context, tx *sql.Tx, topic string, payload []byte) error {
+	traceparent := sql.NullString{String: trace.SpanContextFromContext(ctx).Traceparent()}
+	traceparent.Valid = traceparent.String != ""
+	_, err := tx.ExecContext(ctx, "INSERT INTO outbox(topic, payload, trace_parent) VALUES(?, ?, ?)", topic, payload, traceparent)
+	return err
+}
+
+// Publisher is where relayed rows go, a broker.Broker is one
+type Publisher interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+}
+
+// Fanout publishes to every publisher in turn and stops at the first error.
+// The relay retries the whole row, so publishers ahead of a failing one may see it again.
+func Fanout(publishers ...Publisher) Publisher {
+	return fanout(publishers)
+}
+
+type fanout []Publisher
+
+func (f fanout) Publish(ctx context.Context, topic string, payload []byte) error {
+	for _, p := range f {
+		if err := p.Publish(ctx, topic, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Options tunes the relay
+type Options struct {
+	Batch         int           // rows claimed at a time
+	Interval      time.Duration // how often new rows are looked for
+	MaxAttempts   int           // failed publishes before a row is marked dead and skipped
+	Retention     time.Duration // how long published rows are kept, 0 keeps them forever
+	PruneInterval time.Duration // how often published rows past the retention are deleted
+}
+
+// DefaultOptions keeps a week of published rows
+func DefaultOptions() Options {
+	return Options{
+		Batch:         100,
+		Interval:      time.Second,
+		MaxAttempts:   10,
+		Retention:     7 * 24 * time.Hour,
+		PruneInterval: time.Hour,
+	}
+}
+
+// Relay publishes pending outbox rows to a publisher and marks them sent, with at-least-once semantics.
+// Rows are claimed with FOR UPDATE SKIP LOCKED, so relays running on several instances never publish the same row.
+// A crash after publishing but before the commit publishes the row again, consumers must tolerate duplicates.
+type Relay struct {
+	db        *sql.DB
+	publisher Publisher
+	options   Options
+}
+
+// NewRelay creates a relay that publishes pending rows to p
+func NewRelay(db *sql.DB, p Publisher, options Options) *Relay {
+	return &Relay{db: db, publisher: p, options: options}
+}
+
+// Run relays pending rows until ctx is done, a full batch is followed right away by the next one.
+// Published rows past the retention are pruned along the way.
+func (r *Relay) Run(ctx context.Context) {
+	var pruned time.Time
+	for {
+		if r.options.Retention > 0 && time.Since(pruned) >= r.options.PruneInterval {
+			if n, err := r.Prune(ctx); err != nil && ctx.Err() == nil {
+				slog.ErrorContext(ctx, "Outbox prune error", "error", err)
+			} else if n > 0 {
+				slog.InfoContext(ctx, "Pruned published outbox rows", "count", n)
+			}
+			pruned = time.Now()
+		}
+
+		n, err := r.RelayBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "Outbox relay error", "error", err)
+		}
+		if err == nil && n == r.options.Batch {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.options.Interval):
+		}
+	}
+}
+
+type row struct {
+	id          int64
+	topic       string
+	payload     []byte
+	traceparent sql.NullString
+	attempts    int
+}
+
+// RelayBatch publishes the oldest pending rows in order and returns how many were sent.
+// It stops at the first failed publish, that row stays pending and its attempts are counted.
+// A row that has failed MaxAttempts times is marked dead and the batch goes on without it, unless
+// the publisher was unavailable: an outage is not the row's fault and must not kill every event.
+func (r *Relay) RelayBatch(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	pending, err := claim(ctx, tx, r.options.Batch)
+	if err != nil {
+		return 0, err
+	}
+
+	var sent []int64
+	var publishErr error
+	for _, msg := range pending {
+		sc, _ := trace.ParseTraceparent(msg.traceparent.String)
+		if publishErr = r.publisher.Publish(trace.ContextWithRemote(ctx, sc), msg.topic, msg.payload); publishErr != nil {
+			if msg.attempts+1 >= r.options.MaxAttempts && !unavailable(ctx, publishErr) {
+				slog.ErrorContext(ctx, "Outbox row is dead", "id", msg.id, "topic", msg.topic, "attempts", msg.attempts+1, "error", publishErr)
+				if _, err := tx.ExecContext(ctx, "UPDATE outbox SET attempts = attempts + 1, dead_at = ? WHERE id = ?", time.Now().UTC(), msg.id); err != nil {
+					return 0, err
+				}
+				publishErr = nil
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, "UPDATE outbox SET attempts = attempts + 1 WHERE id = ?", msg.id); err != nil {
+				return 0, err
+			}
+			break
+		}
+		sent = append(sent, msg.id)
+	}
+
+	if err := markSent(ctx, tx, sent); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(sent), publishErr
+}
+
+// unavailable reports whether a publish failed because the publisher could not be reached rather than because of the row
+func unavailable(ctx context.Context, err error) bool {
+	return errors.Is(err, broker.ErrUnavailable) || errors.Is(err, broker.ErrQueueFull) || ctx.Err() != nil
+}
+
+// claim locks the oldest pending rows that no other relay holds, dead rows are never claimed again
+func claim(ctx context.Context, tx *sql.Tx, limit int) ([]row, error) {
+	rows, err := tx.QueryContext(ctx,
+		"SELECT id, topic, payload, trace_parent, attempts FROM outbox WHERE sent_at IS NULL AND dead_at IS NULL ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pending []row
+	for rows.Next() {
+		var msg row
+		if err := rows.Scan(&msg.id, &msg.topic, &msg.payload, &msg.traceparent, &msg.attempts); err != nil {
+			return nil, err
+		}
+		pending = append(pending, msg)
+	}
+	return pending, rows.Err()
+}
+
+// markSent stamps the published rows so they are never claimed again
+func markSent(ctx context.Context, tx *sql.Tx, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := []interface{}{time.Now().UTC()}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	placeholders := strings.Repeat("?, ", len(ids)-1) + "?"
+	_, err := tx.ExecContext(ctx, "UPDATE outbox SET sent_at = ? WHERE id IN ("+placeholders+")", args...)
+	return err
+}
+
+// Prune deletes published rows older than the retention, a batch at a time, and returns how many it deleted.
+// Dead rows are kept, they are the record of events that were never published.
+func (r *Relay) Prune(ctx context.Context) (int64, error) {
+	cutoff := time.Now().UTC().Add(-r.options.Retention)
+	var total int64
+	for {
+		result, err := r.db.ExecContext(ctx, "DELETE FROM outbox WHERE sent_at < ? LIMIT ?", cutoff, r.options.Batch)
+		if err != nil {
+			return total, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < int64(r.options.Batch) {
+			return total, nil
+		}
+	}
+}
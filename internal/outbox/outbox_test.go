@@ -0,0 +1,200 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cupv/mux/pkg/broker"
+	"github.com/cupv/mux/pkg/trace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const claimQuery = `SELECT id, topic, payload, trace_parent, attempts FROM outbox WHERE sent_at IS NULL AND dead_at IS NULL ORDER BY id LIMIT \? FOR UPDATE SKIP LOCKED`
+
+var claimColumns = []string{"id", "topic", "payload", "trace_parent", "attempts"}
+
+// testOptions claims 10 rows at a time
+func testOptions() Options {
+	options := DefaultOptions()
+	options.Batch = 10
+	return options
+}
+
+// failingBroker accepts a fixed number of publishes and then fails
+type failingBroker struct {
+	*broker.Memory
+	allow     int
+	published []string
+	traces    []trace.SpanContext
+}
+
+func (b *failingBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	if len(b.published) == b.allow {
+		return broker.ErrUnavailable
+	}
+	b.published = append(b.published, string(payload))
+	b.traces = append(b.traces, trace.SpanContextFromContext(ctx))
+	return nil
+}
+
+func TestRelayPublishesAndMarksRowsSent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	b := &failingBroker{Memory: broker.NewMemory(), allow: 10}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WithArgs(10).WillReturnRows(sqlmock.NewRows(claimColumns).
+		AddRow(1, "cards", []byte("a"), nil, 0).
+		AddRow(2, "cards", []byte("b"), nil, 0))
+	mock.ExpectExec(`UPDATE outbox SET sent_at = \? WHERE id IN \(\?, \?\)`).
+		WithArgs(sqlmock.AnyArg(), 1, 2).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	n, err := NewRelay(db, b, testOptions()).RelayBatch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"a", "b"}, b.published)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRelayStopsAtTheFirstFailedPublish(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	b := &failingBroker{Memory: broker.NewMemory(), allow: 1}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WithArgs(10).WillReturnRows(sqlmock.NewRows(claimColumns).
+		AddRow(1, "cards", []byte("a"), nil, 0).
+		AddRow(2, "cards", []byte("b"), nil, 0).
+		AddRow(3, "cards", []byte("c"), nil, 0))
+	mock.ExpectExec(`UPDATE outbox SET attempts = attempts \+ 1 WHERE id = \?`).
+		WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE outbox SET sent_at = \? WHERE id IN \(\?\)`).
+		WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Row 2 and everything after it stay pending so events keep their order
+	n, err := NewRelay(db, b, testOptions()).RelayBatch(context.Background())
+	assert.True(t, errors.Is(err, broker.ErrUnavailable))
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"a"}, b.published)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRelayLeavesRowsPendingWhenTheCommitFails(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	b := &failingBroker{Memory: broker.NewMemory(), allow: 10}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WithArgs(10).WillReturnRows(sqlmock.NewRows(claimColumns).
+		AddRow(1, "cards", []byte("a"), nil, 0))
+	mock.ExpectExec(`UPDATE outbox SET sent_at`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit().WillReturnError(errors.New("connection lost"))
+
+	// The row was published but not marked, so it is published again: at least once, never lost
+	n, err := NewRelay(db, b, testOptions()).RelayBatch(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRelayPublishesInTheTraceOfTheRow(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	b := &failingBroker{Memory: broker.NewMemory(), allow: 10}
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WithArgs(10).WillReturnRows(sqlmock.NewRows(claimColumns).
+		AddRow(1, "cards", []byte("a"), traceparent, 0).
+		AddRow(2, "cards", []byte("b"), nil, 0))
+	mock.ExpectExec(`UPDATE outbox SET sent_at`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	_, err = NewRelay(db, b, testOptions()).RelayBatch(context.Background())
+	require.NoError(t, err)
+	require.Len(t, b.traces, 2)
+	assert.Equal(t, traceparent, b.traces[0].Traceparent())
+	assert.False(t, b.traces[1].IsValid())
+}
+
+// poisonPublisher rejects one payload for good and accepts the rest
+type poisonPublisher struct {
+	published []string
+}
+
+func (p *poisonPublisher) Publish(ctx context.Context, topic string, payload []byte) error {
+	if string(payload) == "poison" {
+		return errors.New("payload too large")
+	}
+	p.published = append(p.published, string(payload))
+	return nil
+}
+
+func TestRelayMarksARowDeadAndMovesOn(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	p := &poisonPublisher{}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WithArgs(10).WillReturnRows(sqlmock.NewRows(claimColumns).
+		AddRow(1, "cards", []byte("poison"), nil, 9).
+		AddRow(2, "cards", []byte("b"), nil, 0))
+	mock.ExpectExec(`UPDATE outbox SET attempts = attempts \+ 1, dead_at = \? WHERE id = \?`).
+		WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE outbox SET sent_at = \? WHERE id IN \(\?\)`).
+		WithArgs(sqlmock.AnyArg(), 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Row 1 used its last attempt, so row 2 gets its turn instead of waiting behind it forever
+	n, err := NewRelay(db, p, testOptions()).RelayBatch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"b"}, p.published)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRelayNeverGivesUpDuringAnOutage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	b := &failingBroker{Memory: broker.NewMemory(), allow: 0}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WithArgs(10).WillReturnRows(sqlmock.NewRows(claimColumns).
+		AddRow(1, "cards", []byte("a"), nil, 50).
+		AddRow(2, "cards", []byte("b"), nil, 0))
+	mock.ExpectExec(`UPDATE outbox SET attempts = attempts \+ 1 WHERE id = \?`).
+		WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	n, err := NewRelay(db, b, testOptions()).RelayBatch(context.Background())
+	assert.ErrorIs(t, err, broker.ErrUnavailable)
+	assert.Equal(t, 0, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPruneDeletesOldPublishedRowsInBatches(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec(`DELETE FROM outbox WHERE sent_at < \? LIMIT \?`).
+		WithArgs(sqlmock.AnyArg(), 10).WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectExec(`DELETE FROM outbox WHERE sent_at < \? LIMIT \?`).
+		WithArgs(sqlmock.AnyArg(), 10).WillReturnResult(sqlmock.NewResult(0, 3))
+
+	n, err := NewRelay(db, &failingBroker{Memory: broker.NewMemory()}, testOptions()).Prune(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(13), n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,20 +1,38 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
 
 	"github.com/cupv/mux/internal/domain"
+	"github.com/cupv/mux/internal/outbox"
 )
 
+// ErrNotFound is returned when no record has the requested id
+var ErrNotFound = errors.New("not found")
+
 type AddCardItem struct {
 	Word    string
 	Meaning string
 }
 
+type UpdateCardItem struct {
+	Word    string
+	Meaning string
+}
+
 
 type CardRepository interface {
-	GetAllCards() ([]domain.Card, error)
-	Add(item AddCardItem) (int64, error)
+	GetAllCards(ctx context.Context) ([]domain.Card, error)
+	// GetCardsByIDs returns the cards with the given ids that exist, in id order
+	GetCardsByIDs(ctx context.Context, ids []int) ([]domain.Card, error)
+	// Mutations record their event in the outbox in the same transaction as the change
+	Add(ctx context.Context, item AddCardItem, event domain.CardEvent) (int64, error)
+	Update(ctx context.Context, id int, item UpdateCardItem, event domain.CardEvent) error
+	Delete(ctx context.Context, id int, event domain.CardEvent) error
 }
 
 type cardRepository struct {
@@ -22,11 +40,11 @@ type cardRepository struct {
 }
 
 func NewCardRepository(db *sql.DB) CardRepository {
-	return &cardRepository{db}
+	return tracedCardRepository{&cardRepository{db}}
 }
 
-func (r *cardRepository) GetAllCards() ([]domain.Card, error) {
-	rows, err := r.db.Query("SELECT id, word, meaning FROM cards")
+func (r *cardRepository) GetAllCards(ctx context.Context) ([]domain.Card, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, word, meaning FROM cards")
 	if err != nil {
 		return nil, err
 	}
@@ -43,18 +61,109 @@ func (r *cardRepository) GetAllCards() ([]domain.Card, error) {
 	return cards, nil
 }
 
-func (r *cardRepository) Add(item AddCardItem) (int64, error) {
+func (r *cardRepository) GetCardsByIDs(ctx context.Context, ids []int) ([]domain.Card, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	rows, err := r.db.QueryContext(ctx, "SELECT id, word, meaning FROM cards WHERE id IN ("+placeholders+") ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cards []domain.Card
+	for rows.Next() {
+		var card domain.Card
+		if err := rows.Scan(&card.ID, &card.Word, &card.Meaning); err != nil {
+			return nil, err
+		}
+		cards = append(cards, card)
+	}
+	return cards, rows.Err()
+}
 
-	stmt, err := r.db.Prepare("INSERT INTO cards(word,meaning) VALUES(?,?)")
+// Add stores a new card and records event for it, the event's card gets the new id
+func (r *cardRepository) Add(ctx context.Context, item AddCardItem, event domain.CardEvent) (int64, error) {
+	var id int64
+	err := inTx(ctx, r.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, "INSERT INTO cards(word,meaning) VALUES(?,?)", item.Word, item.Meaning)
+		if err != nil {
+			return err
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return err
+		}
+
+		event.Card.ID = int(id)
+		return recordEvent(ctx, tx, domain.CardEventsTopic, event)
+	})
 	if err != nil {
 		return 0, err
 	}
+	return id, nil
+}
 
-	defer stmt.Close()
+func (r *cardRepository) Update(ctx context.Context, id int, item UpdateCardItem, event domain.CardEvent) error {
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, "UPDATE cards SET word = ?, meaning = ? WHERE id = ?", item.Word, item.Meaning, id)
+		if err != nil {
+			return err
+		}
+		if err := requireAffected(result); err != nil {
+			return err
+		}
+		return recordEvent(ctx, tx, domain.CardEventsTopic, event)
+	})
+}
 
-	if result, err := stmt.Exec(item.Word, item.Meaning); err == nil {
-		id, _ := result.LastInsertId()
-		return id, nil
+func (r *cardRepository) Delete(ctx context.Context, id int, event domain.CardEvent) error {
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, "DELETE FROM cards WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if err := requireAffected(result); err != nil {
+			return err
+		}
+		return recordEvent(ctx, tx, domain.CardEventsTopic, event)
+	})
+}
+
+// inTx runs fn in a transaction that is committed only when fn succeeds
+func inTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordEvent writes an event to the outbox for the relay to publish on topic
+func recordEvent(ctx context.Context, tx *sql.Tx, topic string, event interface{}) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return outbox.Insert(ctx, tx, topic, payload)
+}
+
+// requireAffected reports ErrNotFound when a statement matched no row
+func requireAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
 	}
-	return 0, err
+	return nil
 }
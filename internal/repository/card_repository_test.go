@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cupv/mux/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddWritesTheCardAndItsEventInOneTransaction(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO cards`).WithArgs("hund", "dog").WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectExec(`INSERT INTO outbox`).WithArgs(domain.CardEventsTopic, sqlmock.AnyArg(), nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	id, err := NewCardRepository(db).Add(context.Background(), AddCardItem{Word: "hund", Meaning: "dog"},
+		domain.CardEvent{Type: domain.CardCreated, Card: domain.Card{Word: "hund", Meaning: "dog"}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFailedOutboxWriteRollsBackTheChange(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE cards`).WithArgs("hund", "a dog", 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO outbox`).WillReturnError(errors.New("disk full"))
+	mock.ExpectRollback()
+
+	err = NewCardRepository(db).Update(context.Background(), 7, UpdateCardItem{Word: "hund", Meaning: "a dog"}, domain.CardEvent{Type: domain.CardUpdated})
+	assert.EqualError(t, err, "disk full")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeletingAMissingCardRecordsNoEvent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM cards`).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	err = NewCardRepository(db).Delete(context.Background(), 7, domain.CardEvent{Type: domain.CardDeleted})
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRecordedEventsCarryTheNewId(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	var payload []byte
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO cards`).WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectExec(`INSERT INTO outbox`).WithArgs(domain.CardEventsTopic, capture{&payload}, nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	_, err = NewCardRepository(db).Add(context.Background(), AddCardItem{Word: "kat"}, domain.CardEvent{Type: domain.CardCreated, Card: domain.Card{Word: "kat"}})
+	require.NoError(t, err)
+
+	var event domain.CardEvent
+	require.NoError(t, json.Unmarshal(payload, &event))
+	assert.Equal(t, domain.Card{ID: 9, Word: "kat"}, event.Card)
+}
+
+// capture is a sqlmock argument matcher that keeps the argument it was given
+type capture struct {
+	into *[]byte
+}
+
+func (c capture) Match(v driver.Value) bool {
+	b, ok := v.([]byte)
+	*c.into = b
+	return ok
+}
+
+func TestGetCardsByIDs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, word, meaning FROM cards WHERE id IN \(\?,\?,\?\) ORDER BY id`).WithArgs(3, 1, 9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "word", "meaning"}).AddRow(1, "hund", "dog").AddRow(3, "katze", "cat"))
+
+	cards, err := NewCardRepository(db).GetCardsByIDs(context.Background(), []int{3, 1, 9})
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Card{{ID: 1, Word: "hund", Meaning: "dog"}, {ID: 3, Word: "katze", Meaning: "cat"}}, cards)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/cupv/mux/internal/domain"
+)
+
+type AddReviewItem struct {
+	CardID int
+	UserID int
+	Grade  int
+}
+
+type ReviewRepository interface {
+	// Add stores a review and records its event in the outbox in the same transaction, ErrNotFound when the card does not exist
+	Add(ctx context.Context, item AddReviewItem, event domain.ReviewEvent) (int64, error)
+}
+
+type reviewRepository struct {
+	db *sql.DB
+}
+
+func NewReviewRepository(db *sql.DB) ReviewRepository {
+	return tracedReviewRepository{&reviewRepository{db}}
+}
+
+func (r *reviewRepository) Add(ctx context.Context, item AddReviewItem, event domain.ReviewEvent) (int64, error) {
+	userId := sql.NullInt64{Int64: int64(item.UserID), Valid: item.UserID != 0}
+	var id int64
+	err := inTx(ctx, r.db, func(tx *sql.Tx) error {
+		// Selecting from cards inserts nothing for a missing card
+		result, err := tx.ExecContext(ctx,
+			"INSERT INTO reviews(card_id, user_id, grade) SELECT id, ?, ? FROM cards WHERE id = ?", userId, item.Grade, item.CardID)
+		if err != nil {
+			return err
+		}
+		if err := requireAffected(result); err != nil {
+			return err
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return err
+		}
+
+		event.Review.ID = id
+		return recordEvent(ctx, tx, domain.ReviewEventsTopic, event)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cupv/mux/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddReviewWritesTheReviewAndItsEvent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	var payload []byte
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO reviews\(card_id, user_id, grade\) SELECT id, \?, \? FROM cards WHERE id = \?`).
+		WithArgs(sql.NullInt64{Int64: 42, Valid: true}, 4, 3).WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectExec(`INSERT INTO outbox`).WithArgs(domain.ReviewEventsTopic, capture{&payload}, nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	id, err := NewReviewRepository(db).Add(context.Background(), AddReviewItem{CardID: 3, UserID: 42, Grade: 4},
+		domain.ReviewEvent{Type: domain.ReviewCompleted, Review: domain.Review{CardID: 3, UserID: 42, Grade: 4}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(9), id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var event domain.ReviewEvent
+	require.NoError(t, json.Unmarshal(payload, &event))
+	assert.Equal(t, domain.ReviewCompleted, event.Type)
+	assert.Equal(t, int64(9), event.Review.ID)
+}
+
+func TestAddReviewOfAMissingCard(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO reviews`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	_, err = NewReviewRepository(db).Add(context.Background(), AddReviewItem{CardID: 3, Grade: 4}, domain.ReviewEvent{Type: domain.ReviewCompleted})
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
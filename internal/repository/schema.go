@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// schemaTables are the tables created by card.sql that the service needs
+var schemaTables = []string{"cards", "outbox", "webhooks", "webhook_deliveries", "reviews"}
+
+// CheckSchema reports the tables of card.sql missing from the database, for use as a health check.
+// The schema is not versioned by a migration tool, so its tables being there is what is checked.
+func CheckSchema(db *sql.DB) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		query := "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name IN (?" +
+			strings.Repeat(", ?", len(schemaTables)-1) + ")"
+		args := make([]interface{}, len(schemaTables))
+		for i, table := range schemaTables {
+			args[i] = table
+		}
+
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		found := make(map[string]bool)
+		for rows.Next() {
+			var table string
+			if err := rows.Scan(&table); err != nil {
+				return err
+			}
+			found[table] = true
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		var missing []string
+		for _, table := range schemaTables {
+			if !found[table] {
+				missing = append(missing, table)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("missing tables: %s", strings.Join(missing, ", "))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckSchemaReportsMissingTables(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	query := `SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE\(\) AND table_name IN \(\?, \?, \?, \?, \?\)`
+	mock.ExpectQuery(query).WithArgs("cards", "outbox", "webhooks", "webhook_deliveries", "reviews").
+		WillReturnRows(sqlmock.NewRows([]string{"table_name"}).AddRow("cards").AddRow("outbox").AddRow("webhooks").AddRow("webhook_deliveries").AddRow("reviews"))
+	mock.ExpectQuery(query).
+		WillReturnRows(sqlmock.NewRows([]string{"table_name"}).AddRow("cards").AddRow("outbox"))
+
+	check := CheckSchema(db)
+	assert.NoError(t, check(context.Background()))
+	assert.EqualError(t, check(context.Background()), "missing tables: webhooks, webhook_deliveries, reviews")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
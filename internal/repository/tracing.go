@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/cupv/mux/internal/domain"
+	"github.com/cupv/mux/pkg/trace"
+)
+
+// startQuery starts a client span for a repository call that talks to MySQL
+func startQuery(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, *trace.Span) {
+	return trace.Start(ctx, name, trace.Client, append([]slog.Attr{slog.String("db.system", "mysql")}, attrs...)...)
+}
+
+// tracedCardRepository wraps every call of a CardRepository in a span named after it
+type tracedCardRepository struct {
+	next CardRepository
+}
+
+func (r tracedCardRepository) GetAllCards(ctx context.Context) (cards []domain.Card, err error) {
+	ctx, span := startQuery(ctx, "CardRepository.GetAllCards")
+	defer func() { span.Finish(err) }()
+	return r.next.GetAllCards(ctx)
+}
+
+func (r tracedCardRepository) GetCardsByIDs(ctx context.Context, ids []int) (cards []domain.Card, err error) {
+	ctx, span := startQuery(ctx, "CardRepository.GetCardsByIDs", slog.Int("card.count", len(ids)))
+	defer func() { span.Finish(err) }()
+	return r.next.GetCardsByIDs(ctx, ids)
+}
+
+func (r tracedCardRepository) Add(ctx context.Context, item AddCardItem, event domain.CardEvent) (id int64, err error) {
+	ctx, span := startQuery(ctx, "CardRepository.Add")
+	defer func() { span.Finish(err) }()
+	return r.next.Add(ctx, item, event)
+}
+
+func (r tracedCardRepository) Update(ctx context.Context, id int, item UpdateCardItem, event domain.CardEvent) (err error) {
+	ctx, span := startQuery(ctx, "CardRepository.Update", slog.Int("card.id", id))
+	defer func() { span.Finish(err) }()
+	return r.next.Update(ctx, id, item, event)
+}
+
+func (r tracedCardRepository) Delete(ctx context.Context, id int, event domain.CardEvent) (err error) {
+	ctx, span := startQuery(ctx, "CardRepository.Delete", slog.Int("card.id", id))
+	defer func() { span.Finish(err) }()
+	return r.next.Delete(ctx, id, event)
+}
+
+// tracedWebhookRepository wraps every call of a WebhookRepository in a span named after it
+type tracedWebhookRepository struct {
+	next WebhookRepository
+}
+
+func (r tracedWebhookRepository) AddWebhook(ctx context.Context, item AddWebhookItem) (id int64, err error) {
+	ctx, span := startQuery(ctx, "WebhookRepository.AddWebhook")
+	defer func() { span.Finish(err) }()
+	return r.next.AddWebhook(ctx, item)
+}
+
+func (r tracedWebhookRepository) GetWebhooks(ctx context.Context, userId int) (hooks []domain.Webhook, err error) {
+	ctx, span := startQuery(ctx, "WebhookRepository.GetWebhooks", slog.Int("user.id", userId))
+	defer func() { span.Finish(err) }()
+	return r.next.GetWebhooks(ctx, userId)
+}
+
+func (r tracedWebhookRepository) GetWebhook(ctx context.Context, id int64) (hook *domain.Webhook, err error) {
+	ctx, span := startQuery(ctx, "WebhookRepository.GetWebhook", slog.Int64("webhook.id", id))
+	defer func() { span.Finish(err) }()
+	return r.next.GetWebhook(ctx, id)
+}
+
+func (r tracedWebhookRepository) DeleteWebhook(ctx context.Context, userId int, id int64) (err error) {
+	ctx, span := startQuery(ctx, "WebhookRepository.DeleteWebhook", slog.Int("user.id", userId), slog.Int64("webhook.id", id))
+	defer func() { span.Finish(err) }()
+	return r.next.DeleteWebhook(ctx, userId, id)
+}
+
+func (r tracedWebhookRepository) AddDelivery(ctx context.Context, webhookId int64, eventType string, payload []byte) (id int64, err error) {
+	ctx, span := startQuery(ctx, "WebhookRepository.AddDelivery", slog.Int64("webhook.id", webhookId))
+	defer func() { span.Finish(err) }()
+	return r.next.AddDelivery(ctx, webhookId, eventType, payload)
+}
+
+func (r tracedWebhookRepository) GetDelivery(ctx context.Context, userId int, id int64) (delivery *domain.Delivery, err error) {
+	ctx, span := startQuery(ctx, "WebhookRepository.GetDelivery", slog.Int("user.id", userId), slog.Int64("delivery.id", id))
+	defer func() { span.Finish(err) }()
+	return r.next.GetDelivery(ctx, userId, id)
+}
+
+func (r tracedWebhookRepository) GetDeliveries(ctx context.Context, userId int, webhookId int64, status string) (deliveries []domain.Delivery, err error) {
+	ctx, span := startQuery(ctx, "WebhookRepository.GetDeliveries", slog.Int("user.id", userId), slog.Int64("webhook.id", webhookId))
+	defer func() { span.Finish(err) }()
+	return r.next.GetDeliveries(ctx, userId, webhookId, status)
+}
+
+func (r tracedWebhookRepository) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) (deliveries []domain.Delivery, err error) {
+	ctx, span := startQuery(ctx, "WebhookRepository.ClaimDeliveries")
+	defer func() { span.Finish(err) }()
+	return r.next.ClaimDeliveries(ctx, limit, lease)
+}
+
+func (r tracedWebhookRepository) UpdateDelivery(ctx context.Context, delivery domain.Delivery) (err error) {
+	ctx, span := startQuery(ctx, "WebhookRepository.UpdateDelivery", slog.Int64("delivery.id", delivery.ID))
+	defer func() { span.Finish(err) }()
+	return r.next.UpdateDelivery(ctx, delivery)
+}
+
+// tracedReviewRepository wraps every call of a ReviewRepository in a span named after it
+type tracedReviewRepository struct {
+	next ReviewRepository
+}
+
+func (r tracedReviewRepository) Add(ctx context.Context, item AddReviewItem, event domain.ReviewEvent) (id int64, err error) {
+	ctx, span := startQuery(ctx, "ReviewRepository.Add", slog.Int("card.id", item.CardID))
+	defer func() { span.Finish(err) }()
+	return r.next.Add(ctx, item, event)
+}
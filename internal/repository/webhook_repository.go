@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/cupv/mux/internal/domain"
+)
+
+type AddWebhookItem struct {
+	UserID int
+	URL    string
+	Secret string
+	Events []string
+}
+
+// WebhookRepository stores webhooks and their deliveries. Calls made for a user take their id and
+// only see that user's webhooks, the ids of other users' webhooks and deliveries are ErrNotFound.
+type WebhookRepository interface {
+	AddWebhook(ctx context.Context, item AddWebhookItem) (int64, error)
+	GetWebhooks(ctx context.Context, userId int) ([]domain.Webhook, error)
+	// GetWebhook returns a webhook whoever owns it, for the dispatcher
+	GetWebhook(ctx context.Context, id int64) (*domain.Webhook, error)
+	DeleteWebhook(ctx context.Context, userId int, id int64) error
+
+	AddDelivery(ctx context.Context, webhookId int64, eventType string, payload []byte) (int64, error)
+	GetDelivery(ctx context.Context, userId int, id int64) (*domain.Delivery, error)
+	// GetDeliveries lists a webhook's deliveries newest first, status filters them when it is not empty
+	GetDeliveries(ctx context.Context, userId int, webhookId int64, status string) ([]domain.Delivery, error)
+	// ClaimDeliveries returns pending deliveries that are due and pushes them back by lease,
+	// so other instances skip them while this one sends them
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]domain.Delivery, error)
+	UpdateDelivery(ctx context.Context, delivery domain.Delivery) error
+}
+
+const (
+	webhookColumns  = "id, user_id, url, secret, events, created_at"
+	deliveryColumns = "id, webhook_id, event_type, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, delivered_at"
+	// ownedBy limits deliveries to those of a user's webhooks
+	ownedBy = "webhook_id IN (SELECT id FROM webhooks WHERE user_id = ?)"
+)
+
+type webhookRepository struct {
+	db *sql.DB
+}
+
+func NewWebhookRepository(db *sql.DB) WebhookRepository {
+	return tracedWebhookRepository{&webhookRepository{db}}
+}
+
+func (r *webhookRepository) AddWebhook(ctx context.Context, item AddWebhookItem) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "INSERT INTO webhooks(user_id, url, secret, events) VALUES(?, ?, ?, ?)",
+		item.UserID, item.URL, item.Secret, strings.Join(item.Events, ","))
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (r *webhookRepository) GetWebhooks(ctx context.Context, userId int) ([]domain.Webhook, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE user_id = ? ORDER BY id", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []domain.Webhook
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, *hook)
+	}
+	return hooks, rows.Err()
+}
+
+func (r *webhookRepository) GetWebhook(ctx context.Context, id int64) (*domain.Webhook, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE id = ?", id)
+	hook, err := scanWebhook(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return hook, err
+}
+
+// DeleteWebhook removes a webhook, its deliveries go with it
+func (r *webhookRepository) DeleteWebhook(ctx context.Context, userId int, id int64) error {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM webhooks WHERE id = ? AND user_id = ?", id, userId)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+func (r *webhookRepository) AddDelivery(ctx context.Context, webhookId int64, eventType string, payload []byte) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "INSERT INTO webhook_deliveries(webhook_id, event_type, payload, status, next_attempt_at) VALUES(?, ?, ?, ?, ?)",
+		webhookId, eventType, payload, domain.DeliveryPending, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (r *webhookRepository) GetDelivery(ctx context.Context, userId int, id int64) (*domain.Delivery, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE id = ? AND "+ownedBy, id, userId)
+	delivery, err := scanDelivery(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return delivery, err
+}
+
+func (r *webhookRepository) GetDeliveries(ctx context.Context, userId int, webhookId int64, status string) ([]domain.Delivery, error) {
+	query := "SELECT " + deliveryColumns + " FROM webhook_deliveries WHERE webhook_id = ? AND " + ownedBy
+	args := []interface{}{webhookId, userId}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	rows, err := r.db.QueryContext(ctx, query+" ORDER BY id DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanDeliveries(rows)
+}
+
+func (r *webhookRepository) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]domain.Delivery, error) {
+	now := time.Now().UTC()
+	var claimed []domain.Delivery
+	err := inTx(ctx, r.db, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, "SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE status = ? AND next_attempt_at <= ? ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED",
+			domain.DeliveryPending, now, limit)
+		if err != nil {
+			return err
+		}
+		claimed, err = scanDeliveries(rows)
+		rows.Close()
+		if err != nil || len(claimed) == 0 {
+			return err
+		}
+
+		args := []interface{}{now.Add(lease)}
+		for _, delivery := range claimed {
+			args = append(args, delivery.ID)
+		}
+		placeholders := strings.Repeat("?, ", len(claimed)-1) + "?"
+		_, err = tx.ExecContext(ctx, "UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id IN ("+placeholders+")", args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+func (r *webhookRepository) UpdateDelivery(ctx context.Context, delivery domain.Delivery) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?, last_status_code = ?, last_error = ?, delivered_at = ? WHERE id = ?",
+		delivery.Status, delivery.Attempts, delivery.NextAttemptAt.UTC(),
+		sql.NullInt64{Int64: int64(delivery.LastStatusCode), Valid: delivery.LastStatusCode != 0},
+		sql.NullString{String: delivery.LastError, Valid: delivery.LastError != ""},
+		delivery.DeliveredAt, delivery.ID)
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanWebhook(row scanner) (*domain.Webhook, error) {
+	var hook domain.Webhook
+	var events string
+	if err := row.Scan(&hook.ID, &hook.UserID, &hook.URL, &hook.Secret, &events, &hook.CreatedAt); err != nil {
+		return nil, err
+	}
+	hook.Events = strings.Split(events, ",")
+	return &hook, nil
+}
+
+func scanDelivery(row scanner) (*domain.Delivery, error) {
+	var delivery domain.Delivery
+	var statusCode sql.NullInt64
+	var lastError sql.NullString
+	var deliveredAt sql.NullTime
+	var payload []byte
+	err := row.Scan(&delivery.ID, &delivery.WebhookID, &delivery.EventType, &payload, &delivery.Status,
+		&delivery.Attempts, &delivery.NextAttemptAt, &statusCode, &lastError, &delivery.CreatedAt, &deliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	delivery.Payload = payload
+	delivery.LastStatusCode = int(statusCode.Int64)
+	delivery.LastError = lastError.String
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.Time
+	}
+	return &delivery, nil
+}
+
+func scanDeliveries(rows *sql.Rows) ([]domain.Delivery, error) {
+	var deliveries []domain.Delivery
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+	return deliveries, rows.Err()
+}
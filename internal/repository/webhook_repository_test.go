@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cupv/mux/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaimDeliveriesLeasesWhatItLocks(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now().UTC()
+	columns := []string{"id", "webhook_id", "event_type", "payload", "status", "attempts", "next_attempt_at",
+		"last_status_code", "last_error", "created_at", "delivered_at"}
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .+ FROM webhook_deliveries WHERE status = \? AND next_attempt_at <= \? ORDER BY id LIMIT \? FOR UPDATE SKIP LOCKED`).
+		WithArgs(domain.DeliveryPending, sqlmock.AnyArg(), 10).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(4, 1, "card.created", []byte(`{}`), domain.DeliveryPending, 0, now, nil, nil, now, nil).
+			AddRow(5, 1, "card.deleted", []byte(`{}`), domain.DeliveryPending, 2, now, 500, "receiver answered 500", now, nil))
+	mock.ExpectExec(`UPDATE webhook_deliveries SET next_attempt_at = \? WHERE id IN \(\?, \?\)`).
+		WithArgs(sqlmock.AnyArg(), 4, 5).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	claimed, err := NewWebhookRepository(db).ClaimDeliveries(context.Background(), 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+	assert.Equal(t, "card.deleted", claimed[1].EventType)
+	assert.Equal(t, 500, claimed[1].LastStatusCode)
+	assert.Equal(t, "receiver answered 500", claimed[1].LastError)
+	assert.Nil(t, claimed[1].DeliveredAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWebhookQueriesAreScopedToTheUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	repo := NewWebhookRepository(db)
+	ctx := context.Background()
+
+	mock.ExpectExec(`INSERT INTO webhooks\(user_id, url, secret, events\) VALUES\(\?, \?, \?, \?\)`).
+		WithArgs(42, "https://lms.example.com", "s3cret", "card.*,review.*").WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectQuery(`SELECT id, user_id, url, secret, events, created_at FROM webhooks WHERE user_id = \? ORDER BY id`).
+		WithArgs(42).WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "url", "secret", "events", "created_at"}).
+		AddRow(3, 42, "https://lms.example.com", "s3cret", "card.*,review.*", time.Now()))
+	mock.ExpectExec(`DELETE FROM webhooks WHERE id = \? AND user_id = \?`).WithArgs(3, 7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`FROM webhook_deliveries WHERE id = \? AND webhook_id IN \(SELECT id FROM webhooks WHERE user_id = \?\)`).
+		WithArgs(9, 7).WillReturnRows(sqlmock.NewRows(nil))
+	mock.ExpectQuery(`FROM webhook_deliveries WHERE webhook_id = \? AND webhook_id IN \(SELECT id FROM webhooks WHERE user_id = \?\) AND status = \? ORDER BY id DESC`).
+		WithArgs(3, 7, domain.DeliveryDead).WillReturnRows(sqlmock.NewRows(nil))
+
+	_, err = repo.AddWebhook(ctx, AddWebhookItem{UserID: 42, URL: "https://lms.example.com", Secret: "s3cret", Events: []string{"card.*", "review.*"}})
+	require.NoError(t, err)
+	hooks, err := repo.GetWebhooks(ctx, 42)
+	require.NoError(t, err)
+	require.Len(t, hooks, 1)
+	assert.Equal(t, 42, hooks[0].UserID)
+
+	// Another user's ids are not found
+	assert.ErrorIs(t, repo.DeleteWebhook(ctx, 7, 3), ErrNotFound)
+	_, err = repo.GetDelivery(ctx, 7, 9)
+	assert.ErrorIs(t, err, ErrNotFound)
+	deliveries, err := repo.GetDeliveries(ctx, 7, 3, domain.DeliveryDead)
+	require.NoError(t, err)
+	assert.Empty(t, deliveries)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
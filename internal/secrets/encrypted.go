@@ -0,0 +1,152 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KeySize is the length of an encrypted file key, AES-256
+const KeySize = 32
+
+// ErrBadKey is returned when a key has the wrong size or does not decrypt the file
+var ErrBadKey = errors.New("bad secrets key")
+
+// encryptedFile is the on-disk format, data is the JSON object of secrets sealed with AES-GCM
+type encryptedFile struct {
+	Version int    `json:"version"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// EncryptedFile is a provider backed by a local file of named secrets encrypted with a key kept elsewhere.
+// The file is read on every lookup, so rewriting it rotates the secrets.
+type EncryptedFile struct {
+	path string
+	key  []byte
+}
+
+// NewEncryptedFile creates a provider reading path with key
+func NewEncryptedFile(path string, key []byte) (*EncryptedFile, error) {
+	if len(key) != KeySize {
+		return nil, ErrBadKey
+	}
+	return &EncryptedFile{path: path, key: key}, nil
+}
+
+func (f *EncryptedFile) Get(ctx context.Context, name string) (string, error) {
+	values, err := f.Read()
+	if err != nil {
+		return "", err
+	}
+	secret, ok := values[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return secret, nil
+}
+
+// Read decrypts every secret in the file, a missing file holds none
+func (f *EncryptedFile) Read() (map[string]string, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file encryptedFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", f.path, err)
+	}
+	if file.Version != 1 {
+		return nil, fmt.Errorf("%s: unsupported version %d", f.path, file.Version)
+	}
+	gcm, err := newGCM(f.key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.path, ErrBadKey)
+	}
+
+	values := make(map[string]string)
+	if err := json.Unmarshal(plain, &values); err != nil {
+		return nil, fmt.Errorf("%s: %w", f.path, err)
+	}
+	return values, nil
+}
+
+// Write encrypts values into the file, replacing it atomically so readers never see half of it
+func (f *EncryptedFile) Write(values map[string]string) error {
+	plain, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(f.key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data, err := json.Marshal(encryptedFile{Version: 1, Nonce: nonce, Data: gcm.Seal(nil, nonce, plain, nil)})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, ErrBadKey
+	}
+	return cipher.NewGCM(block)
+}
+
+// GenerateKey returns a new random key, hex encoded as ReadKey expects
+func GenerateKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// ReadKey reads a hex encoded key from a file
+func ReadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("%s: %w, want %d hex encoded bytes", path, ErrBadKey, KeySize)
+	}
+	return key, nil
+}
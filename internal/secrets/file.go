@@ -0,0 +1,24 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+)
+
+// FileProvider reads a secret from a file, as mounted by Docker and Kubernetes secrets.
+// The name is the path, so file:///run/secrets/db_password reads /run/secrets/db_password.
+// A trailing newline is dropped.
+type FileProvider struct{}
+
+func (FileProvider) Get(ctx context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,159 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNotFound is returned by a provider that has no secret under the requested name
+var ErrNotFound = errors.New("secret not found")
+
+// Provider looks secrets up by name, the part of a reference after "scheme://"
+type Provider interface {
+	Get(ctx context.Context, name string) (string, error)
+}
+
+// ProviderFunc adapts a function to Provider
+type ProviderFunc func(ctx context.Context, name string) (string, error)
+
+func (f ProviderFunc) Get(ctx context.Context, name string) (string, error) {
+	return f(ctx, name)
+}
+
+// Value holds a secret that may be rotated while the process runs
+type Value struct {
+	ref     string
+	current atomic.Pointer[string]
+}
+
+// Static returns a value that never changes, for secrets given in plain text
+func Static(s string) *Value {
+	v := &Value{}
+	v.current.Store(&s)
+	return v
+}
+
+// Get returns the current secret, callers should read it each time they use it rather than keep a copy
+func (v *Value) Get() string {
+	return *v.current.Load()
+}
+
+// Ref returns the reference the value was resolved from, empty for static values
+func (v *Value) Ref() string {
+	return v.ref
+}
+
+// Resolver turns references such as file:///run/secrets/db_password into secrets through the provider
+// registered for their scheme, and keeps the values it handed out up to date.
+type Resolver struct {
+	mutex     sync.Mutex
+	providers map[string]Provider
+	values    []*Value
+}
+
+// NewResolver creates a resolver that knows the file scheme
+func NewResolver() *Resolver {
+	r := &Resolver{providers: make(map[string]Provider)}
+	r.Register("file", FileProvider{})
+	return r
+}
+
+// Register makes references with this scheme resolve through p
+func (r *Resolver) Register(scheme string, p Provider) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.providers[scheme] = p
+}
+
+// provider returns the provider for a reference and the name to look up, ok is false for plain values
+func (r *Resolver) provider(s string) (p Provider, name string, ok bool) {
+	scheme, name, found := strings.Cut(s, "://")
+	if !found {
+		return nil, "", false
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	p, ok = r.providers[scheme]
+	return p, name, ok
+}
+
+// IsRef reports whether s is a reference to a registered provider
+func (r *Resolver) IsRef(s string) bool {
+	_, _, ok := r.provider(s)
+	return ok
+}
+
+// Resolve returns the secret s refers to, or s itself when it is not a reference
+func (r *Resolver) Resolve(ctx context.Context, s string) (string, error) {
+	p, name, ok := r.provider(s)
+	if !ok {
+		return s, nil
+	}
+	secret, err := p.Get(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %w", s, err)
+	}
+	return secret, nil
+}
+
+// Value resolves s and, when it is a reference, keeps the result fresh on every Refresh
+func (r *Resolver) Value(ctx context.Context, s string) (*Value, error) {
+	if !r.IsRef(s) {
+		return Static(s), nil
+	}
+	secret, err := r.Resolve(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Value{ref: s}
+	v.current.Store(&secret)
+	r.mutex.Lock()
+	r.values = append(r.values, v)
+	r.mutex.Unlock()
+	return v, nil
+}
+
+// Refresh resolves every value again. A value that fails keeps its last secret, the errors are joined.
+func (r *Resolver) Refresh(ctx context.Context) error {
+	r.mutex.Lock()
+	values := append([]*Value(nil), r.values...)
+	r.mutex.Unlock()
+
+	var errs []error
+	for _, v := range values {
+		secret, err := r.Resolve(ctx, v.ref)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if secret != v.Get() {
+			slog.InfoContext(ctx, "Secret rotated", "ref", v.ref)
+			v.current.Store(&secret)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run refreshes the values every interval until ctx is done
+func (r *Resolver) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Refresh(ctx); err != nil {
+				slog.ErrorContext(ctx, "Secret refresh error", "error", err)
+			}
+		}
+	}
+}
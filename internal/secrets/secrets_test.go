@@ -0,0 +1,100 @@
+package secrets
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db_password")
+	require.NoError(t, os.WriteFile(path, []byte("hunter2\n"), 0o600))
+	r := NewResolver()
+	ctx := context.Background()
+
+	secret, err := r.Resolve(ctx, "file://"+path)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", secret)
+
+	// Plain values and unknown schemes are not references
+	secret, err = r.Resolve(ctx, "redis://localhost")
+	require.NoError(t, err)
+	assert.Equal(t, "redis://localhost", secret)
+
+	_, err = r.Resolve(ctx, "file://"+path+".missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRefreshRotatesValues(t *testing.T) {
+	current := map[string]string{"db": "one"}
+	r := NewResolver()
+	r.Register("test", ProviderFunc(func(ctx context.Context, name string) (string, error) {
+		secret, ok := current[name]
+		if !ok {
+			return "", ErrNotFound
+		}
+		return secret, nil
+	}))
+	ctx := context.Background()
+
+	v, err := r.Value(ctx, "test://db")
+	require.NoError(t, err)
+	static, err := r.Value(ctx, "plain")
+	require.NoError(t, err)
+	assert.Equal(t, "one", v.Get())
+	assert.Equal(t, "test://db", v.Ref())
+
+	current["db"] = "two"
+	require.NoError(t, r.Refresh(ctx))
+	assert.Equal(t, "two", v.Get())
+	assert.Equal(t, "plain", static.Get())
+
+	// A failed lookup keeps the last good secret
+	delete(current, "db")
+	assert.ErrorIs(t, r.Refresh(ctx), ErrNotFound)
+	assert.Equal(t, "two", v.Get())
+}
+
+func TestEncryptedFile(t *testing.T) {
+	dir := t.TempDir()
+	hexKey, err := GenerateKey()
+	require.NoError(t, err)
+	keyPath := filepath.Join(dir, "key")
+	require.NoError(t, os.WriteFile(keyPath, []byte(hexKey+"\n"), 0o600))
+	key, err := ReadKey(keyPath)
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "secrets.enc")
+	f, err := NewEncryptedFile(path, key)
+	require.NoError(t, err)
+	values, err := f.Read()
+	require.NoError(t, err)
+	assert.Empty(t, values)
+	require.NoError(t, f.Write(map[string]string{"redis_password": "swordfish"}))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "swordfish")
+
+	r := NewResolver()
+	r.Register("enc", f)
+	secret, err := r.Resolve(context.Background(), "enc://redis_password")
+	require.NoError(t, err)
+	assert.Equal(t, "swordfish", secret)
+	_, err = r.Resolve(context.Background(), "enc://db_password")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	other, err := GenerateKey()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyPath, []byte(other), 0o600))
+	wrongKey, err := ReadKey(keyPath)
+	require.NoError(t, err)
+	wrong, err := NewEncryptedFile(path, wrongKey)
+	require.NoError(t, err)
+	_, err = wrong.Read()
+	assert.ErrorIs(t, err, ErrBadKey)
+}
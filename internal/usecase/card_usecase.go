@@ -1,6 +1,9 @@
 package usecase
 
 import (
+	"context"
+	"time"
+
 	"github.com/cupv/mux/internal/domain"
 	"github.com/cupv/mux/internal/repository"
 )
@@ -10,26 +13,50 @@ type CreateCardItem struct {
 	Meaning string
 }
 
+type UpdateCardItem struct {
+	Word    string
+	Meaning string
+}
+
 type CardUsecase interface {
-	FetchCards() ([]domain.Card, error)
-	Create(item CreateCardItem) (int64, error)
+	FetchCards(ctx context.Context) ([]domain.Card, error)
+	Create(ctx context.Context, item CreateCardItem) (int64, error)
+	Update(ctx context.Context, id int, item UpdateCardItem) error
+	Delete(ctx context.Context, id int) error
 }
 
 type cardUsecase struct {
 	cardRepo repository.CardRepository
 }
 
+// NewCardUsecase creates the card usecase, every change is stored together with its domain event
 func NewCardUsecase(cardRepo repository.CardRepository) CardUsecase {
-	return &cardUsecase{cardRepo}
+	return tracedCardUsecase{&cardUsecase{cardRepo}}
 }
 
-func (u *cardUsecase) FetchCards() ([]domain.Card, error) {
-	return u.cardRepo.GetAllCards()
+func (u *cardUsecase) FetchCards(ctx context.Context) ([]domain.Card, error) {
+	return u.cardRepo.GetAllCards(ctx)
 }
 
-func (u *cardUsecase) Create(item CreateCardItem) (int64, error) {
-	return u.cardRepo.Add(repository.AddCardItem{
+func (u *cardUsecase) Create(ctx context.Context, item CreateCardItem) (int64, error) {
+	return u.cardRepo.Add(ctx, repository.AddCardItem{
 		Word:    item.Word,
 		Meaning: item.Meaning,
-	})
+	}, newEvent(ctx, domain.CardCreated, domain.Card{Word: item.Word, Meaning: item.Meaning}))
+}
+
+func (u *cardUsecase) Update(ctx context.Context, id int, item UpdateCardItem) error {
+	return u.cardRepo.Update(ctx, id, repository.UpdateCardItem{
+		Word:    item.Word,
+		Meaning: item.Meaning,
+	}, newEvent(ctx, domain.CardUpdated, domain.Card{ID: id, Word: item.Word, Meaning: item.Meaning}))
+}
+
+func (u *cardUsecase) Delete(ctx context.Context, id int) error {
+	return u.cardRepo.Delete(ctx, id, newEvent(ctx, domain.CardDeleted, domain.Card{ID: id}))
+}
+
+// newEvent describes a card change made by the user of ctx, it is published by the outbox relay once the change is committed
+func newEvent(ctx context.Context, eventType string, card domain.Card) domain.CardEvent {
+	return domain.CardEvent{Type: eventType, Card: card, UserID: domain.UserFromContext(ctx), OccurredAt: time.Now().UTC()}
 }
@@ -0,0 +1,99 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cupv/mux/internal/domain"
+	"github.com/cupv/mux/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCardRepository keeps cards in memory and records the events that would go to the outbox
+type fakeCardRepository struct {
+	cards  map[int]domain.Card
+	next   int
+	events []domain.CardEvent
+}
+
+func newFakeCardRepository() *fakeCardRepository {
+	return &fakeCardRepository{cards: make(map[int]domain.Card)}
+}
+
+func (r *fakeCardRepository) GetAllCards(ctx context.Context) ([]domain.Card, error) {
+	cards := make([]domain.Card, 0, len(r.cards))
+	for _, card := range r.cards {
+		cards = append(cards, card)
+	}
+	return cards, nil
+}
+
+func (r *fakeCardRepository) GetCardsByIDs(ctx context.Context, ids []int) ([]domain.Card, error) {
+	var cards []domain.Card
+	for _, id := range ids {
+		if card, ok := r.cards[id]; ok {
+			cards = append(cards, card)
+		}
+	}
+	return cards, nil
+}
+
+func (r *fakeCardRepository) Add(ctx context.Context, item repository.AddCardItem, event domain.CardEvent) (int64, error) {
+	r.next++
+	r.cards[r.next] = domain.Card{ID: r.next, Word: item.Word, Meaning: item.Meaning}
+	event.Card.ID = r.next
+	r.events = append(r.events, event)
+	return int64(r.next), nil
+}
+
+func (r *fakeCardRepository) Update(ctx context.Context, id int, item repository.UpdateCardItem, event domain.CardEvent) error {
+	if _, ok := r.cards[id]; !ok {
+		return repository.ErrNotFound
+	}
+	r.cards[id] = domain.Card{ID: id, Word: item.Word, Meaning: item.Meaning}
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *fakeCardRepository) Delete(ctx context.Context, id int, event domain.CardEvent) error {
+	if _, ok := r.cards[id]; !ok {
+		return repository.ErrNotFound
+	}
+	delete(r.cards, id)
+	r.events = append(r.events, event)
+	return nil
+}
+
+func TestCardChangesRecordEvents(t *testing.T) {
+	repo := newFakeCardRepository()
+	u := NewCardUsecase(repo)
+	ctx := domain.ContextWithUser(context.Background(), 42)
+
+	id, err := u.Create(ctx, CreateCardItem{Word: "hund", Meaning: "dog"})
+	require.NoError(t, err)
+	require.NoError(t, u.Update(ctx, int(id), UpdateCardItem{Word: "hund", Meaning: "a dog"}))
+	require.NoError(t, u.Delete(ctx, int(id)))
+
+	require.Len(t, repo.events, 3)
+	assert.Equal(t, domain.CardCreated, repo.events[0].Type)
+	assert.Equal(t, domain.Card{ID: 1, Word: "hund", Meaning: "dog"}, repo.events[0].Card)
+	assert.Equal(t, domain.CardUpdated, repo.events[1].Type)
+	assert.Equal(t, "a dog", repo.events[1].Card.Meaning)
+	assert.Equal(t, domain.CardDeleted, repo.events[2].Type)
+	assert.Equal(t, domain.Card{ID: 1}, repo.events[2].Card)
+	assert.False(t, repo.events[0].OccurredAt.IsZero())
+	for _, event := range repo.events {
+		assert.Equal(t, 42, event.UserID)
+	}
+}
+
+func TestFailedChangesRecordNothing(t *testing.T) {
+	repo := newFakeCardRepository()
+	u := NewCardUsecase(repo)
+	ctx := context.Background()
+
+	assert.ErrorIs(t, u.Update(ctx, 7, UpdateCardItem{Word: "kat"}), repository.ErrNotFound)
+	assert.ErrorIs(t, u.Delete(ctx, 7), repository.ErrNotFound)
+	assert.Empty(t, repo.events)
+}
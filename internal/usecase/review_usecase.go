@@ -0,0 +1,47 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cupv/mux/internal/domain"
+	"github.com/cupv/mux/internal/repository"
+)
+
+// ErrInvalidReview is returned when a review's grade is out of range
+var ErrInvalidReview = errors.New("invalid review")
+
+type ReviewUsecase interface {
+	// Review records that the user of ctx reviewed a card, together with its review.completed event
+	Review(ctx context.Context, cardId int, grade int) (domain.Review, error)
+}
+
+type reviewUsecase struct {
+	reviewRepo repository.ReviewRepository
+}
+
+// NewReviewUsecase creates the review usecase, every review is stored together with its domain event
+func NewReviewUsecase(reviewRepo repository.ReviewRepository) ReviewUsecase {
+	return tracedReviewUsecase{&reviewUsecase{reviewRepo}}
+}
+
+func (u *reviewUsecase) Review(ctx context.Context, cardId int, grade int) (domain.Review, error) {
+	if grade < domain.MinGrade || grade > domain.MaxGrade {
+		return domain.Review{}, fmt.Errorf("%w: grade must be between %d and %d", ErrInvalidReview, domain.MinGrade, domain.MaxGrade)
+	}
+
+	now := time.Now().UTC()
+	review := domain.Review{CardID: cardId, UserID: domain.UserFromContext(ctx), Grade: grade, ReviewedAt: now}
+	id, err := u.reviewRepo.Add(ctx, repository.AddReviewItem{
+		CardID: review.CardID,
+		UserID: review.UserID,
+		Grade:  review.Grade,
+	}, domain.ReviewEvent{Type: domain.ReviewCompleted, UserID: review.UserID, Review: review, OccurredAt: now})
+	if err != nil {
+		return domain.Review{}, err
+	}
+	review.ID = id
+	return review, nil
+}
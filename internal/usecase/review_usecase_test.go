@@ -0,0 +1,55 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cupv/mux/internal/domain"
+	"github.com/cupv/mux/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeReviewRepository records the reviews and events that would be stored
+type fakeReviewRepository struct {
+	cards  map[int]bool
+	events []domain.ReviewEvent
+}
+
+func (r *fakeReviewRepository) Add(ctx context.Context, item repository.AddReviewItem, event domain.ReviewEvent) (int64, error) {
+	if !r.cards[item.CardID] {
+		return 0, repository.ErrNotFound
+	}
+	event.Review.ID = int64(len(r.events) + 1)
+	r.events = append(r.events, event)
+	return event.Review.ID, nil
+}
+
+func TestReviewRecordsACompletedEvent(t *testing.T) {
+	repo := &fakeReviewRepository{cards: map[int]bool{3: true}}
+	u := NewReviewUsecase(repo)
+	ctx := domain.ContextWithUser(context.Background(), 42)
+
+	review, err := u.Review(ctx, 3, 4)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), review.ID)
+	assert.Equal(t, 42, review.UserID)
+
+	require.Len(t, repo.events, 1)
+	assert.Equal(t, domain.ReviewCompleted, repo.events[0].Type)
+	assert.Equal(t, review, repo.events[0].Review)
+}
+
+func TestInvalidReviewsRecordNothing(t *testing.T) {
+	repo := &fakeReviewRepository{cards: map[int]bool{3: true}}
+	u := NewReviewUsecase(repo)
+	ctx := context.Background()
+
+	for _, grade := range []int{-1, 6} {
+		_, err := u.Review(ctx, 3, grade)
+		assert.ErrorIs(t, err, ErrInvalidReview)
+	}
+	_, err := u.Review(ctx, 7, 3)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	assert.Empty(t, repo.events)
+}
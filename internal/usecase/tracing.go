@@ -0,0 +1,84 @@
+package usecase
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/cupv/mux/internal/domain"
+	"github.com/cupv/mux/pkg/trace"
+)
+
+// tracedCardUsecase wraps every call of a CardUsecase in a span named after it
+type tracedCardUsecase struct {
+	next CardUsecase
+}
+
+func (u tracedCardUsecase) FetchCards(ctx context.Context) (cards []domain.Card, err error) {
+	ctx, span := trace.Start(ctx, "CardUsecase.FetchCards", trace.Internal)
+	defer func() { span.Finish(err) }()
+	return u.next.FetchCards(ctx)
+}
+
+func (u tracedCardUsecase) Create(ctx context.Context, item CreateCardItem) (id int64, err error) {
+	ctx, span := trace.Start(ctx, "CardUsecase.Create", trace.Internal)
+	defer func() { span.Finish(err) }()
+	return u.next.Create(ctx, item)
+}
+
+func (u tracedCardUsecase) Update(ctx context.Context, id int, item UpdateCardItem) (err error) {
+	ctx, span := trace.Start(ctx, "CardUsecase.Update", trace.Internal, slog.Int("card.id", id))
+	defer func() { span.Finish(err) }()
+	return u.next.Update(ctx, id, item)
+}
+
+func (u tracedCardUsecase) Delete(ctx context.Context, id int) (err error) {
+	ctx, span := trace.Start(ctx, "CardUsecase.Delete", trace.Internal, slog.Int("card.id", id))
+	defer func() { span.Finish(err) }()
+	return u.next.Delete(ctx, id)
+}
+
+// tracedWebhookUsecase wraps every call of a WebhookUsecase in a span named after it
+type tracedWebhookUsecase struct {
+	next WebhookUsecase
+}
+
+func (u tracedWebhookUsecase) Register(ctx context.Context, item RegisterWebhookItem) (hook domain.Webhook, err error) {
+	ctx, span := trace.Start(ctx, "WebhookUsecase.Register", trace.Internal)
+	defer func() { span.Finish(err) }()
+	return u.next.Register(ctx, item)
+}
+
+func (u tracedWebhookUsecase) FetchWebhooks(ctx context.Context) (hooks []domain.Webhook, err error) {
+	ctx, span := trace.Start(ctx, "WebhookUsecase.FetchWebhooks", trace.Internal)
+	defer func() { span.Finish(err) }()
+	return u.next.FetchWebhooks(ctx)
+}
+
+func (u tracedWebhookUsecase) Delete(ctx context.Context, id int64) (err error) {
+	ctx, span := trace.Start(ctx, "WebhookUsecase.Delete", trace.Internal, slog.Int64("webhook.id", id))
+	defer func() { span.Finish(err) }()
+	return u.next.Delete(ctx, id)
+}
+
+func (u tracedWebhookUsecase) Deliveries(ctx context.Context, webhookId int64, status string) (deliveries []domain.Delivery, err error) {
+	ctx, span := trace.Start(ctx, "WebhookUsecase.Deliveries", trace.Internal, slog.Int64("webhook.id", webhookId))
+	defer func() { span.Finish(err) }()
+	return u.next.Deliveries(ctx, webhookId, status)
+}
+
+func (u tracedWebhookUsecase) Redeliver(ctx context.Context, deliveryId int64) (err error) {
+	ctx, span := trace.Start(ctx, "WebhookUsecase.Redeliver", trace.Internal, slog.Int64("delivery.id", deliveryId))
+	defer func() { span.Finish(err) }()
+	return u.next.Redeliver(ctx, deliveryId)
+}
+
+// tracedReviewUsecase wraps every call of a ReviewUsecase in a span named after it
+type tracedReviewUsecase struct {
+	next ReviewUsecase
+}
+
+func (u tracedReviewUsecase) Review(ctx context.Context, cardId int, grade int) (review domain.Review, err error) {
+	ctx, span := trace.Start(ctx, "ReviewUsecase.Review", trace.Internal, slog.Int("card.id", cardId))
+	defer func() { span.Finish(err) }()
+	return u.next.Review(ctx, cardId, grade)
+}
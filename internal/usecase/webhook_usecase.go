@@ -0,0 +1,141 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cupv/mux/internal/domain"
+	"github.com/cupv/mux/internal/repository"
+	"github.com/cupv/mux/internal/webhook"
+)
+
+// ErrInvalidWebhook is returned when a registration has a bad URL or event filter
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+type RegisterWebhookItem struct {
+	URL    string
+	Secret string
+	Events []string
+}
+
+// WebhookUsecase manages the webhooks of the user the context carries, see domain.ContextWithUser.
+// Other users' webhooks and deliveries are reported as repository.ErrNotFound.
+type WebhookUsecase interface {
+	// Register stores a webhook and returns it with its secret, one is generated when none is given
+	Register(ctx context.Context, item RegisterWebhookItem) (domain.Webhook, error)
+	FetchWebhooks(ctx context.Context) ([]domain.Webhook, error)
+	Delete(ctx context.Context, id int64) error
+	// Deliveries is the delivery log of a webhook, status picks pending, delivered or dead ones
+	Deliveries(ctx context.Context, webhookId int64, status string) ([]domain.Delivery, error)
+	// Redeliver queues a delivery again with a fresh set of attempts, typically a dead letter
+	Redeliver(ctx context.Context, deliveryId int64) error
+}
+
+type webhookUsecase struct {
+	webhookRepo repository.WebhookRepository
+	allowed     []netip.Prefix
+}
+
+// NewWebhookUsecase creates the webhook usecase, URLs pointing at private addresses are refused unless they are in allowed
+func NewWebhookUsecase(webhookRepo repository.WebhookRepository, allowed []netip.Prefix) WebhookUsecase {
+	return tracedWebhookUsecase{&webhookUsecase{webhookRepo, allowed}}
+}
+
+func (u *webhookUsecase) Register(ctx context.Context, item RegisterWebhookItem) (domain.Webhook, error) {
+	if err := validateWebhook(item, u.allowed); err != nil {
+		return domain.Webhook{}, err
+	}
+	if item.Secret == "" {
+		secret, err := newSecret()
+		if err != nil {
+			return domain.Webhook{}, err
+		}
+		item.Secret = secret
+	}
+
+	userId := domain.UserFromContext(ctx)
+	id, err := u.webhookRepo.AddWebhook(ctx, repository.AddWebhookItem{
+		UserID: userId,
+		URL:    item.URL,
+		Secret: item.Secret,
+		Events: item.Events,
+	})
+	if err != nil {
+		return domain.Webhook{}, err
+	}
+	return domain.Webhook{ID: id, UserID: userId, URL: item.URL, Secret: item.Secret, Events: item.Events, CreatedAt: time.Now().UTC()}, nil
+}
+
+func (u *webhookUsecase) FetchWebhooks(ctx context.Context) ([]domain.Webhook, error) {
+	return u.webhookRepo.GetWebhooks(ctx, domain.UserFromContext(ctx))
+}
+
+func (u *webhookUsecase) Delete(ctx context.Context, id int64) error {
+	return u.webhookRepo.DeleteWebhook(ctx, domain.UserFromContext(ctx), id)
+}
+
+func (u *webhookUsecase) Deliveries(ctx context.Context, webhookId int64, status string) ([]domain.Delivery, error) {
+	switch status {
+	case "", domain.DeliveryPending, domain.DeliveryDelivered, domain.DeliveryDead:
+	default:
+		return nil, fmt.Errorf("%w: unknown delivery status %q", ErrInvalidWebhook, status)
+	}
+	userId := domain.UserFromContext(ctx)
+	hook, err := u.webhookRepo.GetWebhook(ctx, webhookId)
+	if err != nil {
+		return nil, err
+	}
+	if hook.UserID != userId {
+		return nil, repository.ErrNotFound
+	}
+	return u.webhookRepo.GetDeliveries(ctx, userId, webhookId, status)
+}
+
+func (u *webhookUsecase) Redeliver(ctx context.Context, deliveryId int64) error {
+	delivery, err := u.webhookRepo.GetDelivery(ctx, domain.UserFromContext(ctx), deliveryId)
+	if err != nil {
+		return err
+	}
+	delivery.Status = domain.DeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now().UTC()
+	return u.webhookRepo.UpdateDelivery(ctx, *delivery)
+}
+
+// validateWebhook requires an absolute http(s) URL that is not obviously internal, and filters shaped
+// like "*", "card.*" or "card.created". The dispatcher checks the addresses a name resolves to.
+func validateWebhook(item RegisterWebhookItem, allowed []netip.Prefix) error {
+	target, err := url.Parse(item.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidWebhook)
+	}
+	if err := webhook.CheckURL(target, allowed); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidWebhook, err)
+	}
+	if len(item.Events) == 0 {
+		return fmt.Errorf("%w: at least one event filter is required", ErrInvalidWebhook)
+	}
+	for _, filter := range item.Events {
+		family, name, ok := strings.Cut(filter, ".")
+		if filter != "*" && (!ok || family == "" || name == "" || strings.ContainsAny(filter, ", ")) {
+			return fmt.Errorf("%w: bad event filter %q", ErrInvalidWebhook, filter)
+		}
+	}
+	return nil
+}
+
+// newSecret returns 32 random bytes as hex
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
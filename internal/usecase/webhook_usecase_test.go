@@ -0,0 +1,144 @@
+package usecase
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/cupv/mux/internal/domain"
+	"github.com/cupv/mux/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeWebhookRepository keeps webhooks and deliveries in memory, scoped to their owners as the real one is
+type fakeWebhookRepository struct {
+	hooks      map[int64]domain.Webhook
+	deliveries map[int64]domain.Delivery
+	next       int64
+}
+
+func newFakeWebhookRepository() *fakeWebhookRepository {
+	return &fakeWebhookRepository{hooks: make(map[int64]domain.Webhook), deliveries: make(map[int64]domain.Delivery)}
+}
+
+func (r *fakeWebhookRepository) AddWebhook(ctx context.Context, item repository.AddWebhookItem) (int64, error) {
+	r.next++
+	r.hooks[r.next] = domain.Webhook{ID: r.next, UserID: item.UserID, URL: item.URL, Secret: item.Secret, Events: item.Events}
+	return r.next, nil
+}
+
+func (r *fakeWebhookRepository) GetWebhooks(ctx context.Context, userId int) ([]domain.Webhook, error) {
+	var hooks []domain.Webhook
+	for _, hook := range r.hooks {
+		if hook.UserID == userId {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks, nil
+}
+
+func (r *fakeWebhookRepository) GetWebhook(ctx context.Context, id int64) (*domain.Webhook, error) {
+	hook, ok := r.hooks[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &hook, nil
+}
+
+func (r *fakeWebhookRepository) DeleteWebhook(ctx context.Context, userId int, id int64) error {
+	if hook, ok := r.hooks[id]; !ok || hook.UserID != userId {
+		return repository.ErrNotFound
+	}
+	delete(r.hooks, id)
+	return nil
+}
+
+func (r *fakeWebhookRepository) AddDelivery(ctx context.Context, webhookId int64, eventType string, payload []byte) (int64, error) {
+	r.next++
+	r.deliveries[r.next] = domain.Delivery{ID: r.next, WebhookID: webhookId, EventType: eventType, Status: domain.DeliveryDead}
+	return r.next, nil
+}
+
+func (r *fakeWebhookRepository) GetDelivery(ctx context.Context, userId int, id int64) (*domain.Delivery, error) {
+	delivery, ok := r.deliveries[id]
+	if !ok || r.hooks[delivery.WebhookID].UserID != userId {
+		return nil, repository.ErrNotFound
+	}
+	return &delivery, nil
+}
+
+func (r *fakeWebhookRepository) GetDeliveries(ctx context.Context, userId int, webhookId int64, status string) ([]domain.Delivery, error) {
+	var deliveries []domain.Delivery
+	for _, delivery := range r.deliveries {
+		if delivery.WebhookID == webhookId && r.hooks[webhookId].UserID == userId {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+func (r *fakeWebhookRepository) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]domain.Delivery, error) {
+	return nil, nil
+}
+
+func (r *fakeWebhookRepository) UpdateDelivery(ctx context.Context, delivery domain.Delivery) error {
+	r.deliveries[delivery.ID] = delivery
+	return nil
+}
+
+func TestWebhooksBelongToTheUserWhoRegisteredThem(t *testing.T) {
+	repo := newFakeWebhookRepository()
+	u := NewWebhookUsecase(repo, nil)
+	ana := domain.ContextWithUser(context.Background(), 42)
+	ben := domain.ContextWithUser(context.Background(), 7)
+
+	hook, err := u.Register(ana, RegisterWebhookItem{URL: "https://lms.example.com/hooks", Events: []string{"*"}})
+	require.NoError(t, err)
+	assert.Equal(t, 42, hook.UserID)
+	deliveryId, _ := repo.AddDelivery(ana, hook.ID, domain.CardCreated, []byte(`{}`))
+
+	hooks, err := u.FetchWebhooks(ben)
+	require.NoError(t, err)
+	assert.Empty(t, hooks)
+	_, err = u.Deliveries(ben, hook.ID, "")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	assert.ErrorIs(t, u.Redeliver(ben, deliveryId), repository.ErrNotFound)
+	assert.ErrorIs(t, u.Delete(ben, hook.ID), repository.ErrNotFound)
+
+	// The owner sees and manages their webhook
+	hooks, err = u.FetchWebhooks(ana)
+	require.NoError(t, err)
+	assert.Len(t, hooks, 1)
+	deliveries, err := u.Deliveries(ana, hook.ID, "")
+	require.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+	require.NoError(t, u.Redeliver(ana, deliveryId))
+	assert.Equal(t, domain.DeliveryPending, repo.deliveries[deliveryId].Status)
+	assert.NoError(t, u.Delete(ana, hook.ID))
+}
+
+func TestValidateWebhook(t *testing.T) {
+	valid := RegisterWebhookItem{URL: "https://lms.example.com/hooks", Events: []string{"card.*", "card.created", "*"}}
+	assert.NoError(t, validateWebhook(valid, nil))
+
+	for name, item := range map[string]RegisterWebhookItem{
+		"relative url": {URL: "/hooks", Events: []string{"*"}},
+		"other scheme": {URL: "ftp://lms.example.com", Events: []string{"*"}},
+		"no filters":   {URL: "https://lms.example.com"},
+		"no family":    {URL: "https://lms.example.com", Events: []string{"created"}},
+		"comma":        {URL: "https://lms.example.com", Events: []string{"card.created,card.deleted"}},
+		"empty action": {URL: "https://lms.example.com", Events: []string{"card."}},
+		"loopback":     {URL: "http://127.0.0.1:9090/loglevel", Events: []string{"*"}},
+		"localhost":    {URL: "http://localhost/hooks", Events: []string{"*"}},
+		"metadata":     {URL: "http://169.254.169.254/latest/meta-data", Events: []string{"*"}},
+	} {
+		assert.ErrorIs(t, validateWebhook(item, nil), ErrInvalidWebhook, name)
+	}
+
+	// Allowed networks are exempt
+	internal := RegisterWebhookItem{URL: "http://10.20.0.5/hooks", Events: []string{"*"}}
+	assert.ErrorIs(t, validateWebhook(internal, nil), ErrInvalidWebhook)
+	assert.NoError(t, validateWebhook(internal, []netip.Prefix{netip.MustParsePrefix("10.20.0.0/16")}))
+}
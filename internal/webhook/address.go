@@ -0,0 +1,60 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// ErrForbiddenAddress is returned when a webhook would reach an address of the service's own network
+var ErrForbiddenAddress = errors.New("address not allowed for webhooks")
+
+// sharedAddressSpace is the carrier-grade NAT range, private in all but name
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// Forbidden reports whether webhooks must not reach addr: loopback, link-local, private, unspecified
+// and multicast addresses are refused unless they are in one of the allowed networks
+func Forbidden(addr netip.Addr, allowed []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, network := range allowed {
+		if network.Contains(addr) {
+			return false
+		}
+	}
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsUnspecified() || addr.IsMulticast() || sharedAddressSpace.Contains(addr)
+}
+
+// CheckURL refuses webhook URLs whose host is localhost or a forbidden literal address.
+// Names are only resolved when the dispatcher connects, which checks every address it dials.
+func CheckURL(target *url.URL, allowed []netip.Prefix) error {
+	host := strings.TrimSuffix(strings.ToLower(target.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && Forbidden(addr, allowed) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	return nil
+}
+
+// guardedDialer refuses connections to forbidden addresses. Control runs after the name is resolved,
+// for every address tried and every redirect, so DNS cannot point a webhook around the check.
+func guardedDialer(allowed []netip.Prefix) *net.Dialer {
+	return &net.Dialer{
+		Control: func(network, address string, c syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if Forbidden(addrPort.Addr(), allowed) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,54 @@
+package webhook
+
+import (
+	"net/netip"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForbidden(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":    false,
+		"2606:4700::1111":  false,
+		"127.0.0.1":        true,
+		"::1":              true,
+		"10.1.2.3":         true,
+		"172.16.0.1":       true,
+		"192.168.1.1":      true,
+		"169.254.169.254":  true,
+		"fe80::1":          true,
+		"fd00::1":          true,
+		"0.0.0.0":          true,
+		"100.64.0.1":       true,
+		"::ffff:127.0.0.1": true,
+	} {
+		assert.Equal(t, want, Forbidden(netip.MustParseAddr(addr), nil), addr)
+	}
+
+	allowed := []netip.Prefix{netip.MustParsePrefix("10.20.0.0/16")}
+	assert.False(t, Forbidden(netip.MustParseAddr("10.20.1.1"), allowed))
+	assert.True(t, Forbidden(netip.MustParseAddr("10.21.1.1"), allowed))
+}
+
+func TestCheckURL(t *testing.T) {
+	for raw, want := range map[string]bool{
+		"https://lms.example.com/hooks":      true,
+		"https://93.184.216.34/hooks":        true,
+		"http://localhost:9090/loglevel":     false,
+		"http://api.localhost/":              false,
+		"http://127.0.0.1:9090/debug/pprof/": false,
+		"http://[::1]/":                      false,
+		"http://169.254.169.254/latest/":     false,
+	} {
+		target, err := url.Parse(raw)
+		require.NoError(t, err)
+		if want {
+			assert.NoError(t, CheckURL(target, nil), raw)
+		} else {
+			assert.ErrorIs(t, CheckURL(target, nil), ErrForbiddenAddress, raw)
+		}
+	}
+}
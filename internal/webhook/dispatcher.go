@@ -0,0 +1,214 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/cupv/mux/internal/domain"
+	"github.com/cupv/mux/internal/repository"
+	"github.com/cupv/mux/pkg/logging"
+	"github.com/cupv/mux/pkg/trace"
+)
+
+// Options tunes delivery
+type Options struct {
+	MaxAttempts int           // attempts before a delivery becomes a dead letter
+	BaseDelay   time.Duration // wait after the first failure, doubled after each further one
+	MaxDelay    time.Duration // cap on the wait between attempts
+	Timeout     time.Duration // per request timeout
+	Batch       int           // deliveries claimed at a time
+	Interval    time.Duration // how often due deliveries are looked for
+	Lease       time.Duration // how long a claimed delivery is hidden from other instances
+	// AllowedNetworks may be reached even though they are private, e.g. an LMS in the same cluster
+	AllowedNetworks []netip.Prefix
+}
+
+// DefaultOptions gives a receiver about three and a half hours to recover before giving up
+func DefaultOptions() Options {
+	return Options{
+		MaxAttempts: 10,
+		BaseDelay:   30 * time.Second,
+		MaxDelay:    time.Hour,
+		Timeout:     10 * time.Second,
+		Batch:       50,
+		Interval:    time.Second,
+		Lease:       time.Minute,
+	}
+}
+
+// Dispatcher turns events into webhook deliveries and sends them.
+// Deliveries live in the database, so any instance can send them and none is lost on restart.
+type Dispatcher struct {
+	repo    repository.WebhookRepository
+	client  *http.Client
+	options Options
+}
+
+// NewDispatcher creates a dispatcher whose requests never reach forbidden addresses, see Forbidden.
+// Proxies are not used, they would be dialed instead of the receiver and hide it from the check.
+func NewDispatcher(repo repository.WebhookRepository, options Options) *Dispatcher {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = guardedDialer(options.AllowedNetworks).DialContext
+	return &Dispatcher{
+		repo:    repo,
+		client:  &http.Client{Timeout: options.Timeout, Transport: transport},
+		options: options,
+	}
+}
+
+// Publish queues an event for every webhook of the user who caused it whose filters match its type,
+// it lets the outbox relay feed webhooks. Events of no user reach no webhook.
+func (d *Dispatcher) Publish(ctx context.Context, topic string, payload []byte) error {
+	var event struct {
+		Type   string `json:"type"`
+		UserID int    `json:"user_id"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil || event.Type == "" {
+		slog.WarnContext(ctx, "Webhook event decode error", "topic", topic, "error", err)
+		return nil
+	}
+	if event.UserID == 0 {
+		return nil
+	}
+
+	hooks, err := d.repo.GetWebhooks(ctx, event.UserID)
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		if !hook.Matches(event.Type) {
+			continue
+		}
+		if _, err := d.repo.AddDelivery(ctx, hook.ID, event.Type, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run sends due deliveries until ctx is done, a full batch is followed right away by the next one
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		n, err := d.DeliverDue(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "Webhook dispatch error", "error", err)
+		}
+		if err == nil && n == d.options.Batch {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.options.Interval):
+		}
+	}
+}
+
+// DeliverDue claims the deliveries that are due, attempts each once and returns how many were attempted
+func (d *Dispatcher) DeliverDue(ctx context.Context) (int, error) {
+	due, err := d.repo.ClaimDeliveries(ctx, d.options.Batch, d.options.Lease)
+	if err != nil {
+		return 0, err
+	}
+	for _, delivery := range due {
+		if err := d.attempt(ctx, delivery); err != nil {
+			return 0, err
+		}
+	}
+	return len(due), nil
+}
+
+// attempt sends a delivery and records the outcome, scheduling a retry or giving up after MaxAttempts
+func (d *Dispatcher) attempt(ctx context.Context, delivery domain.Delivery) error {
+	now := time.Now().UTC()
+	delivery.Attempts++
+	ctx = logging.With(ctx, slog.Int64("delivery.id", delivery.ID), slog.Int64("webhook.id", delivery.WebhookID))
+	// The outcome is recorded even when stopping, so a sent delivery is not sent again
+	record := context.WithoutCancel(ctx)
+
+	hook, err := d.repo.GetWebhook(ctx, delivery.WebhookID)
+	if errors.Is(err, repository.ErrNotFound) {
+		delivery.Status = domain.DeliveryDead
+		delivery.LastError = "webhook deleted"
+		return d.repo.UpdateDelivery(record, delivery)
+	}
+	if err != nil {
+		return err
+	}
+
+	delivery.LastStatusCode, err = d.send(ctx, hook, delivery, now)
+	switch {
+	case err == nil:
+		delivery.Status = domain.DeliveryDelivered
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+	case delivery.Attempts >= d.options.MaxAttempts:
+		delivery.Status = domain.DeliveryDead
+		delivery.LastError = err.Error()
+		slog.WarnContext(ctx, "Webhook delivery is a dead letter", "attempts", delivery.Attempts, "error", err)
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = now.Add(d.retryDelay(delivery.Attempts))
+		slog.DebugContext(ctx, "Webhook delivery failed, retrying", "attempts", delivery.Attempts, "error", err)
+	}
+	return d.repo.UpdateDelivery(record, delivery)
+}
+
+// send posts the signed payload and returns the response status, anything but 2xx is an error
+func (d *Dispatcher) send(ctx context.Context, hook *domain.Webhook, delivery domain.Delivery, now time.Time) (code int, err error) {
+	ctx, span := trace.Start(ctx, "POST webhook", trace.Client,
+		slog.Int64("webhook.id", hook.ID),
+		slog.Int64("delivery.id", delivery.ID),
+		slog.Int("delivery.attempt", delivery.Attempts))
+	defer func() {
+		span.SetAttributes(slog.Int("http.response.status_code", code))
+		span.Finish(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "card-webhooks")
+	req.Header.Set(HeaderID, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, timestamp, delivery.Payload))
+	if traceparent := span.SpanContext().Traceparent(); traceparent != "" {
+		req.Header.Set(trace.TraceparentHeader, traceparent)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryDelay is BaseDelay doubled for every failed attempt after the first, capped at MaxDelay
+func (d *Dispatcher) retryDelay(attempts int) time.Duration {
+	delay := d.options.BaseDelay
+	for i := 1; i < attempts && delay < d.options.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, d.options.MaxDelay)
+}
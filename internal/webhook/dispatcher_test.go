@@ -0,0 +1,303 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cupv/mux/internal/domain"
+	"github.com/cupv/mux/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeWebhookRepository keeps webhooks and deliveries in memory
+type fakeWebhookRepository struct {
+	mutex      sync.Mutex
+	hooks      map[int64]domain.Webhook
+	deliveries map[int64]domain.Delivery
+	next       int64
+}
+
+func newFakeWebhookRepository() *fakeWebhookRepository {
+	return &fakeWebhookRepository{hooks: make(map[int64]domain.Webhook), deliveries: make(map[int64]domain.Delivery)}
+}
+
+func (r *fakeWebhookRepository) AddWebhook(ctx context.Context, item repository.AddWebhookItem) (int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.next++
+	r.hooks[r.next] = domain.Webhook{ID: r.next, UserID: item.UserID, URL: item.URL, Secret: item.Secret, Events: item.Events}
+	return r.next, nil
+}
+
+func (r *fakeWebhookRepository) GetWebhooks(ctx context.Context, userId int) ([]domain.Webhook, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var hooks []domain.Webhook
+	for _, hook := range r.hooks {
+		if hook.UserID == userId {
+			hooks = append(hooks, hook)
+		}
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].ID < hooks[j].ID })
+	return hooks, nil
+}
+
+func (r *fakeWebhookRepository) GetWebhook(ctx context.Context, id int64) (*domain.Webhook, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	hook, ok := r.hooks[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &hook, nil
+}
+
+func (r *fakeWebhookRepository) DeleteWebhook(ctx context.Context, userId int, id int64) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if hook, ok := r.hooks[id]; !ok || hook.UserID != userId {
+		return repository.ErrNotFound
+	}
+	delete(r.hooks, id)
+	return nil
+}
+
+func (r *fakeWebhookRepository) AddDelivery(ctx context.Context, webhookId int64, eventType string, payload []byte) (int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.next++
+	r.deliveries[r.next] = domain.Delivery{ID: r.next, WebhookID: webhookId, EventType: eventType, Payload: payload,
+		Status: domain.DeliveryPending, NextAttemptAt: time.Now().UTC()}
+	return r.next, nil
+}
+
+func (r *fakeWebhookRepository) GetDelivery(ctx context.Context, userId int, id int64) (*domain.Delivery, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delivery, ok := r.deliveries[id]
+	if !ok || r.hooks[delivery.WebhookID].UserID != userId {
+		return nil, repository.ErrNotFound
+	}
+	return &delivery, nil
+}
+
+func (r *fakeWebhookRepository) GetDeliveries(ctx context.Context, userId int, webhookId int64, status string) ([]domain.Delivery, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var deliveries []domain.Delivery
+	if r.hooks[webhookId].UserID != userId {
+		return nil, nil
+	}
+	for _, delivery := range r.deliveries {
+		if delivery.WebhookID == webhookId && (status == "" || delivery.Status == status) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID > deliveries[j].ID })
+	return deliveries, nil
+}
+
+func (r *fakeWebhookRepository) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]domain.Delivery, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now().UTC()
+	var claimed []domain.Delivery
+	for _, delivery := range r.deliveries {
+		if len(claimed) < limit && delivery.Status == domain.DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			claimed = append(claimed, delivery)
+			delivery.NextAttemptAt = now.Add(lease)
+			r.deliveries[delivery.ID] = delivery
+		}
+	}
+	return claimed, nil
+}
+
+func (r *fakeWebhookRepository) UpdateDelivery(ctx context.Context, delivery domain.Delivery) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.deliveries[delivery.ID] = delivery
+	return nil
+}
+
+// receiver is an httptest stand-in for the LMS, it answers with status and records what it was sent
+type receiver struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func startReceiver(t *testing.T, status int) *receiver {
+	rec := &receiver{status: status}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mutex.Lock()
+		rec.requests = append(rec.requests, r)
+		rec.bodies = append(rec.bodies, body)
+		status := rec.status
+		rec.mutex.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+func (rec *receiver) received() int {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	return len(rec.requests)
+}
+
+// testOptions retries quickly and lets webhooks reach the loopback receivers of the tests
+func testOptions() Options {
+	options := DefaultOptions()
+	options.MaxAttempts = 3
+	options.BaseDelay = time.Millisecond
+	options.MaxDelay = 4 * time.Millisecond
+	options.AllowedNetworks = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+	return options
+}
+
+func TestPublishedEventsAreSignedAndDeliveredToMatchingWebhooks(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeWebhookRepository()
+	rec := startReceiver(t, http.StatusNoContent)
+	cards, _ := repo.AddWebhook(ctx, repository.AddWebhookItem{UserID: 42, URL: rec.URL, Secret: "s3cret", Events: []string{"card.*"}})
+	deletes, _ := repo.AddWebhook(ctx, repository.AddWebhookItem{UserID: 42, URL: rec.URL, Secret: "other", Events: []string{"card.deleted"}})
+	d := NewDispatcher(repo, testOptions())
+
+	payload := []byte(`{"type":"card.created","card":{"id":7,"word":"hola","meaning":"hello"},"user_id":42}`)
+	require.NoError(t, d.Publish(ctx, domain.CardEventsTopic, payload))
+	n, err := d.DeliverDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// Only the card.* webhook gets it, signed with its secret
+	require.Equal(t, 1, rec.received())
+	req, body := rec.requests[0], rec.bodies[0]
+	assert.JSONEq(t, string(payload), string(body))
+	assert.Equal(t, "card.created", req.Header.Get(HeaderEvent))
+	assert.NoError(t, Verify("s3cret", req.Header.Get(HeaderSignature), req.Header.Get(HeaderTimestamp), body, time.Minute))
+	assert.ErrorIs(t, Verify("other", req.Header.Get(HeaderSignature), req.Header.Get(HeaderTimestamp), body, time.Minute), ErrBadSignature)
+
+	log, err := repo.GetDeliveries(ctx, 42, cards, "")
+	require.NoError(t, err)
+	require.Len(t, log, 1)
+	assert.Equal(t, strconv.FormatInt(log[0].ID, 10), req.Header.Get(HeaderID))
+	assert.Equal(t, domain.DeliveryDelivered, log[0].Status)
+	assert.Equal(t, 1, log[0].Attempts)
+	assert.Equal(t, http.StatusNoContent, log[0].LastStatusCode)
+	assert.NotNil(t, log[0].DeliveredAt)
+
+	log, err = repo.GetDeliveries(ctx, 42, deletes, "")
+	require.NoError(t, err)
+	assert.Empty(t, log)
+}
+
+func TestWebhooksOnlyGetTheirOwnersEvents(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeWebhookRepository()
+	rec := startReceiver(t, http.StatusNoContent)
+	mine, _ := repo.AddWebhook(ctx, repository.AddWebhookItem{UserID: 42, URL: rec.URL, Secret: "s3cret", Events: []string{"*"}})
+	theirs, _ := repo.AddWebhook(ctx, repository.AddWebhookItem{UserID: 7, URL: rec.URL, Secret: "other", Events: []string{"*"}})
+	d := NewDispatcher(repo, testOptions())
+
+	require.NoError(t, d.Publish(ctx, domain.ReviewEventsTopic, []byte(`{"type":"review.completed","review":{"card_id":3,"user_id":42,"grade":4},"user_id":42}`)))
+	require.NoError(t, d.Publish(ctx, domain.CardEventsTopic, []byte(`{"type":"card.created","card":{"id":8}}`)))
+	_, err := d.DeliverDue(ctx)
+	require.NoError(t, err)
+
+	// Events without a user reach nobody
+	assert.Equal(t, 1, rec.received())
+	log, _ := repo.GetDeliveries(ctx, 42, mine, "")
+	require.Len(t, log, 1)
+	assert.Equal(t, domain.ReviewCompleted, log[0].EventType)
+	log, _ = repo.GetDeliveries(ctx, 7, theirs, "")
+	assert.Empty(t, log)
+}
+
+func TestFailedDeliveriesBackOffAndEndAsDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeWebhookRepository()
+	rec := startReceiver(t, http.StatusServiceUnavailable)
+	hook, _ := repo.AddWebhook(ctx, repository.AddWebhookItem{UserID: 42, URL: rec.URL, Secret: "s3cret", Events: []string{"*"}})
+	d := NewDispatcher(repo, testOptions())
+
+	require.NoError(t, d.Publish(ctx, domain.CardEventsTopic, []byte(`{"type":"card.deleted","card":{"id":7},"user_id":42}`)))
+	_, err := d.DeliverDue(ctx)
+	require.NoError(t, err)
+
+	// The first failure schedules a retry instead of giving up
+	log, _ := repo.GetDeliveries(ctx, 42, hook, domain.DeliveryPending)
+	require.Len(t, log, 1)
+	assert.Equal(t, 1, log[0].Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, log[0].LastStatusCode)
+	assert.Contains(t, log[0].LastError, "503")
+	assert.True(t, log[0].NextAttemptAt.After(time.Now().Add(-time.Second)))
+
+	require.Eventually(t, func() bool {
+		d.DeliverDue(ctx)
+		dead, _ := repo.GetDeliveries(ctx, 42, hook, domain.DeliveryDead)
+		return len(dead) == 1
+	}, time.Second, 2*time.Millisecond)
+	dead, _ := repo.GetDeliveries(ctx, 42, hook, domain.DeliveryDead)
+	assert.Equal(t, 3, dead[0].Attempts)
+	assert.Equal(t, 3, rec.received())
+
+	// Nothing is sent for a dead letter until it is queued again
+	d.DeliverDue(ctx)
+	assert.Equal(t, 3, rec.received())
+}
+
+func TestDeliveriesNeverReachForbiddenAddresses(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeWebhookRepository()
+	rec := startReceiver(t, http.StatusNoContent)
+	hook, _ := repo.AddWebhook(ctx, repository.AddWebhookItem{UserID: 42, URL: rec.URL, Secret: "s3cret", Events: []string{"*"}})
+	options := testOptions()
+	options.AllowedNetworks = nil
+	d := NewDispatcher(repo, options)
+
+	require.NoError(t, d.Publish(ctx, domain.CardEventsTopic, []byte(`{"type":"card.deleted","card":{"id":7},"user_id":42}`)))
+	_, err := d.DeliverDue(ctx)
+	require.NoError(t, err)
+
+	// The receiver listens on loopback, so the connection is refused before anything is sent
+	assert.Zero(t, rec.received())
+	log, _ := repo.GetDeliveries(ctx, 42, hook, "")
+	require.Len(t, log, 1)
+	assert.Contains(t, log[0].LastError, ErrForbiddenAddress.Error())
+}
+
+func TestRetryDelayDoublesUpToTheCap(t *testing.T) {
+	d := NewDispatcher(nil, Options{BaseDelay: 30 * time.Second, MaxDelay: 5 * time.Minute})
+	var delays []time.Duration
+	for attempts := 1; attempts <= 6; attempts++ {
+		delays = append(delays, d.retryDelay(attempts))
+	}
+	assert.Equal(t, []time.Duration{
+		30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute,
+	}, delays)
+}
+
+func TestVerifyRejectsTamperingAndReplays(t *testing.T) {
+	body := []byte(`{"type":"card.created"}`)
+	now := time.Now().Unix()
+	signature := Sign("s3cret", now, body)
+
+	assert.NoError(t, Verify("s3cret", signature, strconv.FormatInt(now, 10), body, time.Minute))
+	assert.ErrorIs(t, Verify("s3cret", signature, strconv.FormatInt(now, 10), []byte(`{"type":"card.deleted"}`), time.Minute), ErrBadSignature)
+
+	old := time.Now().Add(-time.Hour).Unix()
+	assert.ErrorIs(t, Verify("s3cret", Sign("s3cret", old, body), strconv.FormatInt(old, 10), body, time.Minute), ErrBadSignature)
+}
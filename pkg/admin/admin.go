@@ -0,0 +1,228 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	rpprof "runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/cupv/mux/pkg/logging"
+	"github.com/gorilla/mux"
+)
+
+// started is when the process started, near enough, for the uptime in Runtime
+var started = time.Now()
+
+// Options configure the admin server
+type Options struct {
+	// Token returns the bearer token operators authenticate with. It is read on every request, so a rotated
+	// secret applies right away. No request is allowed while it is empty.
+	Token func() string
+	// Levels are the log levels of the process, changed through /loglevel
+	Levels *logging.Levels
+	// Config renders the effective configuration with secrets redacted
+	Config func() string
+}
+
+// New returns the admin router serving profiles, runtime state, build info, the configuration and log levels.
+// Binaries add their own routes to it. Every route, theirs included, requires the token.
+func New(opts Options) *mux.Router {
+	h := &handler{opts: opts}
+	router := mux.NewRouter()
+	router.Use(h.authenticate)
+
+	router.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline).Methods("GET")
+	router.HandleFunc("/debug/pprof/profile", pprof.Profile).Methods("GET")
+	router.HandleFunc("/debug/pprof/symbol", pprof.Symbol).Methods("GET", "POST")
+	router.HandleFunc("/debug/pprof/trace", pprof.Trace).Methods("GET")
+	// The index also serves the named profiles: heap, goroutine, block, mutex, allocs and threadcreate
+	router.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index).Methods("GET")
+	router.HandleFunc("/debug/goroutines", h.goroutines).Methods("GET")
+
+	router.HandleFunc("/runtime", h.runtime).Methods("GET")
+	router.HandleFunc("/buildinfo", h.buildInfo).Methods("GET")
+	router.HandleFunc("/config", h.config).Methods("GET")
+	router.HandleFunc("/loglevel", h.getLevels).Methods("GET")
+	router.HandleFunc("/loglevel", h.setLevel).Methods("PUT")
+	router.HandleFunc("/loglevel/{package:.+}", h.setLevel).Methods("PUT")
+	router.HandleFunc("/loglevel/{package:.+}", h.unsetLevel).Methods("DELETE")
+	return router
+}
+
+type handler struct {
+	opts Options
+}
+
+// authenticate lets through requests with the bearer token, and answers 401 to the rest
+func (h *handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := h.opts.Token()
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// goroutines dumps the stack of every goroutine, as a panic would
+func (h *handler) goroutines(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rpprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+// Runtime is the state of the Go runtime reported by /runtime
+type Runtime struct {
+	GoVersion  string        `json:"go_version"`
+	GOOS       string        `json:"goos"`
+	GOARCH     string        `json:"goarch"`
+	NumCPU     int           `json:"num_cpu"`
+	GOMAXPROCS int           `json:"gomaxprocs"`
+	Goroutines int           `json:"goroutines"`
+	Uptime     string        `json:"uptime"`
+	HeapAlloc  uint64        `json:"heap_alloc_bytes"`
+	HeapInuse  uint64        `json:"heap_inuse_bytes"`
+	HeapIdle   uint64        `json:"heap_idle_bytes"`
+	Sys        uint64        `json:"sys_bytes"`
+	Mallocs    uint64        `json:"mallocs"`
+	Frees      uint64        `json:"frees"`
+	NumGC      uint32        `json:"num_gc"`
+	PauseTotal time.Duration `json:"gc_pause_total_ns"`
+	LastGC     *time.Time    `json:"last_gc,omitempty"`
+}
+
+func (h *handler) runtime(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	state := Runtime{
+		GoVersion:  runtime.Version(),
+		GOOS:       runtime.GOOS,
+		GOARCH:     runtime.GOARCH,
+		NumCPU:     runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Goroutines: runtime.NumGoroutine(),
+		Uptime:     time.Since(started).Round(time.Second).String(),
+		HeapAlloc:  mem.HeapAlloc,
+		HeapInuse:  mem.HeapInuse,
+		HeapIdle:   mem.HeapIdle,
+		Sys:        mem.Sys,
+		Mallocs:    mem.Mallocs,
+		Frees:      mem.Frees,
+		NumGC:      mem.NumGC,
+		PauseTotal: time.Duration(mem.PauseTotalNs),
+	}
+	if mem.LastGC > 0 {
+		last := time.Unix(0, int64(mem.LastGC))
+		state.LastGC = &last
+	}
+	writeJSON(w, state)
+}
+
+// BuildInfo is how the binary was built, as reported by /buildinfo
+type BuildInfo struct {
+	GoVersion string            `json:"go_version"`
+	Path      string            `json:"path"`
+	Version   string            `json:"version"`
+	Settings  map[string]string `json:"settings"`
+	Deps      []Module          `json:"deps"`
+}
+
+// Module is a dependency compiled into the binary
+type Module struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Replace string `json:"replace,omitempty"`
+}
+
+func (h *handler) buildInfo(w http.ResponseWriter, r *http.Request) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		http.Error(w, "Build info is not available", http.StatusNotFound)
+		return
+	}
+	build := BuildInfo{
+		GoVersion: info.GoVersion,
+		Path:      info.Path,
+		Version:   info.Main.Version,
+		Settings:  make(map[string]string, len(info.Settings)),
+		Deps:      make([]Module, 0, len(info.Deps)),
+	}
+	// Settings include vcs.revision, vcs.time and vcs.modified when built from a checkout
+	for _, setting := range info.Settings {
+		build.Settings[setting.Key] = setting.Value
+	}
+	for _, dep := range info.Deps {
+		module := Module{Path: dep.Path, Version: dep.Version}
+		if dep.Replace != nil {
+			module.Replace = dep.Replace.Path + " " + dep.Replace.Version
+		}
+		build.Deps = append(build.Deps, module)
+	}
+	writeJSON(w, build)
+}
+
+// config shows the effective configuration, one key per line with where its value came from
+func (h *handler) config(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(h.opts.Config()))
+}
+
+// Levels is the body of /loglevel: the default level and the package overrides
+type Levels struct {
+	Level    string            `json:"level"`
+	Packages map[string]string `json:"packages"`
+}
+
+func (h *handler) getLevels(w http.ResponseWriter, r *http.Request) {
+	levels := Levels{Level: h.opts.Levels.Level().String(), Packages: make(map[string]string)}
+	for pkg, level := range h.opts.Levels.Packages() {
+		levels.Packages[pkg] = level.String()
+	}
+	writeJSON(w, levels)
+}
+
+// setLevel changes the default level, or the level of the package in the path, to the level in the body
+func (h *handler) setLevel(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Level string `json:"level"`
+	}
+	var level slog.Level
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := level.UnmarshalText([]byte(body.Level)); err != nil {
+		http.Error(w, "Invalid level, expected debug, info, warn or error", http.StatusBadRequest)
+		return
+	}
+
+	if pkg := mux.Vars(r)["package"]; pkg != "" {
+		h.opts.Levels.Set(strings.Trim(pkg, "/"), level)
+		slog.InfoContext(r.Context(), "Package log level changed", "package", pkg, "level", level)
+	} else {
+		h.opts.Levels.SetDefault(level)
+		slog.InfoContext(r.Context(), "Log level changed", "level", level)
+	}
+	h.getLevels(w, r)
+}
+
+// unsetLevel drops the override of the package in the path
+func (h *handler) unsetLevel(w http.ResponseWriter, r *http.Request) {
+	pkg := mux.Vars(r)["package"]
+	h.opts.Levels.Unset(strings.Trim(pkg, "/"))
+	slog.InfoContext(r.Context(), "Package log level reset", "package", pkg)
+	h.getLevels(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cupv/mux/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAdmin(token string) (http.Handler, *logging.Levels) {
+	levels := logging.NewLevels(slog.LevelInfo, nil)
+	router := New(Options{
+		Token:  func() string { return token },
+		Levels: levels,
+		Config: func() string { return "port = 8080 # default\n" },
+	})
+	return router, levels
+}
+
+func serve(h http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestEveryRouteRequiresTheToken(t *testing.T) {
+	h, _ := newTestAdmin("s3cret")
+	for _, path := range []string{"/runtime", "/buildinfo", "/config", "/loglevel", "/debug/pprof/", "/debug/goroutines"} {
+		assert.Equal(t, http.StatusUnauthorized, serve(h, http.MethodGet, path, "", "").Code, path)
+		assert.Equal(t, http.StatusUnauthorized, serve(h, http.MethodGet, path, "wrong", "").Code, path)
+		assert.Equal(t, http.StatusOK, serve(h, http.MethodGet, path, "s3cret", "").Code, path)
+	}
+	assert.Equal(t, `Bearer realm="admin"`, serve(h, http.MethodGet, "/runtime", "", "").Header().Get("WWW-Authenticate"))
+
+	// Without a configured token nothing is allowed
+	h, _ = newTestAdmin("")
+	assert.Equal(t, http.StatusUnauthorized, serve(h, http.MethodGet, "/runtime", "", "").Code)
+}
+
+func TestRuntimeStateAndConfig(t *testing.T) {
+	h, _ := newTestAdmin("s3cret")
+
+	var state Runtime
+	require.NoError(t, json.Unmarshal(serve(h, http.MethodGet, "/runtime", "s3cret", "").Body.Bytes(), &state))
+	assert.Positive(t, state.Goroutines)
+	assert.Positive(t, state.HeapAlloc)
+	assert.NotEmpty(t, state.GoVersion)
+
+	assert.Contains(t, serve(h, http.MethodGet, "/debug/goroutines", "s3cret", "").Body.String(), "goroutine ")
+	assert.Contains(t, serve(h, http.MethodGet, "/debug/pprof/heap?debug=1", "s3cret", "").Body.String(), "heap profile")
+	assert.Equal(t, "port = 8080 # default\n", serve(h, http.MethodGet, "/config", "s3cret", "").Body.String())
+
+	var build BuildInfo
+	require.NoError(t, json.Unmarshal(serve(h, http.MethodGet, "/buildinfo", "s3cret", "").Body.Bytes(), &build))
+	assert.NotEmpty(t, build.GoVersion)
+}
+
+func TestLogLevelsChangeLive(t *testing.T) {
+	h, levels := newTestAdmin("s3cret")
+
+	rec := serve(h, http.MethodPut, "/loglevel", "s3cret", `{"level":"warn"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, slog.LevelWarn, levels.Level())
+
+	rec = serve(h, http.MethodPut, "/loglevel/internal/outbox", "s3cret", `{"level":"debug"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var got Levels
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, Levels{Level: "WARN", Packages: map[string]string{"internal/outbox": "DEBUG"}}, got)
+	assert.Equal(t, slog.LevelDebug, levels.For("github.com/cupv/mux/internal/outbox"))
+
+	require.Equal(t, http.StatusOK, serve(h, http.MethodDelete, "/loglevel/internal/outbox", "s3cret", "").Code)
+	assert.Empty(t, levels.Packages())
+
+	assert.Equal(t, http.StatusBadRequest, serve(h, http.MethodPut, "/loglevel", "s3cret", `{"level":"loud"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(h, http.MethodPut, "/loglevel", "s3cret", `level`).Code)
+	assert.Equal(t, slog.LevelWarn, levels.Level())
+}
@@ -0,0 +1,71 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cupv/mux/pkg/trace"
+)
+
+// ErrClosed is returned when a broker is used after Close
+var ErrClosed = errors.New("broker closed")
+
+// ErrUnavailable is returned when a publish fails because the broker's backend cannot be reached
+var ErrUnavailable = errors.New("broker unavailable")
+
+// ErrQueueFull is returned when the in-process broker cannot queue a message without waiting
+var ErrQueueFull = errors.New("broker queue full")
+
+// Message is a payload delivered on a topic
+type Message struct {
+	Topic   string
+	Payload []byte
+	// SpanContext is the trace the message was published in, handlers continue it from Context
+	SpanContext trace.SpanContext
+}
+
+// Context returns a context in the trace of the message, for logging and for work continuing it
+func (m Message) Context() context.Context {
+	return trace.ContextWithRemote(context.Background(), m.SpanContext)
+}
+
+// Handler receives the messages published on a subscribed topic
+type Handler func(msg Message)
+
+// Status describes the connection between a broker and its backend
+type Status struct {
+	Connected  bool      `json:"connected"`
+	Since      time.Time `json:"since"`
+	LastError  string    `json:"last_error,omitempty"`
+	Reconnects int64     `json:"reconnects"`
+}
+
+// Broker moves messages between instances of a service
+type Broker interface {
+	// Publish sends a payload to every subscriber of a topic
+	Publish(ctx context.Context, topic string, payload []byte) error
+	// Subscribe delivers every later message on a topic to handler, replacing any previous handler
+	Subscribe(ctx context.Context, topic string, handler Handler) error
+	// Unsubscribe stops delivering messages on a topic
+	Unsubscribe(ctx context.Context, topic string) error
+	// Close stops all subscriptions and releases the broker's resources
+	Close() error
+	// Status reports whether the broker can currently reach its backend
+	Status() Status
+}
+
+// Check reports ErrUnavailable while b cannot reach its backend, for use as a health check
+func Check(b Broker) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		status := b.Status()
+		if status.Connected {
+			return nil
+		}
+		if status.LastError == "" {
+			return ErrUnavailable
+		}
+		return fmt.Errorf("%w since %s: %s", ErrUnavailable, status.Since.Format(time.RFC3339), status.LastError)
+	}
+}
@@ -0,0 +1,107 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Delays between attempts to reach Redis again after losing it
+const (
+	reconnectMin = 100 * time.Millisecond
+	reconnectMax = 30 * time.Second
+)
+
+// health tracks whether a broker's backend is reachable
+type health struct {
+	mutex  sync.Mutex
+	status Status
+}
+
+func newHealth(connected bool) *health {
+	return &health{status: Status{Connected: connected, Since: time.Now()}}
+}
+
+// up records that the backend answered, counting a reconnect when it had been lost
+func (h *health) up() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.status.Connected {
+		return
+	}
+	h.status.Connected = true
+	h.status.Since = time.Now()
+	h.status.Reconnects++
+}
+
+// down records that the backend was lost and why
+func (h *health) down(err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.status.LastError = err.Error()
+	if !h.status.Connected {
+		return
+	}
+	h.status.Connected = false
+	h.status.Since = time.Now()
+}
+
+func (h *health) snapshot() Status {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.status
+}
+
+// published updates the health from the result of a publish and turns connection failures into ErrUnavailable
+func (h *health) published(ctx context.Context, err error) error {
+	var reply redis.Error
+	switch {
+	case err == nil:
+		h.up()
+		return nil
+	case errors.As(err, &reply), ctx.Err() != nil:
+		// Redis answered, or the caller gave up, so the connection itself is fine
+		return err
+	default:
+		h.down(err)
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+}
+
+// backoff produces exponentially growing, jittered delays between reconnect attempts
+type backoff struct {
+	min, max time.Duration
+	attempt  int
+}
+
+// next returns the delay before the next attempt, somewhere between half and all of the current step
+func (b *backoff) next() time.Duration {
+	step := b.max
+	if b.attempt < 32 && b.min<<b.attempt < b.max {
+		step = b.min << b.attempt
+	}
+	b.attempt++
+	return step/2 + time.Duration(rand.Int63n(int64(step/2)+1))
+}
+
+// reset starts over from the shortest delay once a connection succeeds
+func (b *backoff) reset() {
+	b.attempt = 0
+}
+
+// wait sleeps for the next delay and reports false when ctx ends first
+func (b *backoff) wait(ctx context.Context) bool {
+	timer := time.NewTimer(b.next())
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
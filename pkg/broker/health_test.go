@@ -0,0 +1,30 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffGrowsUpToTheCapAndResets(t *testing.T) {
+	retry := backoff{min: 100 * time.Millisecond, max: time.Second}
+	for _, step := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		delay := retry.next()
+		step *= time.Millisecond
+		assert.GreaterOrEqual(t, delay, step/2)
+		assert.LessOrEqual(t, delay, step)
+	}
+
+	retry.reset()
+	assert.LessOrEqual(t, retry.next(), 100*time.Millisecond)
+}
+
+func TestCheckFailsWhileDisconnected(t *testing.T) {
+	b := NewMemory()
+	assert.NoError(t, Check(b)(context.Background()))
+
+	b.Close()
+	assert.ErrorIs(t, Check(b)(context.Background()), ErrUnavailable)
+}
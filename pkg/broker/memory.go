@@ -0,0 +1,123 @@
+package broker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cupv/mux/pkg/trace"
+)
+
+// memoryQueueSize is how many messages a subscription may have pending before Publish fails
+const memoryQueueSize = 1024
+
+// Memory is an in-process broker for single-node deployments and tests
+type Memory struct {
+	mutex         sync.RWMutex
+	subscriptions map[string]*memorySubscription
+	closed        bool
+}
+
+type memorySubscription struct {
+	queue chan Message
+	done  chan struct{}
+}
+
+// NewMemory creates an in-process broker
+func NewMemory() *Memory {
+	return &Memory{
+		subscriptions: make(map[string]*memorySubscription),
+	}
+}
+
+func (b *Memory) Publish(ctx context.Context, topic string, payload []byte) error {
+	ctx, done := publishing(ctx, "memory", topic)
+	return done(b.publish(ctx, topic, payload))
+}
+
+// publish never waits: handlers publish to their own topics, so a full queue that blocked the
+// publisher could never drain
+func (b *Memory) publish(ctx context.Context, topic string, payload []byte) error {
+	b.mutex.RLock()
+	closed := b.closed
+	sub, ok := b.subscriptions[topic]
+	b.mutex.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	if !ok {
+		return nil
+	}
+
+	select {
+	case sub.queue <- Message{Topic: topic, Payload: payload, SpanContext: trace.SpanContextFromContext(ctx)}:
+		return nil
+	case <-sub.done:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (b *Memory) Subscribe(ctx context.Context, topic string, handler Handler) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+
+	if previous, ok := b.subscriptions[topic]; ok {
+		close(previous.done)
+	}
+	sub := &memorySubscription{
+		queue: make(chan Message, memoryQueueSize),
+		done:  make(chan struct{}),
+	}
+	b.subscriptions[topic] = sub
+	go sub.run(handler)
+	return nil
+}
+
+func (b *Memory) Unsubscribe(ctx context.Context, topic string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if sub, ok := b.subscriptions[topic]; ok {
+		close(sub.done)
+		delete(b.subscriptions, topic)
+	}
+	return nil
+}
+
+func (b *Memory) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return nil
+	}
+
+	b.closed = true
+	for topic, sub := range b.subscriptions {
+		close(sub.done)
+		delete(b.subscriptions, topic)
+	}
+	return nil
+}
+
+// run hands queued messages to the handler in publish order until the subscription ends
+func (sub *memorySubscription) run(handler Handler) {
+	for {
+		select {
+		case <-sub.done:
+			return
+		case msg := <-sub.queue:
+			deliver("memory", handler, msg)
+		}
+	}
+}
+
+// Status reports the in-process broker as connected until it is closed
+func (b *Memory) Status() Status {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return Status{Connected: !b.closed}
+}
@@ -0,0 +1,104 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryDeliversInOrder(t *testing.T) {
+	b := NewMemory()
+	defer b.Close()
+	ctx := context.Background()
+
+	received := make(chan string, 10)
+	require.NoError(t, b.Subscribe(ctx, "messages", func(msg Message) {
+		received <- string(msg.Payload)
+	}))
+
+	for _, payload := range []string{"a", "b", "c"} {
+		require.NoError(t, b.Publish(ctx, "messages", []byte(payload)))
+	}
+	require.NoError(t, b.Publish(ctx, "other", []byte("ignored")))
+
+	for _, want := range []string{"a", "b", "c"} {
+		select {
+		case got := <-received:
+			assert.Equal(t, want, got)
+		case <-time.After(time.Second):
+			t.Fatal("message not delivered")
+		}
+	}
+}
+
+func TestMemoryUnsubscribeAndClose(t *testing.T) {
+	b := NewMemory()
+	ctx := context.Background()
+
+	received := make(chan string, 10)
+	require.NoError(t, b.Subscribe(ctx, "messages", func(msg Message) {
+		received <- string(msg.Payload)
+	}))
+	require.NoError(t, b.Unsubscribe(ctx, "messages"))
+	require.NoError(t, b.Publish(ctx, "messages", []byte("lost")))
+
+	select {
+	case got := <-received:
+		t.Fatalf("unexpected delivery after unsubscribe: %s", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, b.Close())
+	assert.ErrorIs(t, b.Publish(ctx, "messages", []byte("late")), ErrClosed)
+	assert.ErrorIs(t, b.Subscribe(ctx, "messages", func(Message) {}), ErrClosed)
+}
+
+func TestMemoryHandlerPublishingToItsOwnFullTopic(t *testing.T) {
+	b := NewMemory()
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	gate := make(chan struct{})
+	echoed := make(chan error, 1)
+	var calls int
+	require.NoError(t, b.Subscribe(ctx, "loop", func(msg Message) {
+		calls++
+		if calls > 1 {
+			return
+		}
+		close(started)
+		<-gate
+		echoed <- b.Publish(ctx, "loop", []byte("echo"))
+	}))
+
+	require.NoError(t, b.Publish(ctx, "loop", []byte("first")))
+	<-started
+	for i := 0; i < memoryQueueSize; i++ {
+		require.NoError(t, b.Publish(ctx, "loop", []byte("fill")))
+	}
+	assert.ErrorIs(t, b.Publish(ctx, "loop", []byte("overflow")), ErrQueueFull)
+
+	// The handler is the queue's only reader, so its publish fails instead of waiting for itself
+	close(gate)
+	select {
+	case err := <-echoed:
+		assert.ErrorIs(t, err, ErrQueueFull)
+	case <-time.After(time.Second):
+		t.Fatal("publish from the handler blocked")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, b.Subscribe(ctx, "other", func(Message) {}))
+		assert.NoError(t, b.Close())
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Subscribe and Close deadlocked against a publisher")
+	}
+}
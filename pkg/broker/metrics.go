@@ -0,0 +1,30 @@
+package broker
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cupv/mux/pkg/metrics"
+)
+
+// publishErrors counts failed publishes, exposed on /metrics
+var publishErrors = metrics.Default.NewCounterVec("broker_publish_errors_total",
+	"Broker publishes that failed, by broker and reason.", "broker", "reason")
+
+// countPublish records a failed publish and returns err unchanged
+func countPublish(broker string, err error) error {
+	if err == nil {
+		return nil
+	}
+	reason := "error"
+	switch {
+	case errors.Is(err, ErrClosed):
+		reason = "closed"
+	case errors.Is(err, ErrUnavailable):
+		reason = "unavailable"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		reason = "canceled"
+	}
+	publishErrors.With(broker, reason).Inc()
+	return err
+}
@@ -0,0 +1,209 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// pubsubPingInterval is how long the subscription may stay silent before Redis is pinged to check on it
+const pubsubPingInterval = 30 * time.Second
+
+// RedisPubSub is a broker backed by Redis Pub/Sub, messages published while an instance is away are lost.
+// A supervisor keeps the subscription alive, reconnecting with backoff and resubscribing every topic after Redis comes back.
+type RedisPubSub struct {
+	client   *redis.Client
+	mutex    sync.RWMutex
+	pubsub   *redis.PubSub // nil while disconnected
+	handlers map[string]Handler
+	health   *health
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewRedisPubSub creates a broker on top of an existing Redis client, the caller keeps ownership of the client
+func NewRedisPubSub(client *redis.Client) *RedisPubSub {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &RedisPubSub{
+		client:   client,
+		handlers: make(map[string]Handler),
+		health:   newHealth(true),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go b.supervise()
+	return b
+}
+
+// Publish fails fast with ErrUnavailable while the supervisor is reconnecting, rather than waiting on a dial timeout
+func (b *RedisPubSub) Publish(ctx context.Context, topic string, payload []byte) error {
+	ctx, done := publishing(ctx, "redis", topic)
+	return done(b.publish(ctx, topic, payload))
+}
+
+func (b *RedisPubSub) publish(ctx context.Context, topic string, payload []byte) error {
+	if b.ctx.Err() != nil {
+		return ErrClosed
+	}
+	if status := b.health.snapshot(); !status.Connected {
+		return fmt.Errorf("%w: %s", ErrUnavailable, status.LastError)
+	}
+	err := b.health.published(ctx, b.client.Publish(ctx, topic, withTraceparent(ctx, payload)).Err())
+	if errors.Is(err, ErrUnavailable) {
+		b.probe()
+	}
+	return err
+}
+
+// probe pings Redis over the subscription after a publish failed, so a healthy connection
+// marks the broker up again as soon as the pong arrives instead of after pubsubPingInterval
+func (b *RedisPubSub) probe() {
+	b.mutex.RLock()
+	pubsub := b.pubsub
+	b.mutex.RUnlock()
+	if pubsub == nil {
+		return
+	}
+	// A failed ping also fails the pending receive, which the supervisor handles
+	go pubsub.Ping(b.ctx)
+}
+
+// Subscribe registers handler right away, while Redis is down the topic is subscribed once it is reached again
+func (b *RedisPubSub) Subscribe(ctx context.Context, topic string, handler Handler) error {
+	if b.ctx.Err() != nil {
+		return ErrClosed
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.handlers[topic] = handler
+	if b.pubsub == nil {
+		return nil
+	}
+	return b.pubsub.Subscribe(ctx, topic)
+}
+
+func (b *RedisPubSub) Unsubscribe(ctx context.Context, topic string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.handlers, topic)
+	if b.pubsub == nil {
+		return nil
+	}
+	return b.pubsub.Unsubscribe(ctx, topic)
+}
+
+func (b *RedisPubSub) Close() error {
+	b.cancel()
+	b.mutex.Lock()
+	if b.pubsub != nil {
+		// Unblocks a pending receive
+		b.pubsub.Close()
+	}
+	b.mutex.Unlock()
+	<-b.done
+	return nil
+}
+
+func (b *RedisPubSub) Status() Status {
+	return b.health.snapshot()
+}
+
+// supervise keeps a subscription running until Close, waiting longer after every failed attempt
+func (b *RedisPubSub) supervise() {
+	defer close(b.done)
+
+	retry := backoff{min: reconnectMin, max: reconnectMax}
+	for {
+		err := b.listen(&retry)
+		if b.ctx.Err() != nil {
+			return
+		}
+		b.health.down(err)
+		slog.Error("Redis pub/sub connection lost", "error", err)
+		if !retry.wait(b.ctx) {
+			return
+		}
+	}
+}
+
+// listen subscribes to every registered topic on a fresh connection and dispatches messages until it fails
+func (b *RedisPubSub) listen(retry *backoff) error {
+	pubsub := b.client.Subscribe(b.ctx)
+	defer b.detach(pubsub)
+
+	b.mutex.Lock()
+	topics := make([]string, 0, len(b.handlers))
+	for topic := range b.handlers {
+		topics = append(topics, topic)
+	}
+	b.pubsub = pubsub
+	b.mutex.Unlock()
+
+	if len(topics) > 0 {
+		if err := pubsub.Subscribe(b.ctx, topics...); err != nil {
+			return err
+		}
+	}
+	if err := pubsub.Ping(b.ctx); err != nil {
+		return err
+	}
+
+	awaitingPong := false
+	for {
+		received, err := pubsub.ReceiveTimeout(b.ctx, pubsubPingInterval)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			// A connection that stays silent after a ping is gone even if it was never closed
+			if awaitingPong {
+				return err
+			}
+			if err := pubsub.Ping(b.ctx); err != nil {
+				return err
+			}
+			awaitingPong = true
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		awaitingPong = false
+		if !b.health.snapshot().Connected {
+			b.health.up()
+			retry.reset()
+		}
+		if msg, ok := received.(*redis.Message); ok {
+			b.dispatch(msg)
+		}
+	}
+}
+
+// detach forgets a subscription that stopped working and closes it
+func (b *RedisPubSub) detach(pubsub *redis.PubSub) {
+	b.mutex.Lock()
+	if b.pubsub == pubsub {
+		b.pubsub = nil
+	}
+	b.mutex.Unlock()
+	pubsub.Close()
+}
+
+// dispatch routes a received message to the handler of its channel
+func (b *RedisPubSub) dispatch(msg *redis.Message) {
+	b.mutex.RLock()
+	handler, ok := b.handlers[msg.Channel]
+	b.mutex.RUnlock()
+	if ok {
+		sc, payload := splitTraceparent(msg.Payload)
+		deliver("redis", handler, Message{Topic: msg.Channel, Payload: payload, SpanContext: sc})
+	}
+}
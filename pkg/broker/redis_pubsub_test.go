@@ -0,0 +1,206 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis speaks just enough RESP for Pub/Sub: PING, SUBSCRIBE, UNSUBSCRIBE and PUBLISH
+type fakeRedis struct {
+	listener    net.Listener
+	mutex       sync.Mutex
+	conns       map[net.Conn]map[string]bool
+	dropPublish bool // closes the connection of the next PUBLISH instead of answering it
+}
+
+func startFakeRedis(t *testing.T, addr string) *fakeRedis {
+	listener, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	f := &fakeRedis{listener: listener, conns: make(map[net.Conn]map[string]bool)}
+	go f.accept()
+	t.Cleanup(f.stop)
+	return f
+}
+
+func (f *fakeRedis) accept() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.mutex.Lock()
+		f.conns[conn] = make(map[string]bool)
+		f.mutex.Unlock()
+		go f.serve(conn)
+	}
+}
+
+// stop closes the listener and every connection, like a Redis restart
+func (f *fakeRedis) stop() {
+	f.listener.Close()
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for conn := range f.conns {
+		conn.Close()
+		delete(f.conns, conn)
+	}
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		f.mutex.Lock()
+		channels := f.conns[conn]
+		switch strings.ToLower(args[0]) {
+		case "ping":
+			if len(channels) > 0 {
+				fmt.Fprint(conn, "*2\r\n$4\r\npong\r\n$0\r\n\r\n")
+			} else {
+				fmt.Fprint(conn, "+PONG\r\n")
+			}
+		case "subscribe", "unsubscribe":
+			for _, channel := range args[1:] {
+				channels[channel] = args[0] == "subscribe"
+				if !channels[channel] {
+					delete(channels, channel)
+				}
+				fmt.Fprintf(conn, "*3\r\n%s%s:%d\r\n", bulk(strings.ToLower(args[0])), bulk(channel), len(channels))
+			}
+		case "publish":
+			if f.dropPublish {
+				f.dropPublish = false
+				conn.Close()
+				delete(f.conns, conn)
+				f.mutex.Unlock()
+				return
+			}
+			receivers := 0
+			for other, subscribed := range f.conns {
+				if subscribed[args[1]] {
+					fmt.Fprintf(other, "*3\r\n%s%s%s", bulk("message"), bulk(args[1]), bulk(args[2]))
+					receivers++
+				}
+			}
+			fmt.Fprintf(conn, ":%d\r\n", receivers)
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		f.mutex.Unlock()
+	}
+}
+
+// subscribed reports whether any connection is subscribed to a channel
+func (f *fakeRedis) subscribed(channel string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, channels := range f.conns {
+		if channels[channel] {
+			return true
+		}
+	}
+	return false
+}
+
+func bulk(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+// readCommand reads one RESP array of bulk strings
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err := reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+func TestRedisPubSubResubscribesAfterRestart(t *testing.T) {
+	server := startFakeRedis(t, "127.0.0.1:0")
+	addr := server.listener.Addr().String()
+	client := redis.NewClient(&redis.Options{Addr: addr, MaxRetries: -1})
+	defer client.Close()
+
+	b := NewRedisPubSub(client)
+	defer b.Close()
+	ctx := context.Background()
+
+	received := make(chan string, 10)
+	require.NoError(t, b.Subscribe(ctx, "messages", func(msg Message) {
+		received <- string(msg.Payload)
+	}))
+	require.Eventually(t, func() bool { return server.subscribed("messages") }, time.Second, 5*time.Millisecond)
+
+	server.stop()
+	require.Eventually(t, func() bool { return !b.Status().Connected }, time.Second, 5*time.Millisecond)
+	assert.ErrorIs(t, b.Publish(ctx, "messages", []byte("lost")), ErrUnavailable)
+
+	// Redis comes back on the same address and the topic is subscribed again
+	server = startFakeRedis(t, addr)
+	require.Eventually(t, func() bool { return server.subscribed("messages") }, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool { return b.Status().Connected }, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, b.Publish(ctx, "messages", []byte("back")))
+	select {
+	case got := <-received:
+		assert.Equal(t, "back", got)
+	case <-time.After(time.Second):
+		t.Fatal("message not delivered after reconnecting")
+	}
+
+	status := b.Status()
+	assert.Equal(t, int64(1), status.Reconnects)
+	assert.NotEmpty(t, status.LastError)
+}
+
+func TestRedisPubSubRecoversRightAfterAFailedPublish(t *testing.T) {
+	server := startFakeRedis(t, "127.0.0.1:0")
+	client := redis.NewClient(&redis.Options{Addr: server.listener.Addr().String(), MaxRetries: -1})
+	defer client.Close()
+
+	b := NewRedisPubSub(client)
+	defer b.Close()
+	ctx := context.Background()
+
+	require.NoError(t, b.Subscribe(ctx, "messages", func(Message) {}))
+	require.Eventually(t, func() bool { return server.subscribed("messages") }, time.Second, 5*time.Millisecond)
+
+	server.mutex.Lock()
+	server.dropPublish = true
+	server.mutex.Unlock()
+	assert.ErrorIs(t, b.Publish(ctx, "messages", []byte("dropped")), ErrUnavailable)
+
+	// The subscription still answers its ping, so the broker is back long before the ping interval
+	require.Eventually(t, func() bool { return b.Status().Connected }, time.Second, 5*time.Millisecond)
+	assert.NoError(t, b.Publish(ctx, "messages", []byte("next")))
+}
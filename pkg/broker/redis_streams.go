@@ -0,0 +1,137 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/cupv/mux/pkg/trace"
+	"github.com/go-redis/redis/v8"
+)
+
+// streamBlock is how long a single XREAD waits for new entries
+const streamBlock = 5 * time.Second
+
+// RedisStreams is a broker backed by Redis Streams, each topic is a capped stream.
+// Readers that lose Redis retry with backoff and resume after the last entry they saw.
+type RedisStreams struct {
+	client  *redis.Client
+	maxLen  int64
+	health  *health
+	mutex   sync.Mutex
+	readers map[string]context.CancelFunc
+	wg      sync.WaitGroup
+	closed  bool
+}
+
+// NewRedisStreams creates a broker that keeps roughly the last maxLen entries of every topic
+func NewRedisStreams(client *redis.Client, maxLen int64) *RedisStreams {
+	return &RedisStreams{
+		client:  client,
+		maxLen:  maxLen,
+		health:  newHealth(true),
+		readers: make(map[string]context.CancelFunc),
+	}
+}
+
+// streamKey is the Redis key holding a topic's stream
+func streamKey(topic string) string {
+	return "stream:" + topic
+}
+
+func (b *RedisStreams) Publish(ctx context.Context, topic string, payload []byte) error {
+	ctx, done := publishing(ctx, "streams", topic)
+	values := map[string]interface{}{"payload": payload}
+	if header := trace.SpanContextFromContext(ctx).Traceparent(); header != "" {
+		values["traceparent"] = header
+	}
+	err := b.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: streamKey(topic),
+		MaxLen: b.maxLen,
+		Approx: true,
+		Values: values,
+	}).Err()
+	return done(b.health.published(ctx, err))
+}
+
+func (b *RedisStreams) Subscribe(ctx context.Context, topic string, handler Handler) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+
+	if cancel, ok := b.readers[topic]; ok {
+		cancel()
+	}
+	readCtx, cancel := context.WithCancel(context.Background())
+	b.readers[topic] = cancel
+
+	b.wg.Add(1)
+	go b.read(readCtx, topic, handler)
+	return nil
+}
+
+func (b *RedisStreams) Unsubscribe(ctx context.Context, topic string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if cancel, ok := b.readers[topic]; ok {
+		cancel()
+		delete(b.readers, topic)
+	}
+	return nil
+}
+
+func (b *RedisStreams) Close() error {
+	b.mutex.Lock()
+	b.closed = true
+	for topic, cancel := range b.readers {
+		cancel()
+		delete(b.readers, topic)
+	}
+	b.mutex.Unlock()
+
+	b.wg.Wait()
+	return nil
+}
+
+func (b *RedisStreams) Status() Status {
+	return b.health.snapshot()
+}
+
+// read follows a topic's stream from its current end and hands every new entry to handler
+func (b *RedisStreams) read(ctx context.Context, topic string, handler Handler) {
+	defer b.wg.Done()
+
+	lastID := "$"
+	retry := backoff{min: reconnectMin, max: reconnectMax}
+	for ctx.Err() == nil {
+		streams, err := b.client.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{streamKey(topic), lastID},
+			Block:   streamBlock,
+		}).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			if ctx.Err() == nil {
+				b.health.down(err)
+				slog.ErrorContext(ctx, "Redis stream read error", "topic", topic, "error", err)
+				retry.wait(ctx)
+			}
+			continue
+		}
+		b.health.up()
+		retry.reset()
+
+		for _, stream := range streams {
+			for _, entry := range stream.Messages {
+				lastID = entry.ID
+				payload, _ := entry.Values["payload"].(string)
+				header, _ := entry.Values["traceparent"].(string)
+				sc, _ := trace.ParseTraceparent(header)
+				deliver("streams", handler, Message{Topic: topic, Payload: []byte(payload), SpanContext: sc})
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package broker
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/cupv/mux/pkg/trace"
+)
+
+// publishing starts a producer span for a publish, the returned function ends it and counts failures
+func publishing(ctx context.Context, broker, topic string) (context.Context, func(error) error) {
+	ctx, span := trace.Start(ctx, "publish", trace.Producer,
+		messagingSystem(broker),
+		slog.String("messaging.destination.name", topic))
+	return ctx, func(err error) error {
+		span.Finish(err)
+		return countPublish(broker, err)
+	}
+}
+
+// deliver hands a message to its handler within a consumer span continuing the publisher's trace.
+// The handler gets the consumer span's context in msg.SpanContext.
+func deliver(broker string, handler Handler, msg Message) {
+	_, span := trace.Start(msg.Context(), "receive", trace.Consumer,
+		messagingSystem(broker),
+		slog.String("messaging.destination.name", msg.Topic))
+	defer span.End()
+	msg.SpanContext = span.SpanContext()
+	handler(msg)
+}
+
+// messagingSystem is the span attribute naming the backend of a broker
+func messagingSystem(broker string) slog.Attr {
+	if broker == "memory" {
+		return slog.String("messaging.system", "memory")
+	}
+	return slog.String("messaging.system", "redis")
+}
+
+// tracePrefix marks a pub/sub payload that starts with a traceparent, Redis pub/sub messages have no headers
+const tracePrefix = "\x00traceparent:"
+
+// traceparentLen is the length of a version 00 traceparent
+const traceparentLen = 55
+
+// withTraceparent prefixes payload with the traceparent of the span in ctx, when there is one
+func withTraceparent(ctx context.Context, payload []byte) []byte {
+	header := trace.SpanContextFromContext(ctx).Traceparent()
+	if header == "" {
+		return payload
+	}
+	return append([]byte(tracePrefix+header), payload...)
+}
+
+// splitTraceparent undoes withTraceparent, payloads without a prefix are returned as they are
+func splitTraceparent(payload string) (trace.SpanContext, []byte) {
+	if !strings.HasPrefix(payload, tracePrefix) || len(payload) < len(tracePrefix)+traceparentLen {
+		return trace.SpanContext{}, []byte(payload)
+	}
+	rest := payload[len(tracePrefix):]
+	sc, err := trace.ParseTraceparent(rest[:traceparentLen])
+	if err != nil {
+		return trace.SpanContext{}, []byte(payload)
+	}
+	return sc, []byte(rest[traceparentLen:])
+}
@@ -0,0 +1,58 @@
+package broker
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/cupv/mux/pkg/trace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceparentPrefixRoundTrip(t *testing.T) {
+	sc, err := trace.ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	require.NoError(t, err)
+	ctx := trace.ContextWithRemote(context.Background(), sc)
+
+	got, payload := splitTraceparent(string(withTraceparent(ctx, []byte(`{"a":1}`))))
+	assert.Equal(t, sc, got)
+	assert.Equal(t, `{"a":1}`, string(payload))
+
+	// Payloads of publishers without a trace, or older ones, are left alone
+	assert.Equal(t, "plain", string(withTraceparent(context.Background(), []byte("plain"))))
+	got, payload = splitTraceparent("plain")
+	assert.False(t, got.IsValid())
+	assert.Equal(t, "plain", string(payload))
+	got, payload = splitTraceparent(tracePrefix + "short")
+	assert.False(t, got.IsValid())
+	assert.Equal(t, tracePrefix+"short", string(payload))
+}
+
+func TestConsumersContinueThePublishersTrace(t *testing.T) {
+	tracer := trace.NewTracer(trace.Options{Service: "test", SampleRatio: 1, Exporter: trace.NewWriter(io.Discard)})
+	trace.SetDefault(tracer)
+	t.Cleanup(func() {
+		trace.SetDefault(nil)
+		tracer.Shutdown(context.Background())
+	})
+
+	b := NewMemory()
+	defer b.Close()
+	received := make(chan Message, 1)
+	require.NoError(t, b.Subscribe(context.Background(), "cards", func(msg Message) { received <- msg }))
+
+	ctx, span := trace.Start(context.Background(), "request", trace.Server)
+	require.NoError(t, b.Publish(ctx, "cards", []byte("hola")))
+	span.End()
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, "hola", string(msg.Payload))
+		assert.Equal(t, span.SpanContext().TraceID, msg.SpanContext.TraceID)
+		assert.NotEqual(t, span.SpanContext().SpanID, msg.SpanContext.SpanID, "the consumer span is a new span")
+	case <-time.After(time.Second):
+		t.Fatal("message not delivered")
+	}
+}
@@ -0,0 +1,188 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Default cache lifetime and timeout of check results
+const (
+	DefaultTTL     = 2 * time.Second
+	DefaultTimeout = time.Second
+)
+
+// Check reports a problem with a dependency as an error
+type Check func(ctx context.Context) error
+
+// Kind tells what a check decides. Liveness checks say whether the process should be restarted,
+// so they must not depend on other services. Readiness checks say whether it should get traffic.
+type Kind int
+
+const (
+	Liveness Kind = iota
+	Readiness
+)
+
+// Status values of results and reports
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Result is the outcome of one check
+type Result struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Report is the outcome of every check of a kind
+type Report struct {
+	Status string `json:"status"`
+	// Reason explains a failure that is not down to a check, such as shutting down
+	Reason string            `json:"reason,omitempty"`
+	Checks map[string]Result `json:"checks"`
+}
+
+type check struct {
+	name string
+	kind Kind
+	fn   Check
+
+	// mutex makes concurrent probes share one run
+	mutex   sync.Mutex
+	result  Result
+	expires time.Time
+}
+
+// Registry runs the checks of a process. Results are cached for TTL, so probes from
+// many sources do not multiply the load on dependencies.
+type Registry struct {
+	// TTL is how long a result is reused, Timeout bounds each check
+	TTL     time.Duration
+	Timeout time.Duration
+
+	mutex  sync.Mutex
+	checks []*check
+	gate   func() bool
+}
+
+// NewRegistry creates a registry with the default TTL and timeout
+func NewRegistry() *Registry {
+	return &Registry{TTL: DefaultTTL, Timeout: DefaultTimeout}
+}
+
+// Add registers a check
+func (r *Registry) Add(kind Kind, name string, fn Check) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.checks = append(r.checks, &check{name: name, kind: kind, fn: fn})
+}
+
+// Gate makes readiness fail while ready returns false, e.g. before startup completes and once shutdown begins.
+// Unlike checks it is never cached, so readiness flips right away.
+func (r *Registry) Gate(ready func() bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.gate = ready
+}
+
+// Report runs the checks of a kind, in parallel. Readiness includes the liveness checks.
+func (r *Registry) Report(ctx context.Context, kind Kind) Report {
+	r.mutex.Lock()
+	checks := make([]*check, 0, len(r.checks))
+	for _, c := range r.checks {
+		if c.kind <= kind {
+			checks = append(checks, c)
+		}
+	}
+	gate := r.gate
+	r.mutex.Unlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *check) {
+			defer wg.Done()
+			results[i] = r.run(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+	for i, c := range checks {
+		report.Checks[c.name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	if kind == Readiness && gate != nil && !gate() {
+		report.Status = StatusFail
+		report.Reason = "not started or shutting down"
+	}
+	return report
+}
+
+// run returns the cached result of a check, or runs it when the result is stale
+func (r *Registry) run(ctx context.Context, c *check) Result {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if time.Now().Before(c.expires) {
+		return c.result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+	start := time.Now()
+	err := c.fn(ctx)
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	result := Result{Status: StatusOK, Duration: time.Since(start).String(), CheckedAt: start}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	c.result = result
+	c.expires = start.Add(r.TTL)
+	return result
+}
+
+// Livez answers 200 when the liveness checks pass and 503 otherwise, with the report as JSON
+func (r *Registry) Livez(w http.ResponseWriter, req *http.Request) {
+	r.serve(w, req, Liveness)
+}
+
+// Readyz answers 200 when the process is ready for traffic and 503 otherwise, with the report as JSON
+func (r *Registry) Readyz(w http.ResponseWriter, req *http.Request) {
+	r.serve(w, req, Readiness)
+}
+
+func (r *Registry) serve(w http.ResponseWriter, req *http.Request, kind Kind) {
+	report := r.Report(req.Context(), kind)
+	code := http.StatusOK
+	if report.Status != StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
+
+// Pinger is a dependency that can be pinged, like *sql.DB
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// Ping checks a dependency with its PingContext
+func Ping(p Pinger) Check {
+	return p.PingContext
+}
@@ -0,0 +1,99 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, handler http.HandlerFunc) (int, Report) {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var report Report
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+	return rec.Code, report
+}
+
+func TestReadinessIncludesLivenessAndFailsWithAnyCheck(t *testing.T) {
+	r := NewRegistry()
+	r.TTL = 0
+	var dbErr error
+	r.Add(Liveness, "process", func(ctx context.Context) error { return nil })
+	r.Add(Readiness, "db", func(ctx context.Context) error { return dbErr })
+
+	code, report := serve(t, r.Readyz)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Len(t, report.Checks, 2)
+
+	dbErr = errors.New("connection refused")
+	code, report = serve(t, r.Readyz)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, "connection refused", report.Checks["db"].Error)
+	assert.Equal(t, StatusOK, report.Checks["process"].Status)
+
+	// A failing dependency does not make the process unhealthy
+	code, report = serve(t, r.Livez)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, report.Checks, 1)
+}
+
+func TestResultsAreCached(t *testing.T) {
+	r := NewRegistry()
+	r.TTL = time.Hour
+	var runs atomic.Int32
+	r.Add(Readiness, "db", func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	})
+
+	first := r.Report(context.Background(), Readiness)
+	second := r.Report(context.Background(), Readiness)
+	assert.Equal(t, int32(1), runs.Load())
+	assert.Equal(t, first.Checks["db"].CheckedAt, second.Checks["db"].CheckedAt)
+}
+
+func TestChecksTimeOut(t *testing.T) {
+	r := NewRegistry()
+	r.Timeout = 10 * time.Millisecond
+	r.Add(Readiness, "slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+
+	report := r.Report(context.Background(), Readiness)
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["slow"].Error)
+}
+
+func TestGateFlipsReadinessRightAway(t *testing.T) {
+	r := NewRegistry()
+	r.TTL = time.Hour
+	r.Add(Readiness, "db", func(ctx context.Context) error { return nil })
+	var ready atomic.Bool
+	ready.Store(true)
+	r.Gate(ready.Load)
+
+	code, _ := serve(t, r.Readyz)
+	assert.Equal(t, http.StatusOK, code)
+
+	ready.Store(false)
+	code, report := serve(t, r.Readyz)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.NotEmpty(t, report.Reason)
+	assert.Equal(t, StatusOK, report.Checks["db"].Status)
+
+	// Liveness ignores the gate
+	code, _ = serve(t, r.Livez)
+	assert.Equal(t, http.StatusOK, code)
+}
@@ -0,0 +1,240 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Default timeouts for components that do not set their own
+const (
+	DefaultStartTimeout = 30 * time.Second
+	DefaultStopTimeout  = 10 * time.Second
+)
+
+// Component is a part of an application: a server, a connection pool, a background worker.
+// Every function is optional.
+type Component struct {
+	Name string
+	// Start brings the component up and returns once it is ready, the next component only starts then
+	Start func(ctx context.Context) error
+	// Run does the component's work in the background until ctx is done, an error stops the application
+	Run func(ctx context.Context) error
+	// Stop shuts the component down once its Run has returned
+	Stop func(ctx context.Context) error
+
+	// StartTimeout and StopTimeout bound Start and Stop, including waiting for Run to return
+	StartTimeout time.Duration
+	StopTimeout  time.Duration
+}
+
+// App starts its components in the order they were added and stops them in reverse order,
+// on SIGINT or SIGTERM, when the context given to Run is done, or when a component fails.
+type App struct {
+	logger     *slog.Logger
+	components []Component
+	ready      atomic.Bool
+	// Signals stop the application, SIGINT and SIGTERM by default
+	Signals []os.Signal
+}
+
+// New creates an application that logs its lifecycle to logger
+func New(logger *slog.Logger) *App {
+	return &App{logger: logger, Signals: []os.Signal{syscall.SIGINT, syscall.SIGTERM}}
+}
+
+// Add appends components, they start after the ones added before
+func (a *App) Add(components ...Component) {
+	a.components = append(a.components, components...)
+}
+
+// Ready reports whether every component has started and the application is not stopping
+func (a *App) Ready() bool {
+	return a.ready.Load()
+}
+
+// Error lists everything that went wrong while running an application
+type Error struct {
+	Errors []error
+}
+
+func (e *Error) Error() string {
+	return errors.Join(e.Errors...).Error()
+}
+
+func (e *Error) Unwrap() []error {
+	return e.Errors
+}
+
+// Errors Run wraps failures in, so callers can tell which phase failed
+var (
+	// ErrStartup marks a component that failed to start
+	ErrStartup = errors.New("start")
+	// ErrStopTimeout marks a component whose Run or Stop outlasted its stop timeout
+	ErrStopTimeout = errors.New("timed out")
+)
+
+// Process exit codes, by what went wrong
+const (
+	ExitOK = 0
+	// ExitFailure is for a component that failed while running or stopping
+	ExitFailure = 1
+	// ExitConfig is for invalid settings, found before Run so the caller returns it directly
+	ExitConfig = 2
+	// ExitStartup is for an application that never became ready
+	ExitStartup = 3
+	// ExitStopTimeout is for an application that ran fine but did not stop in time
+	ExitStopTimeout = 4
+)
+
+// ExitCode is the process exit code for the result of Run. A failed start wins over everything that followed it,
+// and a stop timeout only gets its own code when nothing else went wrong.
+func ExitCode(err error) int {
+	var appErr *Error
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, ErrStartup):
+		return ExitStartup
+	case errors.As(err, &appErr) && appErr.onlyStopTimeouts():
+		return ExitStopTimeout
+	default:
+		return ExitFailure
+	}
+}
+
+// onlyStopTimeouts reports whether every failure was a component stopping too slowly
+func (e *Error) onlyStopTimeouts() bool {
+	for _, err := range e.Errors {
+		if !errors.Is(err, ErrStopTimeout) {
+			return false
+		}
+	}
+	return len(e.Errors) > 0
+}
+
+// running is a started component
+type running struct {
+	Component
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Run starts the components, waits for a reason to stop, then stops the started components in reverse order.
+// A component failing to start stops the ones before it. The errors of every phase are returned together.
+func (a *App) Run(ctx context.Context) error {
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, a.Signals...)
+	defer signal.Stop(shutdown)
+
+	var errs []error
+	failed := make(chan error, len(a.components))
+	started := make([]*running, 0, len(a.components))
+	for _, c := range a.components {
+		r, err := a.start(c, failed)
+		if err != nil {
+			a.logger.Error("Component failed to start", "component", c.Name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w: %w", c.Name, ErrStartup, err))
+			break
+		}
+		started = append(started, r)
+	}
+	closeUnclaimed(a.logger)
+
+	if len(errs) == 0 {
+		a.ready.Store(true)
+		a.logger.Info("Application started")
+		// A process started by an upgrade only takes over once it is ready
+		if err := takeOver(a.logger); err != nil {
+			a.logger.Error("Failed to stop the previous process", "error", err)
+		}
+		select {
+		case sig := <-shutdown:
+			a.logger.Info("Shutdown signal received, initiating graceful shutdown", "signal", sig.String())
+		case <-ctx.Done():
+			a.logger.Info("Shutting down", "reason", ctx.Err())
+		case err := <-failed:
+			errs = append(errs, err)
+		}
+		a.ready.Store(false)
+	}
+
+	for i := len(started) - 1; i >= 0; i-- {
+		if err := a.stop(started[i]); err != nil {
+			a.logger.Error("Component failed to stop", "component", started[i].Name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: stop: %w", started[i].Name, err))
+		}
+	}
+	// Failures of components that were stopping anyway are reported too
+	for len(failed) > 0 {
+		errs = append(errs, <-failed)
+	}
+
+	if len(errs) > 0 {
+		return &Error{Errors: errs}
+	}
+	a.logger.Info("Application stopped")
+	return nil
+}
+
+// start runs a component's Start and launches its Run, whose error is sent to failed
+func (a *App) start(c Component, failed chan<- error) (*running, error) {
+	a.logger.Info("Starting component", "component", c.Name)
+	if c.Start != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout(c.StartTimeout, DefaultStartTimeout))
+		err := c.Start(ctx)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &running{Component: c, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		if c.Run == nil {
+			return
+		}
+		if err := c.Run(ctx); err != nil && ctx.Err() == nil {
+			a.logger.Error("Component failed", "component", c.Name, "error", err)
+			failed <- fmt.Errorf("%s: %w", c.Name, err)
+		}
+	}()
+	return r, nil
+}
+
+// stop cancels a component's Run, waits for it to return and calls Stop, all within the stop timeout
+func (a *App) stop(r *running) error {
+	a.logger.Info("Stopping component", "component", r.Name)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout(r.StopTimeout, DefaultStopTimeout))
+	defer cancel()
+
+	r.cancel()
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return fmt.Errorf("%w waiting for it to return", ErrStopTimeout)
+	}
+	if r.Stop == nil {
+		return nil
+	}
+	err := r.Stop(ctx)
+	if err != nil && errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrStopTimeout, err)
+	}
+	return err
+}
+
+func timeout(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
@@ -0,0 +1,245 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockRunner struct {
+	mock.Mock
+}
+
+func (m *MockRunner) ListenAndServe() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockRunner) Shutdown(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
+var logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestServerGracefulShutdown(t *testing.T) {
+	mockRunner := new(MockRunner)
+	app := New(logger)
+	app.Add(HTTPServer("http", mockRunner, 0))
+
+	mockRunner.On("ListenAndServe").Return(http.ErrServerClosed).Once()
+	mockRunner.On("Shutdown", mock.Anything).Return(nil).Once()
+
+	go func() {
+		time.Sleep(1 * time.Second)
+		process, _ := os.FindProcess(os.Getpid())
+		process.Signal(syscall.SIGINT)
+	}()
+
+	exitCode := ExitCode(app.Run(context.Background()))
+	assert.Equal(t, 0, exitCode, "Expected graceful shutdown to return 0 exit code")
+	mockRunner.AssertExpectations(t)
+}
+
+func TestServerListenAndServeError(t *testing.T) {
+	mockRunner := new(MockRunner)
+	app := New(logger)
+	app.Add(HTTPServer("http", mockRunner, 0))
+
+	// Simulate server failing to start
+	mockRunner.On("ListenAndServe").Return(assert.AnError).Once()
+
+	err := app.Run(context.Background())
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Equal(t, 1, ExitCode(err), "Expected failure exit code 1")
+	mockRunner.AssertExpectations(t)
+}
+
+func TestNewServer(t *testing.T) {
+	handler := http.NewServeMux()
+	server := NewServer(":8080", handler)
+
+	assert.NotNil(t, server, "Server should not be nil")
+	assert.Equal(t, ":8080", server.Addr(), "Server address should match")
+}
+
+func TestServerShutdown(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	server := NewServer(ts.Listener.Addr().String(), ts.Config.Handler)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	assert.NoError(t, err, "Expected no error on shutdown")
+}
+
+func TestHTTPServerFailsStartOnTakenPort(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer taken.Close()
+
+	app := New(logger)
+	app.Add(HTTPServer("http", NewServer(taken.Addr().String(), http.NewServeMux()), 0))
+	err = app.Run(context.Background())
+	assert.ErrorContains(t, err, "http: start:")
+}
+
+func TestHTTPServerServesUntilStopped(t *testing.T) {
+	server := NewServer("127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	app := New(logger)
+	app.Add(HTTPServer("http", server, 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- app.Run(ctx) }()
+	require.Eventually(t, app.Ready, time.Second, 5*time.Millisecond)
+
+	resp, err := http.Get("http://" + server.Addr())
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+
+	cancel()
+	assert.NoError(t, <-done)
+	_, err = http.Get("http://" + server.Addr())
+	assert.Error(t, err)
+}
+
+// recorder notes the lifecycle events of components
+type recorder struct {
+	events chan string
+}
+
+func (r *recorder) component(name string, startErr error) Component {
+	return Component{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			r.events <- "start " + name
+			return startErr
+		},
+		Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			r.events <- "run " + name + " done"
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			r.events <- "stop " + name
+			return nil
+		},
+	}
+}
+
+func (r *recorder) all() []string {
+	close(r.events)
+	var events []string
+	for e := range r.events {
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestRunStartsInOrderAndStopsInReverse(t *testing.T) {
+	r := &recorder{events: make(chan string, 100)}
+	app := New(logger)
+	app.Add(r.component("db", nil), r.component("worker", nil))
+	assert.False(t, app.Ready())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- app.Run(ctx) }()
+	require.Eventually(t, app.Ready, time.Second, 5*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	assert.False(t, app.Ready())
+
+	assert.Equal(t, []string{
+		"start db", "start worker",
+		"run worker done", "stop worker",
+		"run db done", "stop db",
+	}, r.all())
+}
+
+func TestRunStopsStartedComponentsWhenStartFails(t *testing.T) {
+	r := &recorder{events: make(chan string, 100)}
+	app := New(logger)
+	app.Add(r.component("db", nil), r.component("broker", assert.AnError), r.component("http", nil))
+
+	err := app.Run(context.Background())
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.ErrorContains(t, err, "broker: start:")
+	assert.Equal(t, []string{"start db", "start broker", "run db done", "stop db"}, r.all())
+}
+
+func TestRunAggregatesErrors(t *testing.T) {
+	workerErr := errors.New("worker crashed")
+	stopErr := errors.New("flush failed")
+	app := New(logger)
+	app.Add(
+		Component{Name: "db", Stop: func(ctx context.Context) error { return stopErr }},
+		Component{Name: "stuck", Run: func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		}, StopTimeout: 10 * time.Millisecond},
+		Component{Name: "worker", Run: func(ctx context.Context) error { return workerErr }},
+	)
+
+	err := app.Run(context.Background())
+	var appErr *Error
+	require.ErrorAs(t, err, &appErr)
+	assert.Len(t, appErr.Errors, 3)
+	assert.ErrorIs(t, err, workerErr)
+	assert.ErrorIs(t, err, stopErr)
+	assert.ErrorContains(t, err, "stuck: stop: timed out")
+	assert.Equal(t, 1, ExitCode(err))
+}
+
+func TestExitCodeTellsFailuresApart(t *testing.T) {
+	slow := Component{Name: "slow", Stop: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, StopTimeout: 10 * time.Millisecond}
+	stuck := Component{Name: "stuck", Run: func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}, StopTimeout: 10 * time.Millisecond}
+	broken := Component{Name: "broken", Start: func(ctx context.Context) error { return assert.AnError }}
+	crashed := Component{Name: "crashed", Run: func(ctx context.Context) error { return assert.AnError }}
+
+	for _, tc := range []struct {
+		name       string
+		components []Component
+		code       int
+	}{
+		{"clean stop", nil, ExitOK},
+		{"start failure", []Component{stuck, broken}, ExitStartup},
+		{"stop timeouts", []Component{slow, stuck}, ExitStopTimeout},
+		{"run failure", []Component{stuck, crashed}, ExitFailure},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app := New(logger)
+			app.Add(tc.components...)
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			assert.Equal(t, tc.code, ExitCode(app.Run(ctx)))
+		})
+	}
+	assert.Equal(t, ExitFailure, ExitCode(assert.AnError))
+}
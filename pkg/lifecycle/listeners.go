@@ -0,0 +1,114 @@
+package lifecycle
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Environment of the socket activation protocol, see sd_listen_fds(3)
+const (
+	listenFDsEnv   = "LISTEN_FDS"
+	listenPIDEnv   = "LISTEN_PID"
+	listenNamesEnv = "LISTEN_FDNAMES"
+	// listenFDsStart is the first inherited descriptor, after stdin, stdout and stderr
+	listenFDsStart = 3
+)
+
+// inherited holds the listening sockets passed to the process, each is taken by the server on its port
+var inherited struct {
+	once      sync.Once
+	mutex     sync.Mutex
+	listeners []net.Listener
+	err       error
+}
+
+// loadInherited reads the inherited listeners the first time it is called
+func loadInherited() error {
+	inherited.once.Do(func() {
+		inherited.listeners, inherited.err = listenersFromEnv()
+	})
+	return inherited.err
+}
+
+// takeInherited returns the inherited listener on the port of addr, or nil when there is none
+func takeInherited(addr string) (net.Listener, error) {
+	if err := loadInherited(); err != nil {
+		return nil, err
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "0" {
+		return nil, nil
+	}
+
+	inherited.mutex.Lock()
+	defer inherited.mutex.Unlock()
+	for i, l := range inherited.listeners {
+		if _, lport, err := net.SplitHostPort(l.Addr().String()); err == nil && lport == port {
+			inherited.listeners = append(inherited.listeners[:i], inherited.listeners[i+1:]...)
+			return l, nil
+		}
+	}
+	return nil, nil
+}
+
+// closeUnclaimed closes the inherited listeners no server took, once every server has started,
+// so sockets for ports this process no longer serves are not held open
+func closeUnclaimed(logger *slog.Logger) {
+	if loadInherited() != nil {
+		return
+	}
+	inherited.mutex.Lock()
+	defer inherited.mutex.Unlock()
+	for _, l := range inherited.listeners {
+		logger.Warn("Closing inherited listener no server uses", "addr", l.Addr().String())
+		l.Close()
+	}
+	inherited.listeners = nil
+}
+
+// listenersFromEnv turns the descriptors announced by LISTEN_FDS into listeners.
+// The variables are cleared so child processes do not see them.
+func listenersFromEnv() ([]net.Listener, error) {
+	n, ok, err := listenFDs(os.Getenv(listenFDsEnv), os.Getenv(listenPIDEnv), os.Getpid())
+	os.Unsetenv(listenFDsEnv)
+	os.Unsetenv(listenPIDEnv)
+	os.Unsetenv(listenNamesEnv)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	listeners := make([]net.Listener, 0, n)
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		file := os.NewFile(uintptr(fd), "listener-"+strconv.Itoa(fd))
+		l, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("inherited descriptor %d: %w", fd, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// listenFDs parses the socket activation variables, ok is false when they are not meant for this process.
+// LISTEN_PID is optional, a parent handing its sockets over on upgrade cannot know the child's pid in advance.
+func listenFDs(fds, pid string, self int) (n int, ok bool, err error) {
+	if fds == "" {
+		return 0, false, nil
+	}
+	if pid != "" && pid != strconv.Itoa(self) {
+		return 0, false, nil
+	}
+	n, err = strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return 0, false, fmt.Errorf("%s: invalid value %q", listenFDsEnv, fds)
+	}
+	return n, n > 0, nil
+}
@@ -0,0 +1,184 @@
+package lifecycle
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Runner defines the interface for running and shutting down a server
+type Runner interface {
+	ListenAndServe() error
+	Shutdown(ctx context.Context) error
+}
+
+// Listener is a Runner that can bind its address before serving, so a taken port fails startup
+type Listener interface {
+	Runner
+	Listen() error
+}
+
+// Server is a Runner for an http.Server
+type Server struct {
+	server   *http.Server
+	listener net.Listener
+
+	mutex sync.Mutex
+	// serving is closed when Serve returns, nil until it is called
+	serving chan struct{}
+	// fresh holds the connections that have not sent their first request yet
+	fresh map[net.Conn]struct{}
+}
+
+// freshGrace bounds how long Shutdown waits for accepted connections to send their first request
+const freshGrace = time.Second
+
+// NewServer creates a server for handler on addr
+func NewServer(addr string, handler http.Handler) *Server {
+	s := &Server{
+		server: &http.Server{
+			Addr:    addr,
+			Handler: handler,
+		},
+		fresh: make(map[net.Conn]struct{}),
+	}
+	s.server.ConnState = s.trackFresh
+	return s
+}
+
+func (s *Server) trackFresh(c net.Conn, state http.ConnState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if state == http.StateNew {
+		s.fresh[c] = struct{}{}
+	} else {
+		delete(s.fresh, c)
+	}
+}
+
+// Addr is the address the server listens on, the bound one once Listen has been called
+func (s *Server) Addr() string {
+	if s.listener != nil {
+		return s.listener.Addr().String()
+	}
+	return s.server.Addr
+}
+
+// Listen binds the server's address, or takes over a listening socket inherited on the same port
+// through systemd socket activation or an upgrade
+func (s *Server) Listen() error {
+	listener, err := takeInherited(s.server.Addr)
+	if err != nil {
+		return err
+	}
+	if listener != nil {
+		s.listener = listener
+		return nil
+	}
+
+	listener, err = net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	return nil
+}
+
+func (s *Server) ListenAndServe() error {
+	if s.listener == nil {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+	}
+
+	serving := make(chan struct{})
+	s.mutex.Lock()
+	s.serving = serving
+	s.mutex.Unlock()
+	defer close(serving)
+	return s.server.Serve(s.listener)
+}
+
+// Shutdown stops accepting and waits for in-flight requests.
+// http.Server.Shutdown drops requests that arrive after it starts, even on connections already accepted,
+// so the listener is closed first and those connections get a moment to send their request.
+// When the socket is shared with a process taking over, new connections go to that process meanwhile.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	serving := s.serving
+	s.mutex.Unlock()
+	if serving != nil {
+		s.listener.Close()
+		select {
+		case <-serving:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		s.waitFresh(ctx)
+	}
+	return s.server.Shutdown(ctx)
+}
+
+// waitFresh waits until every accepted connection has sent a request, for at most freshGrace
+func (s *Server) waitFresh(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(freshGrace)
+	for {
+		s.mutex.Lock()
+		n := len(s.fresh)
+		s.mutex.Unlock()
+		if n == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// HTTPServer is a component serving with runner. It is ready once its address is bound, when runner can bind
+// ahead of serving, and it is shut down gracefully, letting in-flight requests finish within the stop timeout.
+func HTTPServer(name string, runner Runner, stopTimeout time.Duration) Component {
+	var failed atomic.Bool
+	return Component{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			if l, ok := runner.(Listener); ok {
+				return l.Listen()
+			}
+			return nil
+		},
+		Run: func(ctx context.Context) error {
+			errChan := make(chan error, 1)
+			go func() {
+				errChan <- runner.ListenAndServe()
+			}()
+			select {
+			case err := <-errChan:
+				if err != nil && err != http.ErrServerClosed {
+					failed.Store(true)
+					return err
+				}
+				return nil
+			case <-ctx.Done():
+				return nil
+			}
+		},
+		Stop: func(ctx context.Context) error {
+			// A server that failed to serve has nothing to shut down
+			if failed.Load() {
+				return nil
+			}
+			return runner.Shutdown(ctx)
+		},
+		StopTimeout: stopTimeout,
+	}
+}
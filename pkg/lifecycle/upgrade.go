@@ -0,0 +1,145 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// upgradeParentEnv tells a process started by an upgrade which process to take over from
+const upgradeParentEnv = "UPGRADE_PARENT_PID"
+
+// Upgrade is a component that re-executes the binary on SIGUSR2 for a restart without refusing connections.
+// The new process inherits the servers' listening sockets and, once all its components are ready,
+// sends SIGTERM to this one, which stops through the usual graceful shutdown.
+// If the new process fails to start, this one keeps serving.
+func Upgrade(logger *slog.Logger, servers ...*Server) Component {
+	signals := make(chan os.Signal, 1)
+	var upgrading atomic.Bool
+	return Component{
+		Name: "upgrade",
+		Start: func(ctx context.Context) error {
+			signal.Notify(signals, syscall.SIGUSR2)
+			return nil
+		},
+		Run: func(ctx context.Context) error {
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-signals:
+				}
+				if !upgrading.CompareAndSwap(false, true) {
+					logger.Warn("Upgrade already in progress")
+					continue
+				}
+				logger.Info("Upgrade signal received, starting the new process")
+				child, err := reexec(servers)
+				if err != nil {
+					logger.Error("Upgrade failed", "error", err)
+					upgrading.Store(false)
+					continue
+				}
+				go func() {
+					state, err := child.Wait()
+					if err == nil {
+						err = errors.New(state.String())
+					}
+					logger.Error("Upgraded process exited before taking over", "pid", child.Pid, "error", err)
+					upgrading.Store(false)
+				}()
+			}
+		},
+		Stop: func(ctx context.Context) error {
+			signal.Stop(signals)
+			return nil
+		},
+	}
+}
+
+// reexec starts the current executable with the same arguments, passing the servers' sockets as LISTEN_FDS.
+// It forks with raw descriptors because exec.Cmd switches the files it passes to blocking mode,
+// and the socket is shared with the servers still accepting in this process.
+func reexec(servers []*Server) (*os.Process, error) {
+	fds := []uintptr{0, 1, 2}
+	defer func() {
+		for _, fd := range fds[listenFDsStart:] {
+			syscall.Close(int(fd))
+		}
+	}()
+	for _, s := range servers {
+		fd, err := dupListener(s)
+		if err != nil {
+			return nil, err
+		}
+		fds = append(fds, fd)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	env := make([]string, 0, len(os.Environ())+2)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "LISTEN_") && !strings.HasPrefix(kv, upgradeParentEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env, listenFDsEnv+"="+strconv.Itoa(len(servers)), upgradeParentEnv+"="+strconv.Itoa(os.Getpid()))
+
+	pid, err := syscall.ForkExec(exe, append([]string{exe}, os.Args[1:]...), &syscall.ProcAttr{Env: env, Files: fds})
+	if err != nil {
+		return nil, err
+	}
+	return os.FindProcess(pid)
+}
+
+// dupListener duplicates a server's listening socket, close-on-exec until ForkExec hands it over
+func dupListener(s *Server) (uintptr, error) {
+	sc, ok := s.listener.(syscall.Conn)
+	if !ok {
+		return 0, fmt.Errorf("server on %s has no socket to pass on", s.Addr())
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var dup int
+	var dupErr error
+	err = raw.Control(func(fd uintptr) {
+		syscall.ForkLock.RLock()
+		defer syscall.ForkLock.RUnlock()
+		dup, dupErr = syscall.Dup(int(fd))
+		if dupErr == nil {
+			syscall.CloseOnExec(dup)
+		}
+	})
+	if err == nil {
+		err = dupErr
+	}
+	return uintptr(dup), err
+}
+
+// takeOver stops the process this one was upgraded from, once this one is ready
+func takeOver(logger *slog.Logger) error {
+	value := os.Getenv(upgradeParentEnv)
+	if value == "" {
+		return nil
+	}
+	os.Unsetenv(upgradeParentEnv)
+
+	pid, err := strconv.Atoi(value)
+	if err != nil || pid != os.Getppid() {
+		return errors.New(upgradeParentEnv + " is not this process's parent")
+	}
+	logger.Info("Taking over from the previous process", "pid", pid)
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
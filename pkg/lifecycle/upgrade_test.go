@@ -0,0 +1,148 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// childAddrEnv makes the test binary run as the new process of TestUpgrade instead of running the tests
+const childAddrEnv = "LIFECYCLE_TEST_CHILD_ADDR"
+
+func TestMain(m *testing.M) {
+	if addr := os.Getenv(childAddrEnv); addr != "" {
+		os.Exit(ExitCode(pidServer(addr).Run(context.Background())))
+	}
+	os.Exit(m.Run())
+}
+
+// pidServer is an app serving its process id on addr, and upgradable
+func pidServer(addr string) *App {
+	server := NewServer(addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, os.Getpid())
+	}))
+	app := New(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	app.Add(HTTPServer("http", server, 0), Upgrade(app.logger, server))
+	return app
+}
+
+func TestListenFDs(t *testing.T) {
+	for _, tc := range []struct {
+		fds, pid string
+		n        int
+		ok, err  bool
+	}{
+		{"", "", 0, false, false},
+		{"2", "", 2, true, false},
+		{"1", "42", 1, true, false},
+		{"1", "7", 0, false, false},
+		{"0", "", 0, false, false},
+		{"two", "", 0, false, true},
+	} {
+		n, ok, err := listenFDs(tc.fds, tc.pid, 42)
+		assert.Equal(t, tc.n, n, tc)
+		assert.Equal(t, tc.ok, ok, tc)
+		assert.Equal(t, tc.err, err != nil, tc)
+	}
+}
+
+func TestUnclaimedInheritedListenersAreClosed(t *testing.T) {
+	require.NoError(t, loadInherited())
+	claimed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer claimed.Close()
+	unclaimed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	inherited.mutex.Lock()
+	inherited.listeners = []net.Listener{claimed, unclaimed}
+	inherited.mutex.Unlock()
+
+	server := NewServer(claimed.Addr().String(), http.NotFoundHandler())
+	var logs strings.Builder
+	app := New(slog.New(slog.NewTextHandler(&logs, nil)))
+	app.Add(HTTPServer("http", server, 0))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- app.Run(ctx) }()
+	require.Eventually(t, app.Ready, time.Second, 5*time.Millisecond)
+
+	// The server serves on the socket it took, the other one is closed and logged
+	resp, err := http.Get("http://" + claimed.Addr().String())
+	require.NoError(t, err)
+	resp.Body.Close()
+	_, err = unclaimed.Accept()
+	assert.ErrorIs(t, err, net.ErrClosed)
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.Contains(t, logs.String(), "addr="+unclaimed.Addr().String())
+}
+
+func TestUpgrade(t *testing.T) {
+	// Find a free port, the new process finds its socket by port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	t.Setenv(childAddrEnv, addr)
+
+	done := make(chan error)
+	go func() { done <- pidServer(addr).Run(context.Background()) }()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: time.Second}
+	servedBy := func() (int, error) {
+		resp, err := client.Get("http://" + addr)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return 0, err
+		}
+		return strconv.Atoi(string(body))
+	}
+	require.Eventually(t, func() bool {
+		pid, err := servedBy()
+		return err == nil && pid == os.Getpid()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Every request succeeds while the new process takes over
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR2))
+	child := 0
+	deadline := time.Now().Add(20 * time.Second)
+	for child == 0 && time.Now().Before(deadline) {
+		pid, err := servedBy()
+		require.NoError(t, err)
+		if pid != os.Getpid() {
+			child = pid
+		}
+	}
+	require.NotZero(t, child, "the new process never took over")
+	defer syscall.Kill(child, syscall.SIGKILL)
+
+	// The old process drains and stops cleanly
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the old process did not stop")
+	}
+	pid, err := servedBy()
+	require.NoError(t, err)
+	assert.Equal(t, child, pid)
+
+	require.NoError(t, syscall.Kill(child, syscall.SIGTERM))
+}
@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/cupv/mux/pkg/recorder"
+	"github.com/gorilla/mux"
+)
+
+// RequestIDHeader carries the ID of a request, it is read from the request and set on the response
+const RequestIDHeader = "X-Request-ID"
+
+// UserHeader identifies the user making a request
+const UserHeader = "X-User-Id"
+
+// maxRequestIDLen bounds the request IDs taken from callers
+const maxRequestIDLen = 128
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a context carrying the request ID id
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID in ctx, empty when there is none
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestID gives every request an ID, the caller's X-Request-ID when it sends a usable one.
+// The ID is put in the request context, where every record logged with it picks it up, and echoed in the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(ContextWithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID accepts short IDs of printable ASCII, so callers can't inject anything into logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	return fmt.Sprintf("%016x%016x", rand.Uint64(), rand.Uint64())
+}
+
+// AccessLog logs every request served by next once it is answered: method, route, status, bytes, latency and user.
+// Requests are named by the path template of the first route they match in routers, their path when none matches.
+// It runs inside RequestID so its records carry the request ID.
+func AccessLog(next http.Handler, routers ...*mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r, routers)
+		rec := recorder.New(w)
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.Int("status", rec.Status()),
+			slog.Int64("bytes", rec.Bytes()),
+			slog.Duration("latency", time.Since(start)),
+		}
+		if user := user(r); user != "" {
+			attrs = append(attrs, slog.String("user", user))
+		}
+		slog.LogAttrs(r.Context(), slog.LevelInfo, "HTTP request", attrs...)
+	})
+}
+
+// user is the user a request is made for, from the X-User-Id header
+func user(r *http.Request) string {
+	return r.Header.Get(UserHeader)
+}
+
+// routeTemplate is the path template of the first route matching r
+func routeTemplate(r *http.Request, routers []*mux.Router) string {
+	for _, router := range routers {
+		var match mux.RouteMatch
+		if !router.Match(r, &match) || match.Route == nil {
+			continue
+		}
+		if template, err := match.Route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
@@ -0,0 +1,166 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Levels is the minimum level of every package, a default one and overrides for some packages.
+// It can change while the process runs, the next record uses the new levels.
+//
+// A package is named by its import path or any trailing part of it: "outbox", "internal/outbox" and
+// "github.com/cupv/mux/internal/outbox" all name the outbox package. The longest matching name wins.
+type Levels struct {
+	mutex    sync.RWMutex
+	def      slog.Level
+	packages map[string]slog.Level
+	// min is the lowest level of all, records below it are dropped before their package is looked up
+	min slog.Level
+}
+
+func NewLevels(def slog.Level, packages map[string]slog.Level) *Levels {
+	l := &Levels{}
+	l.Replace(def, packages)
+	return l
+}
+
+// Level is the default level, so Levels is a slog.Leveler
+func (l *Levels) Level() slog.Level {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.def
+}
+
+// Packages returns a copy of the package overrides
+func (l *Levels) Packages() map[string]slog.Level {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	packages := make(map[string]slog.Level, len(l.packages))
+	for pkg, level := range l.packages {
+		packages[pkg] = level
+	}
+	return packages
+}
+
+// Replace sets the default level and all the package overrides at once, e.g. on a configuration reload
+func (l *Levels) Replace(def slog.Level, packages map[string]slog.Level) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.def = def
+	l.packages = make(map[string]slog.Level, len(packages))
+	for pkg, level := range packages {
+		l.packages[pkg] = level
+	}
+	l.update()
+}
+
+// SetDefault changes the default level
+func (l *Levels) SetDefault(level slog.Level) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.def = level
+	l.update()
+}
+
+// Set overrides the level of a package
+func (l *Levels) Set(pkg string, level slog.Level) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.packages[pkg] = level
+	l.update()
+}
+
+// Unset drops the override of a package, it logs at the default level again
+func (l *Levels) Unset(pkg string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.packages, pkg)
+	l.update()
+}
+
+// update recomputes min, the mutex must be held
+func (l *Levels) update() {
+	l.min = l.def
+	for _, level := range l.packages {
+		l.min = min(l.min, level)
+	}
+}
+
+// enabled reports whether any package logs at level
+func (l *Levels) enabled(level slog.Level) bool {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return level >= l.min
+}
+
+// For returns the level of the package at path
+func (l *Levels) For(path string) slog.Level {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	level, matched := l.def, ""
+	for pkg, pkgLevel := range l.packages {
+		if len(pkg) > len(matched) && (path == pkg || strings.HasSuffix(path, "/"+pkg)) {
+			level, matched = pkgLevel, pkg
+		}
+	}
+	return level
+}
+
+// String renders the levels as ParseLevels reads them, with the default first
+func (l *Levels) String() string {
+	packages := l.Packages()
+	names := make([]string, 0, len(packages))
+	for pkg := range packages {
+		names = append(names, pkg)
+	}
+	sort.Strings(names)
+	parts := []string{l.Level().String()}
+	for _, pkg := range names {
+		parts = append(parts, pkg+"="+packages[pkg].String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseLevels reads package overrides written as package=level, e.g. "outbox=debug" or "pkg/broker=warn"
+func ParseLevels(specs []string) (map[string]slog.Level, error) {
+	packages := make(map[string]slog.Level, len(specs))
+	for _, spec := range specs {
+		pkg, name, ok := strings.Cut(strings.TrimSpace(spec), "=")
+		if !ok || pkg == "" {
+			return nil, fmt.Errorf("%q is not package=level", spec)
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return nil, fmt.Errorf("%q: %w", spec, err)
+		}
+		packages[strings.Trim(pkg, "/")] = level
+	}
+	return packages, nil
+}
+
+// packages caches the import path of the function at each program counter
+var packages sync.Map
+
+// packageOf returns the import path of the package of the function at pc
+func packageOf(pc uintptr) string {
+	if pc == 0 {
+		return ""
+	}
+	if path, ok := packages.Load(pc); ok {
+		return path.(string)
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	// The function is e.g. github.com/cupv/mux/internal/outbox.(*Relay).Run
+	fn := frame.Function
+	slash := strings.LastIndex(fn, "/")
+	path := fn
+	if dot := strings.Index(fn[slash+1:], "."); dot >= 0 {
+		path = fn[:slash+1+dot]
+	}
+	packages.Store(pc, path)
+	return path
+}
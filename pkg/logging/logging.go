@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+
+	"github.com/cupv/mux/pkg/trace"
+)
+
+// New creates a logger writing text or JSON records to w, at the levels in effect in levels.
+// Records logged with a context carry its request ID, trace and the attributes added with With.
+func New(w io.Writer, format string, levels *Levels) *slog.Logger {
+	// Levels filter records themselves, the inner handler lets everything through
+	opts := &slog.HandlerOptions{Level: slog.Level(-1 << 10)}
+	var inner slog.Handler = slog.NewTextHandler(w, opts)
+	if format == "json" {
+		inner = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(&handler{inner: inner, levels: levels})
+}
+
+type attrsKey struct{}
+
+// With returns a context carrying attrs, every record logged with it or a context derived from it has them.
+// It lets a layer add what it knows, e.g. a card ID, to the records of the layers it calls.
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	current := Attrs(ctx)
+	merged := make([]slog.Attr, 0, len(current)+len(attrs))
+	merged = append(append(merged, current...), attrs...)
+	return context.WithValue(ctx, attrsKey{}, merged)
+}
+
+// Attrs returns the attributes added to ctx with With
+func Attrs(ctx context.Context) []slog.Attr {
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// handler adds the attributes of the context to records and filters them by the level of their package
+type handler struct {
+	inner  slog.Handler
+	levels *Levels
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.levels.enabled(level)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < h.levels.For(packageOf(r.PC)) {
+		return nil
+	}
+	if ctx != nil {
+		if id := RequestIDFromContext(ctx); id != "" {
+			r.AddAttrs(slog.String("request_id", id))
+		}
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			r.AddAttrs(slog.String("trace_id", sc.TraceID.String()), slog.String("span_id", sc.SpanID.String()))
+		}
+		r.AddAttrs(Attrs(ctx)...)
+	}
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &handler{inner: h.inner.WithAttrs(attrs), levels: h.levels}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{inner: h.inner.WithGroup(name), levels: h.levels}
+}
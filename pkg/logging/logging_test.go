@@ -0,0 +1,162 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cupv/mux/pkg/trace"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// records decodes the JSON records written to buf
+func records(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var out []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		out = append(out, record)
+	}
+	return out
+}
+
+func TestRecordsCarryTheContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "json", NewLevels(slog.LevelInfo, nil))
+
+	sc, err := trace.ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	require.NoError(t, err)
+	ctx := ContextWithRequestID(trace.ContextWithRemote(context.Background(), sc), "req-1")
+	ctx = With(ctx, slog.Int("card.id", 7))
+	ctx = With(ctx, slog.String("step", "update"))
+	logger.With("component", "test").InfoContext(ctx, "Card updated")
+	logger.Info("No context")
+
+	got := records(t, &buf)
+	require.Len(t, got, 2)
+	assert.Equal(t, "Card updated", got[0]["msg"])
+	assert.Equal(t, "req-1", got[0]["request_id"])
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", got[0]["trace_id"])
+	assert.Equal(t, "b7ad6b7169203331", got[0]["span_id"])
+	assert.Equal(t, float64(7), got[0]["card.id"])
+	assert.Equal(t, "update", got[0]["step"])
+	assert.Equal(t, "test", got[0]["component"])
+	assert.NotContains(t, got[1], "request_id")
+}
+
+func TestLevelsPerPackage(t *testing.T) {
+	var buf bytes.Buffer
+	levels := NewLevels(slog.LevelInfo, nil)
+	logger := New(&buf, "text", levels)
+
+	logger.Debug("hidden")
+	levels.Set("pkg/logging", slog.LevelDebug)
+	logger.Debug("shown")
+	levels.Set("outbox", slog.LevelError)
+	assert.Equal(t, slog.LevelError, levels.For("github.com/cupv/mux/internal/outbox"))
+	assert.Equal(t, slog.LevelInfo, levels.For("github.com/cupv/mux/internal/webhook"))
+	assert.Equal(t, slog.LevelInfo, levels.For("github.com/cupv/mux/internal/myoutbox"))
+
+	// The longest name wins
+	levels.Set("logging", slog.LevelError)
+	logger.Debug("shown again")
+	levels.Unset("pkg/logging")
+	logger.Warn("hidden again")
+	levels.SetDefault(slog.LevelWarn)
+	levels.Unset("logging")
+	logger.Warn("warned")
+
+	out := buf.String()
+	assert.NotContains(t, out, "hidden")
+	assert.Contains(t, out, "shown")
+	assert.Contains(t, out, "shown again")
+	assert.Contains(t, out, "warned")
+	assert.Equal(t, "WARN,outbox=ERROR", levels.String())
+}
+
+func TestParseLevels(t *testing.T) {
+	packages, err := ParseLevels([]string{"outbox=debug", " /pkg/broker/=warn "})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]slog.Level{"outbox": slog.LevelDebug, "pkg/broker": slog.LevelWarn}, packages)
+
+	for _, spec := range []string{"outbox", "=debug", "outbox=loud"} {
+		_, err := ParseLevels([]string{spec})
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+	}))
+
+	for _, tc := range []struct {
+		header string
+		kept   bool
+	}{
+		{"", false},
+		{"abc-123", true},
+		{"bad\nid", false},
+		{strings.Repeat("a", maxRequestIDLen+1), false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(RequestIDHeader, tc.header)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.NotEmpty(t, seen)
+		assert.Equal(t, seen, rec.Header().Get(RequestIDHeader))
+		if tc.kept {
+			assert.Equal(t, tc.header, seen)
+		} else {
+			assert.NotEqual(t, tc.header, seen)
+			assert.Len(t, seen, 32)
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(New(&buf, "json", NewLevels(slog.LevelInfo, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	router := mux.NewRouter()
+	router.HandleFunc("/card/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	}).Methods("PUT")
+	handler := RequestID(AccessLog(router, router))
+
+	req := httptest.NewRequest(http.MethodPut, "/card/7", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	req.Header.Set(UserHeader, "42")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nowhere?user_id=9", nil))
+
+	got := records(t, &buf)
+	require.Len(t, got, 2)
+	assert.Equal(t, "HTTP request", got[0]["msg"])
+	assert.Equal(t, "PUT", got[0]["method"])
+	assert.Equal(t, "/card/{id}", got[0]["route"])
+	assert.Equal(t, float64(http.StatusCreated), got[0]["status"])
+	assert.Equal(t, float64(5), got[0]["bytes"])
+	assert.Contains(t, got[0], "latency")
+	assert.Equal(t, "42", got[0]["user"])
+	assert.Equal(t, "req-1", got[0]["request_id"])
+
+	assert.Equal(t, "/nowhere", got[1]["route"])
+	assert.Equal(t, float64(http.StatusNotFound), got[1]["status"])
+	assert.NotContains(t, got[1], "user", "identity only comes from the header")
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cupv/mux/pkg/recorder"
+	"github.com/gorilla/mux"
+)
+
+// Unmatched is the route label of requests that match no route
+const Unmatched = "unmatched"
+
+// InstrumentHTTP counts, times and tracks in-flight requests served by next.
+// Requests are labelled with the path template of the first route they match in routers,
+// rather than their path, so the number of series stays bounded.
+func (r *Registry) InstrumentHTTP(next http.Handler, routers ...*mux.Router) http.Handler {
+	requests := r.NewCounterVec("http_requests_total", "HTTP requests by method, route template and status code.",
+		"method", "route", "code")
+	duration := r.NewHistogramVec("http_request_duration_seconds", "HTTP request latency by method and route template.",
+		DefBuckets, "method", "route")
+	inFlight := r.NewGauge("http_requests_in_flight", "HTTP requests being served.")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		route := routeTemplate(req, routers)
+		method := methodLabel(req.Method)
+		rec := recorder.New(w)
+		start := time.Now()
+		next.ServeHTTP(rec, req)
+		duration.With(method, route).Observe(time.Since(start).Seconds())
+		requests.With(method, route, strconv.Itoa(rec.Status())).Inc()
+	})
+}
+
+// routeTemplate is the path template of the first route matching req
+func routeTemplate(req *http.Request, routers []*mux.Router) string {
+	for _, router := range routers {
+		var match mux.RouteMatch
+		if !router.Match(req, &match) || match.Route == nil {
+			continue
+		}
+		if template, err := match.Route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return Unmatched
+}
+
+// methodLabel keeps arbitrary methods out of the labels
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
@@ -0,0 +1,344 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Default is the registry served on /metrics, packages register their metrics on it like they would with expvar
+var Default = NewRegistry()
+
+// DefBuckets are latency buckets in seconds, from 5ms to 10s
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metrics and writes them in the Prometheus text exposition format
+type Registry struct {
+	mutex    sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// family is a metric name with its help, type and one series per set of label values
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mutex  sync.Mutex
+	series map[string]*series
+	// newMetric creates the metric of a new series
+	newMetric func() metric
+}
+
+type series struct {
+	values []string
+	metric metric
+}
+
+// metric writes its samples, labels is the series' rendered label pairs without braces
+type metric interface {
+	write(w *bufio.Writer, name, labels string)
+}
+
+// register adds a family, registering a name twice is a programming error and panics
+func (r *Registry) register(name, help, kind string, labels []string, newMetric func() metric) *family {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.families[name]; ok {
+		panic("metrics: " + name + " registered twice")
+	}
+	f := &family{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*series), newMetric: newMetric}
+	r.families[name] = f
+	return f
+}
+
+// with returns the metric of a set of label values, creating it on first use
+func (f *family) with(values []string) metric {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...), metric: f.newMetric()}
+		f.series[key] = s
+	}
+	return s.metric
+}
+
+// value is a float64 updated atomically
+type value struct {
+	bits atomic.Uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := v.bits.Load()
+		if v.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (v *value) set(f float64) {
+	v.bits.Store(math.Float64bits(f))
+}
+
+func (v *value) get() float64 {
+	return math.Float64frombits(v.bits.Load())
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	v value
+}
+
+func (c *Counter) Inc() {
+	c.v.add(1)
+}
+
+// Add increases the counter, negative deltas are ignored
+func (c *Counter) Add(delta float64) {
+	if delta > 0 {
+		c.v.add(delta)
+	}
+}
+
+func (c *Counter) Value() float64 {
+	return c.v.get()
+}
+
+func (c *Counter) write(w *bufio.Writer, name, labels string) {
+	writeSample(w, name, labels, c.v.get())
+}
+
+// Gauge is a value that goes up and down
+type Gauge struct {
+	v value
+}
+
+func (g *Gauge) Set(f float64) {
+	g.v.set(f)
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.v.add(delta)
+}
+
+func (g *Gauge) Inc() {
+	g.v.add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.v.add(-1)
+}
+
+func (g *Gauge) Value() float64 {
+	return g.v.get()
+}
+
+func (g *Gauge) write(w *bufio.Writer, name, labels string) {
+	writeSample(w, name, labels, g.v.get())
+}
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	upper  []float64
+	counts []atomic.Uint64
+	count  atomic.Uint64
+	sum    value
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	upper := append([]float64(nil), buckets...)
+	sort.Float64s(upper)
+	return &Histogram{upper: upper, counts: make([]atomic.Uint64, len(upper))}
+}
+
+func (h *Histogram) Observe(f float64) {
+	if i := sort.SearchFloat64s(h.upper, f); i < len(h.upper) {
+		h.counts[i].Add(1)
+	}
+	h.count.Add(1)
+	h.sum.add(f)
+}
+
+func (h *Histogram) write(w *bufio.Writer, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var cumulative uint64
+	for i, upper := range h.upper {
+		cumulative += h.counts[i].Load()
+		writeSample(w, name+"_bucket", labels+sep+`le="`+formatFloat(upper)+`"`, float64(cumulative))
+	}
+	count := h.count.Load()
+	writeSample(w, name+"_bucket", labels+sep+`le="+Inf"`, float64(count))
+	writeSample(w, name+"_sum", labels, h.sum.get())
+	writeSample(w, name+"_count", labels, float64(count))
+}
+
+// valueFunc is a metric read when it is written
+type valueFunc func() float64
+
+func (f valueFunc) write(w *bufio.Writer, name, labels string) {
+	writeSample(w, name, labels, f())
+}
+
+// CounterVec is a counter per set of label values
+type CounterVec struct {
+	f *family
+}
+
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.f.with(values).(*Counter)
+}
+
+// GaugeVec is a gauge per set of label values
+type GaugeVec struct {
+	f *family
+}
+
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.f.with(values).(*Gauge)
+}
+
+// HistogramVec is a histogram per set of label values
+type HistogramVec struct {
+	f *family
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.f.with(values).(*Histogram)
+}
+
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, "counter", labels, func() metric { return &Counter{} })}
+}
+
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, "gauge", labels, func() metric { return &Gauge{} })}
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	return r.NewHistogramVec(name, help, buckets).With()
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{r.register(name, help, "histogram", labels, func() metric { return newHistogram(buckets) })}
+}
+
+// NewGaugeFunc registers a gauge whose value is read from fn on every scrape
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(name, help, "gauge", nil, func() metric { return valueFunc(fn) }).with(nil)
+}
+
+// NewCounterFunc registers a counter whose value is read from fn on every scrape, fn must never decrease
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(name, help, "counter", nil, func() metric { return valueFunc(fn) }).with(nil)
+}
+
+// Write writes every metric in the text exposition format, sorted by name and label values
+func (r *Registry) Write(out io.Writer) error {
+	r.mutex.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mutex.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	w := bufio.NewWriter(out)
+	for _, f := range families {
+		f.mutex.Lock()
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		all := make([]*series, len(keys))
+		for i, key := range keys {
+			all[i] = f.series[key]
+		}
+		f.mutex.Unlock()
+		if len(all) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind)
+		for _, s := range all {
+			pairs := make([]string, len(f.labels))
+			for i, label := range f.labels {
+				pairs[i] = label + `="` + escapeLabel(s.values[i]) + `"`
+			}
+			s.metric.write(w, f.name, strings.Join(pairs, ","))
+		}
+	}
+	return w.Flush()
+}
+
+// Handler serves the registry to Prometheus
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+func writeSample(w *bufio.Writer, name, labels string, v float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+	var b strings.Builder
+	require.NoError(t, r.Write(&b))
+	return b.String()
+}
+
+func TestWriteUsesTheTextFormat(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests\nby path.", "path")
+	requests.With(`/a"b`).Add(2)
+	requests.With("/").Inc()
+	r.NewGauge("queue_length", "Queued items.").Set(3.5)
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{1, 0.1})
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(4)
+	r.NewGaugeFunc("answer", "Read on scrape.", func() float64 { return 42 })
+	r.NewCounterVec("unused_total", "Never incremented.", "label")
+
+	assert.Equal(t, `# HELP answer Read on scrape.
+# TYPE answer gauge
+answer 42
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 4.55
+latency_seconds_count 3
+# HELP queue_length Queued items.
+# TYPE queue_length gauge
+queue_length 3.5
+# HELP requests_total Requests\nby path.
+# TYPE requests_total counter
+requests_total{path="/"} 1
+requests_total{path="/a\"b"} 2
+`, scrape(t, r))
+}
+
+func TestRegisteringANameTwicePanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("requests_total", "Requests.")
+	assert.Panics(t, func() { r.NewGauge("requests_total", "Requests.") })
+}
+
+func TestInstrumentHTTPLabelsByRouteTemplate(t *testing.T) {
+	r := NewRegistry()
+	router := mux.NewRouter()
+	router.HandleFunc("/card/{id}", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("DELETE")
+	handler := r.InstrumentHTTP(router, router)
+
+	for _, path := range []string{"/card/1", "/card/2", "/nowhere"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", path, nil))
+	}
+
+	out := scrape(t, r)
+	assert.Contains(t, out, `http_requests_total{method="DELETE",route="/card/{id}",code="204"} 2`)
+	assert.Contains(t, out, `http_requests_total{method="DELETE",route="unmatched",code="404"} 1`)
+	assert.Contains(t, out, `http_request_duration_seconds_count{method="DELETE",route="/card/{id}"} 2`)
+	assert.Contains(t, out, "http_requests_in_flight 0")
+}
+
+func TestDBStats(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(7)
+
+	r := NewRegistry()
+	r.DBStats(db)
+	out := scrape(t, r)
+	assert.Contains(t, out, "sql_max_open_connections 7")
+	assert.Contains(t, out, "# TYPE sql_wait_count_total counter")
+}
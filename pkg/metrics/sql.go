@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"database/sql"
+)
+
+// DBStats exposes the connection pool statistics of db, read on every scrape
+func (r *Registry) DBStats(db *sql.DB) {
+	stats := []struct {
+		name, help string
+		counter    bool
+		value      func(s sql.DBStats) float64
+	}{
+		{"sql_max_open_connections", "Maximum number of open connections to the database.", false,
+			func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		{"sql_open_connections", "Established connections, in use and idle.", false,
+			func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"sql_in_use_connections", "Connections currently in use.", false,
+			func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"sql_idle_connections", "Idle connections.", false,
+			func(s sql.DBStats) float64 { return float64(s.Idle) }},
+		{"sql_wait_count_total", "Connections waited for because the pool was exhausted.", true,
+			func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"sql_wait_duration_seconds_total", "Time spent waiting for a connection.", true,
+			func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+		{"sql_max_idle_closed_total", "Connections closed because the idle pool was full.", true,
+			func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }},
+		{"sql_max_idle_time_closed_total", "Connections closed for being idle too long.", true,
+			func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }},
+		{"sql_max_lifetime_closed_total", "Connections closed for reaching their maximum lifetime.", true,
+			func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }},
+	}
+
+	for _, stat := range stats {
+		value := stat.value
+		read := func() float64 { return value(db.Stats()) }
+		if stat.counter {
+			r.NewCounterFunc(stat.name, stat.help, read)
+		} else {
+			r.NewGaugeFunc(stat.name, stat.help, read)
+		}
+	}
+}
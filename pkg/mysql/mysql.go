@@ -1,30 +1,50 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
-	"log"
+	"log/slog"
 	
-	_ "github.com/go-sql-driver/mysql" // MySQL driver
+	driver "github.com/go-sql-driver/mysql"
 )
 
 type Database struct {
 	Conn *sql.DB
 }
 
-func Serve(user, password, host, dbname string) (*Database, error) {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true", user, password, host, dbname)
-	db, err := sql.Open("mysql", dsn)
+// Open sets up a MySQL pool without connecting yet.
+// The password is read each time a connection is opened, so a rotated password is picked up.
+func Open(user string, password func() string, host, dbname string) (*Database, error) {
+	cfg := driver.NewConfig()
+	cfg.User = user
+	cfg.Net = "tcp"
+	cfg.Addr = host
+	cfg.DBName = dbname
+	cfg.ParseTime = true
+	// clientFoundRows makes updates report matched rows, so saving an unchanged card is not mistaken for a missing one
+	cfg.ClientFoundRows = true
+	err := cfg.Apply(driver.BeforeConnect(func(ctx context.Context, cfg *driver.Config) error {
+		cfg.Passwd = password()
+		return nil
+	}))
 	if err != nil {
 		return nil, err
 	}
 
-	if err := db.Ping(); err != nil {
+	connector, err := driver.NewConnector(cfg)
+	if err != nil {
 		return nil, err
 	}
+	return &Database{Conn: sql.OpenDB(connector)}, nil
+}
 
-	log.Println("Connected to MySQL database successfully")
-	return &Database{Conn: db}, nil
+// Ping checks that the database can be reached
+func (d *Database) Ping(ctx context.Context) error {
+	if err := d.Conn.PingContext(ctx); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Connected to MySQL database successfully")
+	return nil
 }
 
 func (d *Database) Close() error {
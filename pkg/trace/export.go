@@ -0,0 +1,204 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scopeName names this package as the instrumentation scope of exported spans
+const scopeName = "github.com/cupv/mux/pkg/trace"
+
+// The OTLP JSON encoding of a batch of spans, see opentelemetry-proto's trace.proto
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              Kind            `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+	}
+)
+
+// Status codes of OTLP spans
+const (
+	statusUnset = 0
+	statusError = 2
+)
+
+// encodeOTLP renders spans as an OTLP/JSON export request
+func encodeOTLP(service string, spans []SpanData) ([]byte, error) {
+	encoded := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.SpanContext.TraceID.String(),
+			SpanID:            s.SpanContext.SpanID.String(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        encodeAttributes(s.Attributes),
+			Status:            otlpStatus{Code: statusUnset},
+		}
+		if s.Parent.IsValid() {
+			span.ParentSpanID = s.Parent.String()
+		}
+		if s.Error != "" {
+			span.Status = otlpStatus{Code: statusError, Message: s.Error}
+		}
+		encoded[i] = span
+	}
+
+	return json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: encodeAttributes([]slog.Attr{slog.String("service.name", service)})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: scopeName}, Spans: encoded}},
+	}}})
+}
+
+func encodeAttributes(attrs []slog.Attr) []otlpAttribute {
+	encoded := make([]otlpAttribute, 0, len(attrs))
+	for _, attr := range attrs {
+		var v otlpValue
+		value := attr.Value.Resolve()
+		switch value.Kind() {
+		case slog.KindInt64:
+			s := strconv.FormatInt(value.Int64(), 10)
+			v.IntValue = &s
+		case slog.KindUint64:
+			s := strconv.FormatUint(value.Uint64(), 10)
+			v.IntValue = &s
+		case slog.KindFloat64:
+			f := value.Float64()
+			v.DoubleValue = &f
+		case slog.KindBool:
+			b := value.Bool()
+			v.BoolValue = &b
+		default:
+			s := value.String()
+			v.StringValue = &s
+		}
+		encoded = append(encoded, otlpAttribute{Key: attr.Key, Value: v})
+	}
+	return encoded
+}
+
+// OTLP exports spans to an OpenTelemetry collector over OTLP/HTTP with the JSON encoding
+type OTLP struct {
+	url    string
+	client *http.Client
+}
+
+// NewOTLP creates an exporter for the collector at endpoint, e.g. http://localhost:4318
+func NewOTLP(endpoint string) *OTLP {
+	return &OTLP{
+		url:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (e *OTLP) Export(ctx context.Context, service string, spans []SpanData) error {
+	body, err := encodeOTLP(service, spans)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Writer exports spans as OTLP/JSON, one export request per line, the format of the collector's file exporter.
+// It suits offline environments: the lines can be read as they are or replayed to a collector later.
+type Writer struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// NewWriter creates an exporter writing to w, e.g. os.Stdout
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// NewFile creates an exporter appending to the file at path, Shutdown of the tracer closes it
+func NewFile(path string) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(f), nil
+}
+
+func (e *Writer) Export(ctx context.Context, service string, spans []SpanData) error {
+	line, err := encodeOTLP(service, spans)
+	if err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	_, err = e.w.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the underlying file, standard output and error are left open
+func (e *Writer) Close() error {
+	if closer, ok := e.w.(io.Closer); ok && e.w != os.Stdout && e.w != os.Stderr {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package trace
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/cupv/mux/pkg/recorder"
+	"github.com/gorilla/mux"
+)
+
+// TraceparentHeader is the W3C trace-context header
+const TraceparentHeader = "traceparent"
+
+// Middleware starts a server span for every request routed by a mux router, WebSocket upgrades included.
+// A request with a traceparent header continues the caller's trace. The span is named after the route
+// template, e.g. "PUT /card/{id}", and is in the request context for handlers to start children from.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, err := ParseTraceparent(r.Header.Get(TraceparentHeader)); err == nil {
+			ctx = ContextWithRemote(ctx, sc)
+		}
+
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		ctx, span := Start(ctx, r.Method+" "+route, Server,
+			slog.String("http.request.method", r.Method),
+			slog.String("http.route", route),
+			slog.String("url.path", r.URL.Path),
+		)
+		defer span.End()
+
+		rec := recorder.New(w)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		span.SetAttributes(slog.Int("http.response.status_code", rec.Status()))
+		if rec.Status() >= http.StatusInternalServerError {
+			span.SetError(fmt.Errorf("answered %d", rec.Status()))
+		}
+	})
+}
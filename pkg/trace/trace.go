@@ -0,0 +1,270 @@
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace across services
+type TraceID [16]byte
+
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext is what travels between processes: the trace, the span in it and whether the trace is recorded
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as a W3C traceparent header, empty when it is not valid
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ErrBadTraceparent is returned for headers that are not a W3C traceparent
+var ErrBadTraceparent = errors.New("malformed traceparent")
+
+// ParseTraceparent reads a W3C traceparent header. Versions other than 00 are read as far as version 00 goes.
+func ParseTraceparent(header string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, ErrBadTraceparent
+	}
+
+	var sc SpanContext
+	var flags [1]byte
+	for _, field := range []struct {
+		dst []byte
+		src string
+	}{{sc.TraceID[:], parts[1]}, {sc.SpanID[:], parts[2]}, {flags[:], parts[3]}} {
+		if len(field.src) != 2*len(field.dst) || strings.ToLower(field.src) != field.src {
+			return SpanContext{}, ErrBadTraceparent
+		}
+		if _, err := hex.Decode(field.dst, []byte(field.src)); err != nil {
+			return SpanContext{}, ErrBadTraceparent
+		}
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, ErrBadTraceparent
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+// Kind is the role of a span, as in OpenTelemetry
+type Kind int
+
+const (
+	Internal Kind = iota + 1
+	Server
+	Client
+	Producer
+	Consumer
+)
+
+// Span is a timed operation. Spans of traces that are not sampled, or made without a tracer, record nothing
+// but still carry the trace on to their children and to other processes.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent SpanID
+	name   string
+	kind   Kind
+	start  time.Time
+
+	mutex sync.Mutex
+	attrs []slog.Attr
+	err   string
+	ended bool
+}
+
+// SpanContext identifies the span for propagation
+func (s *Span) SpanContext() SpanContext {
+	return s.sc
+}
+
+// IsRecording reports whether the span will be exported
+func (s *Span) IsRecording() bool {
+	return s.tracer != nil
+}
+
+// SetAttributes adds attributes to the span, strings, integers, floats and booleans are exported as such
+func (s *Span) SetAttributes(attrs ...slog.Attr) {
+	if !s.IsRecording() {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// SetError marks the span as failed, a nil error is ignored
+func (s *Span) SetError(err error) {
+	if err == nil || !s.IsRecording() {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.err = err.Error()
+}
+
+// End finishes the span and hands it to the exporter, only the first call counts
+func (s *Span) End() {
+	if !s.IsRecording() {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	data := SpanData{
+		Name:        s.name,
+		Kind:        s.kind,
+		SpanContext: s.sc,
+		Parent:      s.parent,
+		Start:       s.start,
+		End:         time.Now(),
+		Attributes:  s.attrs,
+		Error:       s.err,
+	}
+	s.mutex.Unlock()
+	s.tracer.export(data)
+}
+
+// Finish records err, which may be nil, and ends the span. It suits deferred calls with a named error result:
+//
+//	defer func() { span.Finish(err) }()
+func (s *Span) Finish(err error) {
+	s.SetError(err)
+	s.End()
+}
+
+// SpanData is a finished span as handed to exporters
+type SpanData struct {
+	Name        string
+	Kind        Kind
+	SpanContext SpanContext
+	Parent      SpanID
+	Start       time.Time
+	End         time.Time
+	Attributes  []slog.Attr
+	// Error is the message of the error the span failed with, empty when it succeeded
+	Error string
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a context carrying span, spans started from it become its children
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// ContextWithRemote returns a context continuing a trace that was started in another process
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.IsValid() {
+		return ctx
+	}
+	return ContextWithSpan(ctx, &Span{sc: sc})
+}
+
+// SpanFromContext returns the current span, a span that records nothing when there is none
+func SpanFromContext(ctx context.Context) *Span {
+	if span, ok := ctx.Value(spanKey{}).(*Span); ok {
+		return span
+	}
+	return &Span{}
+}
+
+// SpanContextFromContext returns the span context of the current span, invalid when there is none
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	return SpanFromContext(ctx).sc
+}
+
+// Start begins a span as a child of the current span in ctx, or as the root of a new trace.
+// The returned context carries the new span. End must be called on it.
+func Start(ctx context.Context, name string, kind Kind, attrs ...slog.Attr) (context.Context, *Span) {
+	t := Default()
+	parent := SpanContextFromContext(ctx)
+	if t == nil {
+		// Without a tracer the incoming trace is still passed on
+		return ctx, &Span{sc: parent}
+	}
+
+	sc := SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled}
+	if !parent.IsValid() {
+		sc.TraceID = newTraceID()
+		sc.Sampled = t.sample()
+	}
+	sc.SpanID = newSpanID()
+
+	span := &Span{sc: sc}
+	if sc.Sampled {
+		span.tracer = t
+		span.parent = parent.SpanID
+		span.name = name
+		span.kind = kind
+		span.start = time.Now()
+		span.attrs = attrs
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		putUint64(id[:8], rand.Uint64())
+		putUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		putUint64(id[:], rand.Uint64())
+	}
+	return id
+}
+
+func putUint64(b []byte, v uint64) {
+	for i := range b {
+		b[i] = byte(v >> (8 * i))
+	}
+}
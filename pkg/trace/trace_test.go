@@ -0,0 +1,211 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collector keeps exported spans in memory
+type collector struct {
+	mutex sync.Mutex
+	spans []SpanData
+}
+
+func (c *collector) Export(ctx context.Context, service string, spans []SpanData) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.spans = append(c.spans, spans...)
+	return nil
+}
+
+// useTracer makes a tracer exporting to a collector the default for the test, and returns a function
+// that shuts it down and returns what was exported
+func useTracer(t *testing.T, ratio float64) func() []SpanData {
+	c := &collector{}
+	tracer := NewTracer(Options{Service: "test", SampleRatio: ratio, Exporter: c})
+	SetDefault(tracer)
+	t.Cleanup(func() { SetDefault(nil) })
+	return func() []SpanData {
+		require.NoError(t, tracer.Shutdown(context.Background()))
+		return c.spans
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	require.NoError(t, err)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", sc.TraceID.String())
+	assert.Equal(t, "b7ad6b7169203331", sc.SpanID.String())
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", sc.Traceparent())
+
+	// Later versions may add fields
+	sc, err = ParseTraceparent("01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00-extra")
+	require.NoError(t, err)
+	assert.False(t, sc.Sampled)
+
+	for _, header := range []string{
+		"",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra",
+		"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-0AF7651916CD43DD8448EB211C80319C-b7ad6b7169203331-01",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b71692033-01",
+		"00-0af7651916cd43dd8448eb211c80319x-b7ad6b7169203331-01",
+	} {
+		_, err := ParseTraceparent(header)
+		assert.ErrorIs(t, err, ErrBadTraceparent, header)
+	}
+}
+
+func TestSpansFormATree(t *testing.T) {
+	exported := useTracer(t, 1)
+
+	ctx, root := Start(context.Background(), "POST /card", Server)
+	_, child := Start(ctx, "CardUsecase.Create", Internal, slog.Int("card.id", 7))
+	child.SetError(errors.New("duplicate word"))
+	child.End()
+	child.End()
+	root.End()
+
+	spans := exported()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "CardUsecase.Create", spans[0].Name)
+	assert.Equal(t, root.SpanContext().TraceID, spans[0].SpanContext.TraceID)
+	assert.Equal(t, root.SpanContext().SpanID, spans[0].Parent)
+	assert.Equal(t, "duplicate word", spans[0].Error)
+	assert.Equal(t, "POST /card", spans[1].Name)
+	assert.False(t, spans[1].Parent.IsValid())
+}
+
+func TestUnsampledTracesArePassedOnButNotRecorded(t *testing.T) {
+	exported := useTracer(t, 0)
+	ctx, root := Start(context.Background(), "GET /cards", Server)
+	_, child := Start(ctx, "CardUsecase.FetchCards", Internal)
+	assert.False(t, root.IsRecording())
+	assert.Equal(t, root.SpanContext().TraceID, child.SpanContext().TraceID)
+	child.End()
+	root.End()
+
+	// A sampled caller's decision wins over the ratio
+	remote, err := ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	require.NoError(t, err)
+	_, span := Start(ContextWithRemote(context.Background(), remote), "GET /cards", Server)
+	span.End()
+
+	spans := exported()
+	require.Len(t, spans, 1)
+	assert.Equal(t, remote.TraceID, spans[0].SpanContext.TraceID)
+}
+
+func TestWithoutTracerTheIncomingTraceIsKept(t *testing.T) {
+	remote, err := ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	require.NoError(t, err)
+	_, span := Start(ContextWithRemote(context.Background(), remote), "GET /cards", Server)
+	assert.False(t, span.IsRecording())
+	assert.Equal(t, remote, span.SpanContext())
+	span.End()
+}
+
+func TestWriterExportsOTLPJSON(t *testing.T) {
+	var buf bytes.Buffer
+	sc, err := ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	require.NoError(t, err)
+	span := SpanData{
+		Name:        "publish",
+		Kind:        Producer,
+		SpanContext: sc,
+		Parent:      SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		Attributes:  []slog.Attr{slog.String("messaging.system", "redis"), slog.Int("retries", 2), slog.Bool("ok", false)},
+		Error:       "redis down",
+	}
+	require.NoError(t, NewWriter(&buf).Export(context.Background(), "card", []SpanData{span}))
+
+	var request struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []otlpAttribute `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []map[string]interface{} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &request))
+	assert.Equal(t, byte('\n'), buf.Bytes()[buf.Len()-1])
+	resource := request.ResourceSpans[0]
+	assert.Equal(t, "service.name", resource.Resource.Attributes[0].Key)
+	assert.Equal(t, "card", *resource.Resource.Attributes[0].Value.StringValue)
+
+	encoded := resource.ScopeSpans[0].Spans[0]
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", encoded["traceId"])
+	assert.Equal(t, "b7ad6b7169203331", encoded["spanId"])
+	assert.Equal(t, "0102030405060708", encoded["parentSpanId"])
+	assert.Equal(t, float64(Producer), encoded["kind"])
+	assert.Equal(t, map[string]interface{}{"code": float64(2), "message": "redis down"}, encoded["status"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"key": "messaging.system", "value": map[string]interface{}{"stringValue": "redis"}},
+		map[string]interface{}{"key": "retries", "value": map[string]interface{}{"intValue": "2"}},
+		map[string]interface{}{"key": "ok", "value": map[string]interface{}{"boolValue": false}},
+	}, encoded["attributes"])
+}
+
+func TestOTLPPostsToTheCollector(t *testing.T) {
+	var path, contentType string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	span := SpanData{Name: "GET /cards", Kind: Server, SpanContext: SpanContext{TraceID: newTraceID(), SpanID: newSpanID()}}
+	require.NoError(t, NewOTLP(collector.URL+"/").Export(context.Background(), "card", []SpanData{span}))
+	assert.Equal(t, "/v1/traces", path)
+	assert.Equal(t, "application/json", contentType)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	assert.Error(t, NewOTLP(failing.URL).Export(context.Background(), "card", []SpanData{span}))
+}
+
+func TestMiddlewareNamesSpansAfterTheRoute(t *testing.T) {
+	exported := useTracer(t, 1)
+
+	var inHandler SpanContext
+	router := mux.NewRouter()
+	router.Use(Middleware)
+	router.HandleFunc("/card/{id}", func(w http.ResponseWriter, r *http.Request) {
+		inHandler = SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusInternalServerError)
+	}).Methods("PUT")
+
+	req := httptest.NewRequest(http.MethodPut, "/card/7", nil)
+	req.Header.Set(TraceparentHeader, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exported()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "PUT /card/{id}", span.Name)
+	assert.Equal(t, Server, span.Kind)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", span.SpanContext.TraceID.String())
+	assert.Equal(t, "b7ad6b7169203331", span.Parent.String())
+	assert.Equal(t, span.SpanContext, inHandler)
+	assert.Equal(t, "answered 500", span.Error)
+	assert.Contains(t, span.Attributes, slog.Int("http.response.status_code", http.StatusInternalServerError))
+}
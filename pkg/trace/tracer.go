@@ -0,0 +1,158 @@
+package trace
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Batching defaults
+const (
+	DefaultQueueSize     = 2048
+	DefaultBatchSize     = 512
+	DefaultFlushInterval = 5 * time.Second
+)
+
+// Exporter sends finished spans somewhere, service names the process they come from
+type Exporter interface {
+	Export(ctx context.Context, service string, spans []SpanData) error
+}
+
+// Options configure a tracer
+type Options struct {
+	// Service names the process in exported spans
+	Service string
+	// SampleRatio is the share of new traces recorded, traces continued from another process keep their decision
+	SampleRatio float64
+	Exporter    Exporter
+
+	// Spans are exported in batches of up to BatchSize, at least every FlushInterval.
+	// Spans ending while QueueSize spans wait are dropped.
+	QueueSize     int
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// Tracer records sampled spans and exports them in the background
+type Tracer struct {
+	opts    Options
+	queue   chan SpanData
+	done    chan struct{}
+	dropped atomic.Int64
+
+	// closing guards sends to queue against Shutdown closing it
+	closing sync.RWMutex
+	closed  bool
+}
+
+// NewTracer creates a tracer and starts its exporting goroutine, Shutdown stops it
+func NewTracer(opts Options) *Tracer {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	t := &Tracer{opts: opts, queue: make(chan SpanData, opts.QueueSize), done: make(chan struct{})}
+	go t.run()
+	return t
+}
+
+var defaultTracer atomic.Pointer[Tracer]
+
+// SetDefault makes t the tracer used by Start, nil turns recording off
+func SetDefault(t *Tracer) {
+	defaultTracer.Store(t)
+}
+
+// Default returns the tracer used by Start, nil when none is set
+func Default() *Tracer {
+	return defaultTracer.Load()
+}
+
+func (t *Tracer) sample() bool {
+	return t.opts.SampleRatio >= 1 || rand.Float64() < t.opts.SampleRatio
+}
+
+// export queues a finished span without blocking
+func (t *Tracer) export(span SpanData) {
+	t.closing.RLock()
+	defer t.closing.RUnlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- span:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// Dropped is the number of spans dropped because the queue was full
+func (t *Tracer) Dropped() int64 {
+	return t.dropped.Load()
+}
+
+// run exports queued spans in batches until the queue is closed, then exports what is left
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, t.opts.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), t.opts.FlushInterval)
+		defer cancel()
+		if err := t.opts.Exporter.Export(ctx, t.opts.Service, batch); err != nil {
+			slog.Error("Trace export error", "spans", len(batch), "error", err)
+		}
+		batch = make([]SpanData, 0, t.opts.BatchSize)
+	}
+
+	for {
+		select {
+		case span, ok := <-t.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= t.opts.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Shutdown exports the spans still queued and closes the exporter when it is an io.Closer.
+// Spans ending afterwards are not exported.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.closing.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.closing.Unlock()
+
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if closer, ok := t.opts.Exporter.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}